@@ -0,0 +1,31 @@
+package pusher
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// SignChannel returns the auth string for subscribing to a private or
+// presence channel, as Pusher's server libraries compute it, so that trusted
+// clients and tests can sign subscriptions themselves and pass the result to
+// WithAuth. The auth string has the form "key:signature", where the signature
+// is the hex-encoded HMAC-SHA256, keyed with the app secret, of the string
+// "socketID:channelName" for private channels, or
+// "socketID:channelName:channelData" for presence channels. channelData must
+// be empty for private channels, and for presence channels must be exactly the
+// channel data sent with the subscription, such as that returned by
+// BuildPresenceChannelData.
+//
+// The secret gives full access to the app, so it must never be embedded in
+// untrusted clients.
+func SignChannel(key, secret, socketID, channelName, channelData string) string {
+	toSign := socketID + ":" + channelName
+	if channelData != "" {
+		toSign += ":" + channelData
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(toSign))
+	return key + ":" + hex.EncodeToString(mac.Sum(nil))
+}
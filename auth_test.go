@@ -0,0 +1,25 @@
+package pusher
+
+import "testing"
+
+func TestSignChannel(t *testing.T) {
+	testCases := []struct {
+		name        string
+		channelName string
+		channelData string
+		want        string
+	}{
+		// The example from Pusher's authentication signature documentation
+		{"private", "private-foobar", "", "278d425bdf160c739803:58df8b0c36d6982b82c3ecf6b4662e34fe8c25bba48f5369f135bf843651c3a4"},
+		{"presence", "presence-foobar", `{"user_id":10,"user_info":{"name":"Mr. Channel"}}`, "278d425bdf160c739803:fc92c2263fd5b72721e20dd1a06a900e6b3c4fbfac6cb9b098d68eb7911498a2"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := SignChannel("278d425bdf160c739803", "7ad3773142a6692b25b8", "1234.1234", tc.channelName, tc.channelData)
+			if got != tc.want {
+				t.Errorf("Expected auth %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
@@ -7,18 +7,67 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"regexp"
+	"runtime/debug"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 )
 
+// ChannelType identifies the kind of a Pusher channel, which is determined by
+// the prefix of its name.
+type ChannelType int
+
+// Channel types
+const (
+	ChannelTypePublic ChannelType = iota
+	ChannelTypePrivate
+	ChannelTypePresence
+	ChannelTypePrivateEncrypted
+)
+
+func (t ChannelType) String() string {
+	switch t {
+	case ChannelTypePublic:
+		return "public"
+	case ChannelTypePrivate:
+		return "private"
+	case ChannelTypePresence:
+		return "presence"
+	case ChannelTypePrivateEncrypted:
+		return "private-encrypted"
+	default:
+		return fmt.Sprintf("ChannelType(%d)", int(t))
+	}
+}
+
+// channelTypeForName returns the type of the channel named name.
+func channelTypeForName(name string) ChannelType {
+	switch {
+	case strings.HasPrefix(name, "private-encrypted-"):
+		return ChannelTypePrivateEncrypted
+	case strings.HasPrefix(name, "private-"):
+		return ChannelTypePrivate
+	case strings.HasPrefix(name, "presence-"):
+		return ChannelTypePresence
+	default:
+		return ChannelTypePublic
+	}
+}
+
 // Channel represents a subscription to a Pusher channel.
 type Channel interface {
+	// Name returns the name of the channel
+	Name() string
+	// Type returns the type of the channel, as determined by its name
+	Type() ChannelType
 	// IsSubscribed indicates if the channel is currently subscribed
 	IsSubscribed() bool
 	// Subscribe attempts to subscribe to the channel if the subscription is not
 	// already active. Authentication will be attempted for private and presence
-	// channels.
+	// channels. Calls made while a subscription attempt is in progress wait for
+	// that attempt and return its result, ignoring their own options.
 	Subscribe(...SubscribeOption) error
 	// Unsubscribe attempts to unsubscribe from the channel. Note that a nil error
 	// does not mean that the unsubscription was successful, just that the request
@@ -28,13 +77,32 @@ type Channel interface {
 	// This is used during reconnection to ensure that a fresh subscribe message will be sent.
 	ResetSubscriptionState()
 	// Bind returns a channel to which all the data from all matching events received
-	// on the channel will be sent.
-	Bind(event string) chan json.RawMessage
+	// on the channel will be sent. Data may be delivered out of order unless
+	// WithOrderedDelivery is given.
+	Bind(event string, opts ...BindOption) chan json.RawMessage
 	// Unbind removes bindings for an event. If chans are passed, only those bindings
 	// will be removed. Otherwise, all bindings for an event will be removed.
 	Unbind(event string, chans ...chan json.RawMessage)
+	// BoundEvents returns the sorted names of the events that are currently
+	// bound on the channel.
+	BoundEvents() []string
 	// Trigger sends an event to the channel.
 	Trigger(event string, data interface{}) error
+	// SubscriptionCount returns the number of clients subscribed to the channel,
+	// as last reported by a subscription_count event. It is 0 if the channel has
+	// not opted into subscription counting.
+	SubscriptionCount() int
+	// BindSubscriptionCount registers a function that is called with the new
+	// count each time a subscription_count event is received. It is called in
+	// its own goroutine, so calls may happen out of order.
+	BindSubscriptionCount(func(count int))
+	// BindCacheMiss registers a function that is called when Pusher reports that
+	// a cache channel has no cached event. When a cached event exists, it is
+	// instead delivered to Bind like any other event after the subscription
+	// succeeds. The function is never called for channels that are not cache
+	// channels. It is called in its own goroutine, so it may block, such as to
+	// fetch the missing state, without holding up other events.
+	BindCacheMiss(func())
 }
 
 // internalChannel represents the Channel interface used internally
@@ -42,6 +110,12 @@ type internalChannel interface {
 	Channel
 
 	handleEvent(event string, data json.RawMessage)
+	// resubscribeOnReconnect reports whether the channel should be
+	// resubscribed after a reconnection, rather than dropped.
+	resubscribeOnReconnect() bool
+	// subscribedChan returns a channel that is closed once the channel is
+	// subscribed.
+	subscribedChan() <-chan struct{}
 }
 
 type boundDataChans map[chan json.RawMessage]chan struct{}
@@ -49,25 +123,92 @@ type boundDataChans map[chan json.RawMessage]chan struct{}
 type channel struct {
 	name        string
 	boundEvents map[string]boundDataChans
+	// orderedChans holds the delivery queues of bindings made with
+	// WithOrderedDelivery.
+	orderedChans map[chan json.RawMessage]*orderedQueue[json.RawMessage]
 	// TODO: implement global bindings
 	// globalBindings boundDataChans
 	client           *Client
 	subscribed       bool
 	subscribeSuccess chan struct{}
+	subscribeFailure chan error
+	// pendingSubscribe is the subscription attempt in progress, if any, which
+	// concurrent calls to Subscribe wait for instead of starting another.
+	pendingSubscribe *subscribeCall
 	// channelData is populated for authorized channels (presence and private
 	// channels). It's set by sendSubscriptionRequest. The channelData is invalid
 	// until subscribed is set to true.
 	channelData channelData
+	// noResubscribe is set if the channel was last subscribed with
+	// WithoutAutoResubscribe. It's set by sendSubscriptionRequest.
+	noResubscribe bool
+	// subscribedSignal is closed when subscribed is next set to true. It's
+	// created by subscribedChan.
+	subscribedSignal chan struct{}
+	// earlyEvents holds the events received without a binding before the
+	// subscription was confirmed, up to earlyEventsSize of them, until they
+	// are bound. The size is set by sendSubscriptionRequest from
+	// WithPreSubscriptionBuffer.
+	earlyEvents     []earlyEvent
+	earlyEventsSize int
+	// subscriptionCount is the count from the last subscription_count event.
+	subscriptionCount         int
+	subscriptionCountHandlers []func(int)
+	cacheMissHandlers         []func()
 
 	mutex sync.RWMutex
 }
 
+// subscribeCall is a subscription attempt shared by concurrent calls to
+// Subscribe. err is valid once done is closed.
+type subscribeCall struct {
+	done chan struct{}
+	err  error
+}
+
+// subscribeOnce runs subscribe unless a subscription attempt is already in
+// progress, in which case it waits for that attempt and returns its result, or
+// the channel has been subscribed to since the caller checked, in which case it
+// returns nil.
+func (c *channel) subscribeOnce(subscribe func() error) error {
+	c.mutex.Lock()
+	if call := c.pendingSubscribe; call != nil {
+		c.mutex.Unlock()
+		<-call.done
+		return call.err
+	}
+	if c.subscribed {
+		c.mutex.Unlock()
+		return nil
+	}
+	call := &subscribeCall{done: make(chan struct{})}
+	c.pendingSubscribe = call
+	c.mutex.Unlock()
+
+	call.err = subscribe()
+
+	c.mutex.Lock()
+	c.pendingSubscribe = nil
+	c.mutex.Unlock()
+	close(call.done)
+
+	return call.err
+}
+
 type channelData struct {
 	Channel     string          `json:"channel"`
 	Auth        string          `json:"auth,omitempty"`
 	ChannelData json.RawMessage `json:"channel_data,omitempty"`
 }
 
+func (c *channel) Name() string {
+	return c.name
+}
+
+func (c *channel) Type() ChannelType {
+	return channelTypeForName(c.name)
+}
+
 func (c *channel) IsSubscribed() bool {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
@@ -77,6 +218,53 @@ func (c *channel) IsSubscribed() bool {
 
 type subscribeOptions struct {
 	successTimeout time.Duration
+	auth           string
+	channelData    string
+	presenceData   *presenceMemberData
+	noResubscribe  bool
+	// earlyEventsSize is set by WithPreSubscriptionBuffer.
+	earlyEventsSize int
+	// queueWhenDisconnected is set by WithQueueWhenDisconnected.
+	queueWhenDisconnected bool
+}
+
+// presenceMemberData is the channel data that identifies the local member of a
+// presence channel.
+type presenceMemberData struct {
+	UserID   string      `json:"user_id"`
+	UserInfo interface{} `json:"user_info,omitempty"`
+}
+
+// BuildPresenceChannelData returns the channel data identifying a member of a
+// presence channel, in the form Pusher expects, such as
+// `{"user_id":"123","user_info":{"name":"foo"}}`. userInfo is encoded as JSON
+// and omitted if nil. It's meant for backends that compute auth signatures
+// themselves: the signature must cover the returned string exactly, and both
+// are then passed to WithAuth.
+func BuildPresenceChannelData(userID string, userInfo interface{}) (string, error) {
+	if userID == "" {
+		return "", errors.New("presence channel data requires a user ID")
+	}
+	data, err := json.Marshal(presenceMemberData{UserID: userID, UserInfo: userInfo})
+	if err != nil {
+		return "", fmt.Errorf("encoding presence data: %w", err)
+	}
+	return string(data), nil
+}
+
+func (c *channel) newSubscribeOptions(opts []SubscribeOption) *subscribeOptions {
+	o := &subscribeOptions{
+		successTimeout: defaultSuccessTimeout,
+	}
+	if c.client.SubscribeTimeout > 0 {
+		o.successTimeout = c.client.SubscribeTimeout
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
 }
 
 // SubscribeOption is a configuration option for subscribing to a channel
@@ -86,35 +274,111 @@ const defaultSuccessTimeout = 10 * time.Second
 
 // WithSuccessTimeout returns a SubscribeOption that sets the time that a subscription
 // request will wait for a success response from Pusher before timing out. The
-// default is 10 seconds.
+// default is Client.SubscribeTimeout, or 10 seconds if that is not set.
 func WithSuccessTimeout(d time.Duration) SubscribeOption {
 	return func(o *subscribeOptions) {
 		o.successTimeout = d
 	}
 }
 
-// ErrTimedOut is the error returned when there is a timeout waiting for a subscription
-// confirmation from Pusher
+// WithAuth returns a SubscribeOption that provides a precomputed auth signature
+// and channel data for a private or presence channel. When set, the request to
+// AuthURL is skipped and the given values are sent in the subscription request.
+// channelData should be empty for private channels.
+func WithAuth(auth, channelData string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.auth = auth
+		o.channelData = channelData
+	}
+}
+
+// WithPresenceData returns a SubscribeOption that provides the user ID and
+// info of the local member of a presence channel, which are sent as the channel
+// data in the subscription request in place of the channel data given to
+// WithAuth. Since the auth signature covers the channel data, it must be used
+// together with WithAuth.
+func WithPresenceData(userID string, userInfo interface{}) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.presenceData = &presenceMemberData{UserID: userID, UserInfo: userInfo}
+	}
+}
+
+// WithoutAutoResubscribe returns a SubscribeOption that drops the channel when
+// the client reconnects, instead of resubscribing to it. The channel must be
+// subscribed to again with Client.Subscribe if it is still needed.
+func WithoutAutoResubscribe() SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.noResubscribe = true
+	}
+}
+
+// WithQueueWhenDisconnected returns a SubscribeOption that lets
+// Client.Subscribe be called while the client isn't connected, such as before
+// Connect. Instead of failing with ErrNotConnected, the channel is registered
+// and returned with a nil error, and the subscription is sent once the client
+// connects. Use Client.WaitForSubscription to wait for it to succeed. The other
+// options only apply to subscriptions made while connected.
+func WithQueueWhenDisconnected() SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.queueWhenDisconnected = true
+	}
+}
+
+// WithPreSubscriptionBuffer returns a SubscribeOption that holds up to size
+// events received on the channel before Pusher confirms the subscription, so
+// that they aren't lost when nothing is bound to them yet, such as when events
+// are bound once Subscribe returns. Held events are replayed to the first
+// binding made for their event with Channel.Bind, in the order they were
+// received. With WithOrderedDelivery, they are delivered before any event
+// received after the binding is made. Events that already have a binding when
+// they arrive are delivered as usual. When more than size events are held, the
+// oldest is dropped and an error is sent to Client.Errors.
+func WithPreSubscriptionBuffer(size int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.earlyEventsSize = size
+	}
+}
+
+// earlyEvent is an event held by WithPreSubscriptionBuffer.
+type earlyEvent struct {
+	event string
+	data  json.RawMessage
+}
+
+// ErrTimedOut is the error returned when there is a timeout waiting for a
+// response from Pusher, such as a subscription confirmation
 var ErrTimedOut = errors.New("timed out")
 
 func (c *channel) sendSubscriptionRequest(data channelData, o *subscribeOptions) error {
 	c.mutex.Lock()
-	c.subscribeSuccess = make(chan struct{})
+	c.subscribeSuccess = make(chan struct{}, 1)
+	c.subscribeFailure = make(chan error, 1)
 	c.channelData = data
+	c.noResubscribe = o.noResubscribe
+	c.earlyEventsSize = o.earlyEventsSize
+	success, failure := c.subscribeSuccess, c.subscribeFailure
 	c.mutex.Unlock()
 
-	doneChan := make(chan error)
+	// Stop waiting if the connection is closed before a response arrives.
+	c.client.mutex.RLock()
+	connDone := c.client.done
+	c.client.mutex.RUnlock()
+
+	doneChan := make(chan error, 1)
 
 	go func() {
 		var err error
 
-		timer := time.NewTimer(o.successTimeout)
+		timer := c.client.newTimer(o.successTimeout)
 		defer timer.Stop()
 
 		select {
-		case <-c.subscribeSuccess:
+		case <-success:
 			err = nil
-		case <-timer.C:
+		case err = <-failure:
+		case <-connDone:
+			err = ErrNotConnected
+		case <-timer.C():
 			err = ErrTimedOut
 		}
 
@@ -128,7 +392,7 @@ func (c *channel) sendSubscriptionRequest(data channelData, o *subscribeOptions)
 
 	err := c.client.SendEvent(pusherSubscribe, data, "")
 	if err != nil {
-		return fmt.Errorf("error sending subscription request: %s", err)
+		return fmt.Errorf("error sending subscription request: %w", err)
 	}
 
 	return <-doneChan
@@ -139,22 +403,30 @@ func (c *channel) Subscribe(opts ...SubscribeOption) error {
 		return nil
 	}
 
-	o := &subscribeOptions{
-		successTimeout: defaultSuccessTimeout,
+	o := c.newSubscribeOptions(opts)
+	if o.auth != "" {
+		return fmt.Errorf("auth can only be provided for private and presence channels: %s", c.name)
 	}
-
-	for _, opt := range opts {
-		opt(o)
+	if o.presenceData != nil {
+		return fmt.Errorf("presence data can only be provided for presence channels: %s", c.name)
+	}
+	if !c.client.isConnected() {
+		return ErrNotConnected
 	}
 
-	return c.sendSubscriptionRequest(channelData{Channel: c.name}, o)
+	return c.subscribeOnce(func() error {
+		return c.sendSubscriptionRequest(channelData{Channel: c.name}, o)
+	})
 }
 
 func (c *channel) Unsubscribe() error {
 	c.mutex.Lock()
-	defer c.mutex.Unlock()
-
 	c.subscribed = false
+	c.earlyEvents = nil
+	c.mutex.Unlock()
+
+	// The request is sent once the lock is released, since the client's read
+	// loop takes it while holding the client's lock.
 	return c.client.SendEvent(pusherUnsubscribe, channelData{
 		Channel: c.name,
 	}, "")
@@ -167,28 +439,95 @@ func (c *channel) ResetSubscriptionState() {
 	c.subscribed = false
 }
 
-func (c *channel) Bind(event string) chan json.RawMessage {
+func (c *channel) Bind(event string, opts ...BindOption) chan json.RawMessage {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
 	boundChan := make(chan json.RawMessage)
+	doneChan := make(chan struct{})
 
+	if c.boundEvents == nil {
+		c.boundEvents = map[string]boundDataChans{}
+	}
 	if c.boundEvents[event] == nil {
 		c.boundEvents[event] = boundDataChans{}
 	}
 
-	c.boundEvents[event][boundChan] = make(chan struct{})
+	c.boundEvents[event][boundChan] = doneChan
+
+	if newBindOptions(opts).ordered {
+		if c.orderedChans == nil {
+			c.orderedChans = map[chan json.RawMessage]*orderedQueue[json.RawMessage]{}
+		}
+		c.orderedChans[boundChan] = newOrderedQueue(boundChan, doneChan, c.deliveries())
+	}
+	c.replayEarlyEventsLocked(event, boundChan, doneChan)
 
 	return boundChan
 }
 
+// replayEarlyEventsLocked delivers the held events named event to boundChan,
+// the first binding for the event, in the order they were received. The mutex
+// must be held by the caller.
+func (c *channel) replayEarlyEventsLocked(event string, boundChan chan json.RawMessage, doneChan chan struct{}) {
+	var replay []json.RawMessage
+	remaining := c.earlyEvents[:0]
+	for _, e := range c.earlyEvents {
+		if e.event == event {
+			replay = append(replay, e.data)
+		} else {
+			remaining = append(remaining, e)
+		}
+	}
+	c.earlyEvents = remaining
+	if len(replay) == 0 {
+		return
+	}
+
+	if queue := c.orderedChans[boundChan]; queue != nil {
+		for _, data := range replay {
+			queue.push(data)
+		}
+		return
+	}
+	deliver(c.deliveries(), func() {
+		for _, data := range replay {
+			select {
+			case boundChan <- data:
+			case <-doneChan:
+				return
+			}
+		}
+	})
+}
+
+// holdEarlyEventLocked holds event if it was received before the subscription
+// was confirmed, nothing is bound to it, and WithPreSubscriptionBuffer was
+// given. It reports whether the event was held. The mutex must be held by the
+// caller.
+func (c *channel) holdEarlyEventLocked(event string, data json.RawMessage) bool {
+	if c.subscribed || c.earlyEventsSize <= 0 || len(c.boundEvents[event]) > 0 ||
+		strings.HasPrefix(event, "pusher:") || strings.HasPrefix(event, "pusher_internal:") {
+		return false
+	}
+
+	if len(c.earlyEvents) >= c.earlyEventsSize {
+		dropped := c.earlyEvents[0]
+		c.earlyEvents = c.earlyEvents[1:]
+		c.client.sendError(fmt.Errorf("dropping %q event received before subscribing to %s: pre-subscription buffer full", dropped.event, c.name))
+	}
+	c.earlyEvents = append(c.earlyEvents, earlyEvent{event: event, data: data})
+	return true
+}
+
 func (c *channel) Unbind(event string, chans ...chan json.RawMessage) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
 	if len(chans) == 0 {
-		for _, doneChan := range c.boundEvents[event] {
+		for boundChan, doneChan := range c.boundEvents[event] {
 			close(doneChan)
+			delete(c.orderedChans, boundChan)
 		}
 		delete(c.boundEvents, event)
 		return
@@ -203,10 +542,153 @@ func (c *channel) Unbind(event string, chans ...chan json.RawMessage) {
 
 		close(doneChan)
 		delete(eventBoundChans, boundChan)
+		delete(c.orderedChans, boundChan)
+	}
+}
+
+func (c *channel) subscribedChan() <-chan struct{} {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.subscribed {
+		signal := make(chan struct{})
+		close(signal)
+		return signal
+	}
+	if c.subscribedSignal == nil {
+		c.subscribedSignal = make(chan struct{})
+	}
+	return c.subscribedSignal
+}
+
+func (c *channel) resubscribeOnReconnect() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return !c.noResubscribe
+}
+
+func (c *channel) BoundEvents() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	events := make([]string, 0, len(c.boundEvents))
+	for event, eventBoundChans := range c.boundEvents {
+		if len(eventBoundChans) > 0 {
+			events = append(events, event)
+		}
 	}
+	sort.Strings(events)
+	return events
+}
+
+// BindOption is a configuration option for binding to an event
+type BindOption func(*bindOptions)
+
+type bindOptions struct {
+	ordered bool
+}
+
+func newBindOptions(opts []BindOption) *bindOptions {
+	o := &bindOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithOrderedDelivery returns a BindOption that delivers events to the bound
+// channel in the order they were received. By default, each event is delivered
+// by its own goroutine, so events may arrive out of order. With ordered
+// delivery, events are queued until the receiver is ready for them.
+func WithOrderedDelivery() BindOption {
+	return func(o *bindOptions) {
+		o.ordered = true
+	}
+}
+
+// subscriptionErrorData is sent from Pusher to the client in the
+// subscription_error event.
+type subscriptionErrorData struct {
+	Type   string `json:"type"`
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+}
+
+// subscriptionCountData is sent from Pusher to the client in the
+// subscription_count event.
+type subscriptionCountData struct {
+	SubscriptionCount int `json:"subscription_count"`
 }
 
 func (c *channel) handleEvent(event string, data json.RawMessage) {
+	if event == pusherInternalSubError {
+		var errData subscriptionErrorData
+		if err := UnmarshalAuto(data, &errData); err != nil {
+			c.client.sendError(fmt.Errorf("decoding subscription error event data: %w", err))
+		}
+		subErr := SubscriptionError{
+			Channel: c.name,
+			Type:    errData.Type,
+			Code:    errData.Status,
+			Message: errData.Error,
+		}
+
+		c.mutex.Lock()
+		c.subscribed = false
+		failure := c.subscribeFailure
+		c.mutex.Unlock()
+
+		// try to send on the channel, but don't block if nothing is listening
+		select {
+		case failure <- subErr:
+		default:
+		}
+
+		c.client.sendError(subErr)
+
+		event = pusherSubError
+	}
+
+	if event == pusherInternalSubCount {
+		var countData subscriptionCountData
+		if err := UnmarshalAuto(data, &countData); err != nil {
+			c.client.sendError(fmt.Errorf("decoding subscription count event data: %w", err))
+			return
+		}
+
+		c.mutex.Lock()
+		c.subscriptionCount = countData.SubscriptionCount
+		handlers := make([]func(int), len(c.subscriptionCountHandlers))
+		copy(handlers, c.subscriptionCountHandlers)
+		c.mutex.Unlock()
+
+		// The handlers run in their own goroutines, since the client's lock is
+		// held and they may call its methods.
+		for _, handler := range handlers {
+			deliver(c.deliveries(), func() {
+				c.callHandler(pusherSubCount, func() { handler(countData.SubscriptionCount) })
+			})
+		}
+
+		event = pusherSubCount
+	}
+
+	if event == pusherCacheMiss && isCacheChannel(c.name) {
+		c.mutex.RLock()
+		handlers := make([]func(), len(c.cacheMissHandlers))
+		copy(handlers, c.cacheMissHandlers)
+		c.mutex.RUnlock()
+
+		// As for subscription counts, and so that a handler fetching the
+		// missing state doesn't hold up the read loop
+		for _, handler := range handlers {
+			deliver(c.deliveries(), func() {
+				c.callHandler(pusherCacheMiss, handler)
+			})
+		}
+	}
+
 	if event == pusherInternalSubSucceeded {
 		// try to send on the channel, but don't block if nothing is listening
 		select {
@@ -216,46 +698,204 @@ func (c *channel) handleEvent(event string, data json.RawMessage) {
 
 		c.mutex.Lock()
 		c.subscribed = true
+		if c.subscribedSignal != nil {
+			close(c.subscribedSignal)
+			c.subscribedSignal = nil
+		}
 		c.mutex.Unlock()
 
 		event = pusherSubSucceeded
 	}
 
-	c.mutex.RLock()
-	sendDataMessage(c.boundEvents[event], data)
-	c.mutex.RUnlock()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.holdEarlyEventLocked(event, data) {
+		return
+	}
+	sendDataMessage(c.boundEvents[event], c.orderedChans, c.deliveries(), data)
 }
 
-func sendDataMessage(channels boundDataChans, data json.RawMessage) {
+// sendDataMessage sends data to each of channels, through its delivery queue
+// in ordered if it has one. Deliveries are counted in pending if it isn't nil.
+func sendDataMessage(channels boundDataChans, ordered map[chan json.RawMessage]*orderedQueue[json.RawMessage], pending *deliveryCounter, data json.RawMessage) {
 	for boundChan, doneChan := range channels {
-		go func(boundChan chan json.RawMessage, data json.RawMessage, doneChan chan struct{}) {
+		if queue := ordered[boundChan]; queue != nil {
+			queue.push(data)
+			continue
+		}
+		deliver(pending, func() {
 			select {
 			case boundChan <- data:
 			case <-doneChan:
 			}
-		}(boundChan, data, doneChan)
+		})
+	}
+}
+
+// deliveries returns the counter of the client's pending deliveries, or nil if
+// the channel has no client.
+func (c *channel) deliveries() *deliveryCounter {
+	if c.client == nil {
+		return nil
 	}
+	return &c.client.deliveries
+}
+
+// callHandler calls handler, a function registered for event, reporting a panic
+// on the client's Errors.
+func (c *channel) callHandler(event string, handler func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			if c.client == nil {
+				panic(r)
+			}
+			c.client.sendError(fmt.Errorf("recovered from panic in %q handler of channel %q: %v\n%s", event, c.name, r, debug.Stack()))
+		}
+	}()
+
+	handler()
 }
 
 func (c *channel) Trigger(event string, data interface{}) error {
 	return c.client.SendEvent(event, data, c.name)
 }
 
+func (c *channel) BindCacheMiss(handler func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.cacheMissHandlers = append(c.cacheMissHandlers, handler)
+}
+
+// The maximum length of a channel name accepted by Pusher
+const maxChannelNameLength = 200
+
+// ErrInvalidChannelName is returned when subscribing to a channel whose name
+// isn't accepted by Pusher.
+var ErrInvalidChannelName = errors.New("invalid channel name")
+
+var channelNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_\-=@,.;]+$`)
+
+// ValidateChannelName checks that name is a channel name accepted by Pusher. It
+// must be at most 200 characters long, contain only letters, digits and the
+// characters _ - = @ , . ; and not consist of only a channel type prefix. The
+// error returned wraps ErrInvalidChannelName.
+func ValidateChannelName(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: name is empty", ErrInvalidChannelName)
+	}
+	if len(name) > maxChannelNameLength {
+		return fmt.Errorf("%w: %q is longer than %d characters", ErrInvalidChannelName, name, maxChannelNameLength)
+	}
+	if !channelNameRegexp.MatchString(name) {
+		return fmt.Errorf("%w: %q contains characters other than A-Z, a-z, 0-9, _, -, =, @, comma, . and ;", ErrInvalidChannelName, name)
+	}
+	for _, prefix := range []string{"private-", "private-encrypted-", "presence-", "cache-", "private-cache-", "private-encrypted-cache-", "presence-cache-"} {
+		if name == prefix {
+			return fmt.Errorf("%w: %q has no name after the channel type prefix", ErrInvalidChannelName, name)
+		}
+	}
+	return nil
+}
+
+// isCacheChannel reports whether name is a cache channel, including private,
+// presence, and encrypted cache channels.
+func isCacheChannel(name string) bool {
+	for _, prefix := range []string{"cache-", "private-cache-", "private-encrypted-cache-", "presence-cache-"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *channel) SubscriptionCount() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.subscriptionCount
+}
+
+func (c *channel) BindSubscriptionCount(handler func(count int)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.subscriptionCountHandlers = append(c.subscriptionCountHandlers, handler)
+}
+
 type privateChannel struct {
 	*channel
 }
 
-// An AuthError is returned when a non-200 status code is returned in a channel
-// subscription authentication request.
+// An AuthError is returned when a channel subscription authentication request
+// fails, either because a non-200 status code was returned or because the
+// request could not be completed or its response decoded.
 type AuthError struct {
 	Status int
 	Body   string
+	// Err is the underlying cause when the request failed without a response,
+	// or the response body could not be decoded.
+	Err error
 }
 
 func (e AuthError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("Auth error: %s", e.Err)
+	}
 	return fmt.Sprintf("Auth error: status code %d, response body: %q", e.Status, e.Body)
 }
 
+func (e AuthError) Unwrap() error {
+	return e.Err
+}
+
+// A SubscriptionError is returned when Pusher rejects a channel subscription.
+type SubscriptionError struct {
+	Channel string
+	// Type is the kind of error reported by Pusher, such as "AuthError".
+	Type string
+	// Code is the status code reported by Pusher.
+	Code    int
+	Message string
+	// Err is the underlying error, if any.
+	Err error
+}
+
+func (e SubscriptionError) Error() string {
+	return fmt.Sprintf("Subscription error: channel %q, code %d, message %q", e.Channel, e.Code, e.Message)
+}
+
+func (e SubscriptionError) Unwrap() error {
+	return e.Err
+}
+
+// A SubscribeManyError is returned by Client.SubscribeMany when subscribing
+// to some of the channels fails. It maps the name of each channel that failed
+// to its error.
+type SubscribeManyError map[string]error
+
+func (e SubscribeManyError) Error() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = fmt.Sprintf("%s: %v", name, e[name])
+	}
+	return fmt.Sprintf("Subscription failed for %d channels: %s", len(e), strings.Join(msgs, "; "))
+}
+
+func (e SubscribeManyError) Unwrap() []error {
+	errs := make([]error, 0, len(e))
+	for _, err := range e {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
 func (c *privateChannel) ResetSubscriptionState() {
 	c.channel.ResetSubscriptionState()
 }
@@ -264,39 +904,118 @@ func (c *privateChannel) Subscribe(opts ...SubscribeOption) error {
 	if c.IsSubscribed() {
 		return nil
 	}
+	if !c.client.isConnected() {
+		return ErrNotConnected
+	}
 
-	o := &subscribeOptions{
-		successTimeout: defaultSuccessTimeout,
+	o := c.newSubscribeOptions(opts)
+	if o.presenceData != nil {
+		if c.Type() != ChannelTypePresence {
+			return fmt.Errorf("presence data can only be provided for presence channels: %s", c.name)
+		}
+		if o.auth == "" {
+			return fmt.Errorf("presence data must be provided with an auth signature: %s", c.name)
+		}
+		data, err := BuildPresenceChannelData(o.presenceData.UserID, o.presenceData.UserInfo)
+		if err != nil {
+			return err
+		}
+		o.channelData = data
 	}
 
-	for _, opt := range opts {
-		opt(o)
+	return c.subscribeOnce(func() error {
+		var chanData channelData
+		if o.auth != "" {
+			chanData.Auth = o.auth
+			if o.channelData != "" {
+				data, err := json.Marshal(o.channelData)
+				if err != nil {
+					return err
+				}
+				chanData.ChannelData = data
+			}
+		} else {
+			var err error
+			chanData, err = c.authorizeWithRetry()
+			if err != nil {
+				return err
+			}
+		}
+		chanData.Channel = c.name
+
+		return c.sendSubscriptionRequest(chanData, o)
+	})
+}
+
+// authorizeWithRetry requests the auth signature for the channel, retrying
+// transient failures up to AuthRetries times with exponential backoff. It
+// returns ErrNotConnected if the connection is closed while waiting to retry.
+func (c *privateChannel) authorizeWithRetry() (channelData, error) {
+	backoff := c.client.AuthRetryBackoff
+	if backoff <= 0 {
+		backoff = defaultAuthRetryBackoff
+	}
+
+	// Stop retrying if the connection is closed, since the signature is tied
+	// to its socket ID.
+	c.client.mutex.RLock()
+	closed, connDone := c.client.closed, c.client.done
+	c.client.mutex.RUnlock()
+
+	for attempt := 0; ; attempt++ {
+		chanData, retryable, err := c.authorize()
+		if err == nil || !retryable || attempt >= c.client.AuthRetries {
+			return chanData, err
+		}
+
+		timer := c.client.newTimer(backoff)
+		select {
+		case <-timer.C():
+		case <-closed:
+			timer.Stop()
+			return channelData{}, ErrNotConnected
+		case <-connDone:
+			timer.Stop()
+			return channelData{}, ErrNotConnected
+		}
+		backoff *= 2
 	}
+}
+
+// authorize requests the auth signature for the channel from AuthURL. The
+// returned bool indicates whether a failure is transient and may be retried.
+func (c *privateChannel) authorize() (channelData, bool, error) {
+	authURL, authParams, authHeaders := c.client.authConfig()
 
 	body := url.Values{}
-	body.Set("socket_id", c.client.socketID)
+	body.Set("socket_id", c.client.SocketID())
 	body.Set("channel_name", c.name)
-	for key, vals := range c.client.AuthParams {
+	for key, vals := range authParams {
 		for _, val := range vals {
 			body.Add(key, val)
 		}
 	}
 
-	req, err := http.NewRequest(http.MethodPost, c.client.AuthURL, strings.NewReader(body.Encode()))
+	req, err := http.NewRequest(http.MethodPost, authURL, strings.NewReader(body.Encode()))
 	if err != nil {
-		return err
+		return channelData{}, false, AuthError{Err: err}
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	for key, vals := range c.client.AuthHeaders {
+	for key, vals := range authHeaders {
 		for _, val := range vals {
 			req.Header.Add(key, val)
 		}
 	}
+	if intercept := c.client.AuthRequestInterceptor; intercept != nil {
+		if err := intercept(req); err != nil {
+			return channelData{}, false, AuthError{Err: err}
+		}
+	}
 
 	res, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return err
+		return channelData{}, true, AuthError{Err: err}
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
@@ -309,7 +1028,10 @@ func (c *privateChannel) Subscribe(opts ...SubscribeOption) error {
 			bodyStr = string(body)
 		}
 
-		return AuthError{
+		retryable := res.StatusCode >= http.StatusInternalServerError ||
+			res.StatusCode == http.StatusTooManyRequests
+
+		return channelData{}, retryable, AuthError{
 			Status: res.StatusCode,
 			Body:   bodyStr,
 		}
@@ -317,9 +1039,11 @@ func (c *privateChannel) Subscribe(opts ...SubscribeOption) error {
 
 	chanData := channelData{}
 	if err = json.NewDecoder(res.Body).Decode(&chanData); err != nil {
-		return err
+		return channelData{}, false, AuthError{
+			Status: res.StatusCode,
+			Err:    fmt.Errorf("decoding response body: %w", err),
+		}
 	}
-	chanData.Channel = c.name
 
-	return c.sendSubscriptionRequest(chanData, o)
+	return chanData, false, nil
 }
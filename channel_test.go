@@ -1,11 +1,17 @@
 package pusher
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -62,7 +68,7 @@ func TestChannelSubscribe(t *testing.T) {
 			name:       wantChannel,
 			subscribed: false,
 			client: &Client{
-				ws:        ws,
+				ws:        websocketConn{ws},
 				connected: true,
 			},
 		}
@@ -89,7 +95,7 @@ func TestChannelSubscribe(t *testing.T) {
 
 		ch := &channel{
 			client: &Client{
-				ws:        ws,
+				ws:        websocketConn{ws},
 				connected: true,
 			},
 		}
@@ -110,6 +116,116 @@ func TestChannelSubscribe(t *testing.T) {
 			t.Errorf("Expected to get error %s, got %v", ErrTimedOut, err)
 		}
 	})
+
+	t.Run("withAuth", func(t *testing.T) {
+		ch := &channel{name: "foo", client: &Client{}}
+
+		err := ch.Subscribe(WithAuth("bar", ""))
+		if err == nil {
+			t.Errorf("Expected an error providing auth for a public channel, got nil")
+		}
+	})
+
+	t.Run("subscribeRejected", func(t *testing.T) {
+		wantChannel := "foo"
+
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			var event Event
+			err := websocket.JSON.Receive(ws, &event)
+			if err != nil {
+				panic(err)
+			}
+
+			data, err := json.Marshal(`{"type":"AuthError","error":"forbidden","status":403}`)
+			if err != nil {
+				panic(err)
+			}
+			err = websocket.JSON.Send(ws, Event{
+				Event:   pusherInternalSubError,
+				Channel: wantChannel,
+				Data:    data,
+			})
+			if err != nil {
+				panic(err)
+			}
+		}))
+		defer srv.Close()
+		wsURL := strings.Replace(srv.URL, "http", "ws", 1)
+		ws, err := websocket.Dial(wsURL, "ws", localOrigin)
+		if err != nil {
+			panic(err)
+		}
+
+		ch := &channel{
+			name:        wantChannel,
+			boundEvents: map[string]boundDataChans{},
+			client: &Client{
+				ws:        websocketConn{ws},
+				connected: true,
+				Errors:    make(chan error, 1),
+			},
+		}
+		ch.client.subscribedChannels = subscribedChannels{wantChannel: ch}
+		defer ch.client.Disconnect()
+
+		go ch.client.listen()
+
+		err = ch.Subscribe(WithSuccessTimeout(time.Second))
+		var subErr SubscriptionError
+		if !errors.As(err, &subErr) {
+			t.Fatalf("Expected a SubscriptionError, got %v", err)
+		}
+		if subErr.Channel != wantChannel || subErr.Code != 403 || subErr.Type != "AuthError" || subErr.Message != "forbidden" {
+			t.Errorf("Unexpected subscription error %+v", subErr)
+		}
+		if ch.IsSubscribed() {
+			t.Errorf("Expected channel not to be subscribed after rejection")
+		}
+		if gotErr := <-ch.client.Errors; !errors.As(gotErr, &subErr) {
+			t.Errorf("Expected a SubscriptionError on the Errors channel, got %v", gotErr)
+		}
+	})
+
+	t.Run("clientSubscribeTimeout", func(t *testing.T) {
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {}))
+		defer srv.Close()
+		wsURL := strings.Replace(srv.URL, "http", "ws", 1)
+		ws, err := websocket.Dial(wsURL, "ws", localOrigin)
+		if err != nil {
+			panic(err)
+		}
+
+		ch := &channel{
+			client: &Client{
+				ws:               websocketConn{ws},
+				connected:        true,
+				SubscribeTimeout: 10 * time.Millisecond,
+			},
+		}
+		defer ch.client.Disconnect()
+
+		start := time.Now()
+		err = ch.Subscribe()
+		if err != ErrTimedOut {
+			t.Errorf("Expected to get error %s, got %v", ErrTimedOut, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected to time out after %s, waited %s", ch.client.SubscribeTimeout, elapsed)
+		}
+	})
+}
+
+func TestChannelSubscribeOnce(t *testing.T) {
+	// A caller may see that the channel isn't subscribed just before an
+	// attempt in progress succeeds.
+	ch := &channel{name: "foo", subscribed: true}
+	err := ch.subscribeOnce(func() error {
+		t.Error("Expected no new subscription attempt once subscribed")
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
 }
 
 func TestChannelUnsubscribe(t *testing.T) {
@@ -142,7 +258,8 @@ func TestChannelUnsubscribe(t *testing.T) {
 		name:       "foo",
 		subscribed: true,
 		client: &Client{
-			ws: ws,
+			ws:        websocketConn{ws},
+			connected: true,
 		},
 	}
 	defer ch.client.Disconnect()
@@ -159,6 +276,47 @@ func TestChannelUnsubscribe(t *testing.T) {
 	wg.Wait()
 }
 
+func TestChannelUnsubscribeConcurrentEvents(t *testing.T) {
+	conn := newFakeConn()
+	ch := &channel{name: "foo", subscribed: true}
+	client := &Client{
+		ws:                 conn,
+		connected:          true,
+		subscribedChannels: subscribedChannels{"foo": ch},
+	}
+	ch.client = client
+	defer client.Disconnect()
+
+	go client.listen()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case conn.received <- []byte(`{"event":"bar","channel":"foo","data":"{}"}`):
+			case <-conn.sent:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	// Unsubscribing must not deadlock with the read loop delivering events
+	// to the channel.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10000; i++ {
+			ch.Unsubscribe()
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Unsubscribe not to deadlock with incoming events")
+	}
+}
+
 func TestChannelBind(t *testing.T) {
 	ch := &channel{boundEvents: map[string]boundDataChans{}}
 	boundChan := ch.Bind("foo")
@@ -173,6 +331,55 @@ func TestChannelBind(t *testing.T) {
 	}
 }
 
+func TestChannelBindOrdered(t *testing.T) {
+	ch := &channel{boundEvents: map[string]boundDataChans{}}
+	boundChan := ch.Bind("foo", WithOrderedDelivery())
+
+	for i := 0; i < 100; i++ {
+		ch.handleEvent("foo", json.RawMessage(strconv.Itoa(i)))
+	}
+
+	for i := 0; i < 100; i++ {
+		select {
+		case data := <-boundChan:
+			if want := strconv.Itoa(i); string(data) != want {
+				t.Fatalf("Expected data %s, got %s", want, data)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected to receive data %d", i)
+		}
+	}
+
+	ch.Unbind("foo")
+	if len(ch.orderedChans) != 0 {
+		t.Errorf("Expected Unbind to stop the delivery queue, got %+v", ch.orderedChans)
+	}
+}
+
+func TestChannelBoundEvents(t *testing.T) {
+	ch := &channel{}
+	if events := ch.BoundEvents(); len(events) != 0 {
+		t.Errorf("Expected no bound events, got %v", events)
+	}
+
+	ch.Bind("foo")
+	barChan := ch.Bind("bar")
+	ch.Bind("baz")
+	ch.Unbind("baz")
+
+	want := []string{"bar", "foo"}
+	events := ch.BoundEvents()
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("Expected bound events %v, got %v", want, events)
+	}
+
+	events[0] = "qux"
+	ch.Unbind("bar", barChan)
+	if events := ch.BoundEvents(); !reflect.DeepEqual(events, []string{"foo"}) {
+		t.Errorf("Expected bound events [foo], got %v", events)
+	}
+}
+
 func TestChannelUnbind(t *testing.T) {
 	t.Run("eventOnly", func(t *testing.T) {
 		ch := &channel{boundEvents: map[string]boundDataChans{
@@ -215,6 +422,135 @@ func TestChannelUnbind(t *testing.T) {
 			t.Errorf("Expected data bound channels to contain ch3, got %+v instead", dataBoundChans)
 		}
 	})
+
+	t.Run("stopsDelivery", func(t *testing.T) {
+		ch := &channel{client: &Client{}}
+		ch1 := ch.Bind("foo")
+		ch2 := ch.Bind("foo")
+
+		// The delivery to ch1 is pending until ch1 is unbound.
+		ch.handleEvent("foo", json.RawMessage(`"bar"`))
+		ch.Unbind("foo", ch1)
+
+		select {
+		case <-ch2:
+		case <-time.After(time.Second):
+			t.Fatal("Expected data to be delivered to the remaining binding")
+		}
+		select {
+		case <-ch.client.deliveries.idle():
+		case <-time.After(time.Second):
+			t.Fatal("Expected the pending delivery to ch1 to be abandoned")
+		}
+
+		ch.handleEvent("foo", json.RawMessage(`"baz"`))
+		ch.Unbind("foo", ch2)
+		select {
+		case data := <-ch1:
+			t.Errorf("Expected no data on the unbound channel, got %s", data)
+		case <-ch.client.deliveries.idle():
+		case <-time.After(time.Second):
+			t.Fatal("Expected the pending delivery to ch2 to be abandoned")
+		}
+		if events := ch.BoundEvents(); len(events) != 0 {
+			t.Errorf("Expected no bound events once all bindings are removed, got %v", events)
+		}
+	})
+}
+
+func TestChannelPreSubscriptionBuffer(t *testing.T) {
+	newChannel := func(size int) *channel {
+		ch := &channel{
+			name:        "foo",
+			boundEvents: map[string]boundDataChans{},
+			client:      &Client{Errors: make(chan error, 10)},
+		}
+		ch.earlyEventsSize = ch.newSubscribeOptions([]SubscribeOption{WithPreSubscriptionBuffer(size)}).earlyEventsSize
+		return ch
+	}
+
+	receive := func(t *testing.T, dataChan chan json.RawMessage, want string) {
+		t.Helper()
+		select {
+		case data := <-dataChan:
+			if string(data) != want {
+				t.Errorf("Expected data %s, got %s", want, data)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected to receive %s", want)
+		}
+	}
+
+	t.Run("replayedOnBind", func(t *testing.T) {
+		ch := newChannel(3)
+		ch.handleEvent("bar", json.RawMessage(`1`))
+		ch.handleEvent("bar", json.RawMessage(`2`))
+		ch.handleEvent("baz", json.RawMessage(`3`))
+		ch.handleEvent(pusherInternalSubSucceeded, nil)
+		// Events received once subscribed aren't held.
+		ch.handleEvent("bar", json.RawMessage(`4`))
+
+		dataChan := ch.Bind("bar", WithOrderedDelivery())
+		ch.handleEvent("bar", json.RawMessage(`5`))
+		receive(t, dataChan, `1`)
+		receive(t, dataChan, `2`)
+		receive(t, dataChan, `5`)
+
+		// Held events are only replayed to the first binding.
+		if len(ch.earlyEvents) != 1 || ch.earlyEvents[0].event != "baz" {
+			t.Errorf("Expected only the baz event to remain held, got %+v", ch.earlyEvents)
+		}
+	})
+
+	t.Run("unordered", func(t *testing.T) {
+		ch := newChannel(3)
+		ch.handleEvent("bar", json.RawMessage(`1`))
+		ch.handleEvent("bar", json.RawMessage(`2`))
+
+		dataChan := ch.Bind("bar")
+		receive(t, dataChan, `1`)
+		receive(t, dataChan, `2`)
+	})
+
+	t.Run("full", func(t *testing.T) {
+		ch := newChannel(1)
+		ch.handleEvent("bar", json.RawMessage(`1`))
+		ch.handleEvent("bar", json.RawMessage(`2`))
+
+		select {
+		case err := <-ch.client.Errors:
+			if !strings.Contains(err.Error(), `"bar"`) {
+				t.Errorf("Expected the error to name the dropped event, got %v", err)
+			}
+		default:
+			t.Error("Expected an error for the dropped event")
+		}
+
+		dataChan := ch.Bind("bar", WithOrderedDelivery())
+		receive(t, dataChan, `2`)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		ch := newChannel(0)
+		ch.handleEvent("bar", json.RawMessage(`1`))
+
+		dataChan := ch.Bind("bar")
+		select {
+		case data := <-dataChan:
+			t.Errorf("Expected no event to be held, got %s", data)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("unsubscribe", func(t *testing.T) {
+		ch := newChannel(1)
+		ch.handleEvent("bar", json.RawMessage(`1`))
+		ch.Unsubscribe()
+
+		if len(ch.earlyEvents) != 0 {
+			t.Errorf("Expected held events to be discarded on unsubscribe, got %+v", ch.earlyEvents)
+		}
+	})
 }
 
 func TestChannelHandleEvent(t *testing.T) {
@@ -247,6 +583,89 @@ func TestChannelHandleEvent(t *testing.T) {
 			t.Errorf("Expected to channel subscribed to be true, got false")
 		}
 	})
+
+	t.Run("subscriptionCount", func(t *testing.T) {
+		dataChan := make(chan json.RawMessage)
+		ch := &channel{
+			boundEvents: map[string]boundDataChans{
+				pusherSubCount: {dataChan: make(chan struct{})},
+			},
+		}
+
+		counts := make(chan int, 1)
+		ch.BindSubscriptionCount(func(count int) { counts <- count })
+
+		data, err := json.Marshal(`{"subscription_count":3}`)
+		if err != nil {
+			panic(err)
+		}
+		ch.handleEvent(pusherInternalSubCount, data)
+
+		if count := ch.SubscriptionCount(); count != 3 {
+			t.Errorf("Expected subscription count to be 3, got %d", count)
+		}
+		select {
+		case gotCount := <-counts:
+			if gotCount != 3 {
+				t.Errorf("Expected subscription count handler to be called with 3, got %d", gotCount)
+			}
+		case <-time.After(time.Second):
+			t.Error("Expected subscription count handler to be called")
+		}
+		if gotData := <-dataChan; !reflect.DeepEqual(gotData, json.RawMessage(data)) {
+			t.Errorf("Expected to receive data %s, got %s", data, gotData)
+		}
+	})
+
+	t.Run("subscriptionCountSingleEncoded", func(t *testing.T) {
+		ch := &channel{}
+
+		counts := make(chan int, 1)
+		ch.BindSubscriptionCount(func(count int) { counts <- count })
+		ch.handleEvent(pusherInternalSubCount, json.RawMessage(`{"subscription_count":3}`))
+
+		if count := ch.SubscriptionCount(); count != 3 {
+			t.Errorf("Expected subscription count to be 3, got %d", count)
+		}
+		select {
+		case gotCount := <-counts:
+			if gotCount != 3 {
+				t.Errorf("Expected subscription count handler to be called with 3, got %d", gotCount)
+			}
+		case <-time.After(time.Second):
+			t.Error("Expected subscription count handler to be called")
+		}
+	})
+
+	t.Run("cacheMiss", func(t *testing.T) {
+		ch := &channel{name: "cache-foo"}
+
+		called := make(chan struct{})
+		ch.BindCacheMiss(func() { close(called) })
+
+		ch.handleEvent(pusherCacheMiss, nil)
+
+		select {
+		case <-called:
+		case <-time.After(time.Second):
+			t.Errorf("Expected cache miss handler to be called")
+		}
+	})
+
+	t.Run("cacheMissNonCacheChannel", func(t *testing.T) {
+		ch := &channel{name: "foo"}
+
+		called := make(chan struct{})
+		ch.BindCacheMiss(func() { close(called) })
+
+		ch.handleEvent(pusherCacheMiss, nil)
+
+		select {
+		case <-called:
+			t.Errorf("Expected cache miss handler not to be called for a non-cache channel")
+		case <-time.After(20 * time.Millisecond):
+		}
+	})
 }
 
 func TestChannelTrigger(t *testing.T) {
@@ -277,7 +696,8 @@ func TestChannelTrigger(t *testing.T) {
 	}
 
 	client := &Client{
-		ws:                 ws,
+		ws:                 websocketConn{ws},
+		connected:          true,
 		activityTimerReset: make(chan struct{}, 1),
 	}
 	defer client.Disconnect()
@@ -295,6 +715,67 @@ func TestChannelTrigger(t *testing.T) {
 	wg.Wait()
 }
 
+func TestChannelType(t *testing.T) {
+	testCases := []struct {
+		channel    string
+		want       ChannelType
+		wantString string
+	}{
+		{"foo", ChannelTypePublic, "public"},
+		{"cache-foo", ChannelTypePublic, "public"},
+		{"private-foo", ChannelTypePrivate, "private"},
+		{"private-cache-foo", ChannelTypePrivate, "private"},
+		{"presence-foo", ChannelTypePresence, "presence"},
+		{"private-encrypted-foo", ChannelTypePrivateEncrypted, "private-encrypted"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.channel, func(t *testing.T) {
+			ch := &channel{name: tc.channel}
+			if ch.Name() != tc.channel {
+				t.Errorf("Expected name %q, got %q", tc.channel, ch.Name())
+			}
+			if got := ch.Type(); got != tc.want {
+				t.Errorf("Expected type %v, got %v", tc.want, got)
+			}
+			if got := ch.Type().String(); got != tc.wantString {
+				t.Errorf("Expected type string %q, got %q", tc.wantString, got)
+			}
+		})
+	}
+}
+
+func TestValidateChannelName(t *testing.T) {
+	testCases := []struct {
+		name    string
+		channel string
+		valid   bool
+	}{
+		{"public", "foo-bar_baz=@,.;", true},
+		{"private", "private-foo", true},
+		{"presence", "presence-foo", true},
+		{"encrypted", "private-encrypted-foo", true},
+		{"maxLength", strings.Repeat("a", maxChannelNameLength), true},
+		{"empty", "", false},
+		{"tooLong", strings.Repeat("a", maxChannelNameLength+1), false},
+		{"invalidCharacters", "foo bar", false},
+		{"serverToUser", "#server-to-user-foo", false},
+		{"prefixOnly", "private-", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateChannelName(tc.channel)
+			if tc.valid && err != nil {
+				t.Errorf("Expected %q to be valid, got %v", tc.channel, err)
+			}
+			if !tc.valid && !errors.Is(err, ErrInvalidChannelName) {
+				t.Errorf("Expected %q to be invalid with %v, got %v", tc.channel, ErrInvalidChannelName, err)
+			}
+		})
+	}
+}
+
 func TestAuthErrorError(t *testing.T) {
 	err := AuthError{
 		Status: 123,
@@ -309,6 +790,88 @@ func TestAuthErrorError(t *testing.T) {
 	}
 }
 
+func TestAuthErrorUnwrap(t *testing.T) {
+	wantErr := errors.New("foo")
+	err := AuthError{Err: wantErr}
+	if !errors.Is(err, wantErr) {
+		t.Errorf("Expected auth error to wrap %v, got %v", wantErr, errors.Unwrap(err))
+	}
+	if errMsg := err.Error(); !strings.Contains(errMsg, "foo") {
+		t.Errorf("Expected error message to contain 'foo', got %s", errMsg)
+	}
+}
+
+func TestBuildPresenceChannelData(t *testing.T) {
+	testCases := []struct {
+		name     string
+		userID   string
+		userInfo interface{}
+		want     string
+	}{
+		{"withInfo", "123", map[string]string{"name": "foo"}, `{"user_id":"123","user_info":{"name":"foo"}}`},
+		{"withoutInfo", "123", nil, `{"user_id":"123"}`},
+		{"structInfo", "123", struct {
+			Name string `json:"name"`
+		}{"foo"}, `{"user_id":"123","user_info":{"name":"foo"}}`},
+		{"escaped", `a"b`, "<c>", `{"user_id":"a\"b","user_info":"\u003cc\u003e"}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := BuildPresenceChannelData(tc.userID, tc.userInfo)
+			if err != nil {
+				t.Fatalf("Expected error to be `nil`, got %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("Expected channel data %s, got %s", tc.want, got)
+			}
+		})
+	}
+
+	t.Run("missingUserID", func(t *testing.T) {
+		if _, err := BuildPresenceChannelData("", nil); err == nil {
+			t.Error("Expected an error for an empty user ID, got nil")
+		}
+	})
+
+	t.Run("unencodableInfo", func(t *testing.T) {
+		if _, err := BuildPresenceChannelData("123", make(chan int)); err == nil {
+			t.Error("Expected an error for user info that can't be encoded, got nil")
+		}
+	})
+}
+
+func TestSubscribeManyErrorError(t *testing.T) {
+	err := SubscribeManyError{
+		"foo": errors.New("bar"),
+		"baz": errors.New("qux"),
+	}
+	want := "Subscription failed for 2 channels: baz: qux; foo: bar"
+	if got := err.Error(); got != want {
+		t.Errorf("Expected error %q, got %q", want, got)
+	}
+}
+
+func TestSubscriptionErrorError(t *testing.T) {
+	wantErr := errors.New("bar")
+	err := SubscriptionError{
+		Channel: "foo",
+		Code:    401,
+		Message: "baz",
+		Err:     wantErr,
+	}
+	errMsg := err.Error()
+	if !strings.Contains(errMsg, `channel "foo"`) {
+		t.Errorf(`Expected error message to contain 'channel "foo"', got %s`, errMsg)
+	}
+	if !strings.Contains(errMsg, "code 401") {
+		t.Errorf("Expected error message to contain 'code 401', got %s", errMsg)
+	}
+	if !errors.Is(err, wantErr) {
+		t.Errorf("Expected subscription error to wrap %v, got %v", wantErr, errors.Unwrap(err))
+	}
+}
+
 func TestPrivateChannelSubscribe(t *testing.T) {
 	t.Run("subscribed", func(t *testing.T) {
 		ch := &privateChannel{
@@ -407,7 +970,7 @@ func TestPrivateChannelSubscribe(t *testing.T) {
 				name:       wantChannel,
 				subscribed: false,
 				client: &Client{
-					ws:          ws,
+					ws:          websocketConn{ws},
 					connected:   true,
 					socketID:    wantSocketID,
 					AuthURL:     authSrv.URL,
@@ -442,7 +1005,8 @@ func TestPrivateChannelSubscribe(t *testing.T) {
 			&channel{
 				subscribed: false,
 				client: &Client{
-					AuthURL: authSrv.URL,
+					connected: true,
+					AuthURL:   authSrv.URL,
 				},
 			},
 		}
@@ -453,6 +1017,380 @@ func TestPrivateChannelSubscribe(t *testing.T) {
 			t.Errorf("Expected auth error status to be %d, got %d", wantStatus, authErr.Status)
 		}
 	})
+
+	t.Run("withAuth", func(t *testing.T) {
+		wantChannel := "presence-foo"
+		wantAuth := "baz"
+		wantChannelData := `{"user_id":"1"}`
+
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			var event Event
+			err := websocket.JSON.Receive(ws, &event)
+			if err != nil {
+				panic(err)
+			}
+
+			data := channelData{}
+			err = json.Unmarshal(event.Data, &data)
+			if err != nil {
+				panic(err)
+			}
+
+			if data.Auth != wantAuth {
+				t.Errorf("Expected subscribe data to have auth %q, got %q", wantAuth, data.Auth)
+			}
+			var gotChannelData string
+			if err = json.Unmarshal(data.ChannelData, &gotChannelData); err != nil || gotChannelData != wantChannelData {
+				t.Errorf("Expected subscribe data to have channel data %q, got %s", wantChannelData, data.ChannelData)
+			}
+
+			err = websocket.JSON.Send(ws, Event{
+				Event:   pusherInternalSubSucceeded,
+				Channel: wantChannel,
+			})
+			if err != nil {
+				panic(err)
+			}
+		}))
+		defer srv.Close()
+		wsURL := strings.Replace(srv.URL, "http", "ws", 1)
+		ws, err := websocket.Dial(wsURL, "ws", localOrigin)
+		if err != nil {
+			panic(err)
+		}
+
+		ch := &privateChannel{
+			&channel{
+				name: wantChannel,
+				client: &Client{
+					ws:        websocketConn{ws},
+					connected: true,
+				},
+			},
+		}
+		ch.client.subscribedChannels = subscribedChannels{wantChannel: ch}
+		defer ch.client.Disconnect()
+
+		go ch.client.listen()
+
+		err = ch.Subscribe(WithAuth(wantAuth, wantChannelData), WithSuccessTimeout(100*time.Millisecond))
+		if err != nil {
+			panic(err)
+		}
+	})
+
+	t.Run("withPresenceData", func(t *testing.T) {
+		wantChannel := "presence-foo"
+		wantAuth := "baz"
+		wantChannelData := `{"user_id":"1","user_info":{"name":"bar"}}`
+
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			var event Event
+			err := websocket.JSON.Receive(ws, &event)
+			if err != nil {
+				panic(err)
+			}
+
+			data := channelData{}
+			err = json.Unmarshal(event.Data, &data)
+			if err != nil {
+				panic(err)
+			}
+
+			if data.Auth != wantAuth {
+				t.Errorf("Expected subscribe data to have auth %q, got %q", wantAuth, data.Auth)
+			}
+			var gotChannelData string
+			if err = json.Unmarshal(data.ChannelData, &gotChannelData); err != nil || gotChannelData != wantChannelData {
+				t.Errorf("Expected subscribe data to have channel data %q, got %s", wantChannelData, data.ChannelData)
+			}
+
+			err = websocket.JSON.Send(ws, Event{
+				Event:   pusherInternalSubSucceeded,
+				Channel: wantChannel,
+				Data:    json.RawMessage(`"{\"presence\":{\"ids\":[\"1\"],\"hash\":{\"1\":{\"name\":\"bar\"}},\"count\":1}}"`),
+			})
+			if err != nil {
+				panic(err)
+			}
+		}))
+		defer srv.Close()
+		wsURL := strings.Replace(srv.URL, "http", "ws", 1)
+		ws, err := websocket.Dial(wsURL, "ws", localOrigin)
+		if err != nil {
+			panic(err)
+		}
+
+		client := &Client{
+			ws:        websocketConn{ws},
+			connected: true,
+		}
+		ch := newPresenceChannel(&channel{name: wantChannel, client: client})
+		client.subscribedChannels = subscribedChannels{wantChannel: ch}
+		defer client.Disconnect()
+
+		go client.listen()
+
+		err = ch.Subscribe(
+			WithAuth(wantAuth, ""),
+			WithPresenceData("1", map[string]string{"name": "bar"}),
+			WithSuccessTimeout(100*time.Millisecond),
+		)
+		if err != nil {
+			panic(err)
+		}
+
+		me, err := ch.Me()
+		if err != nil || me.ID != "1" {
+			t.Errorf("Expected the local member to have ID %q, got %+v, %v", "1", me, err)
+		}
+	})
+
+	t.Run("withPresenceDataInvalid", func(t *testing.T) {
+		testCases := []struct {
+			name    string
+			channel string
+			opts    []SubscribeOption
+		}{
+			{"privateChannel", "private-foo", []SubscribeOption{WithAuth("bar", ""), WithPresenceData("1", nil)}},
+			{"missingAuth", "presence-foo", []SubscribeOption{WithPresenceData("1", nil)}},
+			{"missingUserID", "presence-foo", []SubscribeOption{WithAuth("bar", ""), WithPresenceData("", nil)}},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				ch := &privateChannel{&channel{name: tc.channel, client: &Client{connected: true}}}
+				if err := ch.Subscribe(tc.opts...); err == nil {
+					t.Error("Expected an error, got nil")
+				}
+			})
+		}
+
+		ch := &channel{name: "foo", client: &Client{connected: true}}
+		if err := ch.Subscribe(WithPresenceData("1", nil)); err == nil {
+			t.Error("Expected an error providing presence data for a public channel, got nil")
+		}
+	})
+
+	t.Run("authRetryTransient", func(t *testing.T) {
+		wantChannel := "private-foo"
+		var authAttempts int
+
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			var event Event
+			err := websocket.JSON.Receive(ws, &event)
+			if err != nil {
+				panic(err)
+			}
+
+			err = websocket.JSON.Send(ws, Event{
+				Event:   pusherInternalSubSucceeded,
+				Channel: wantChannel,
+			})
+			if err != nil {
+				panic(err)
+			}
+		}))
+		defer srv.Close()
+		wsURL := strings.Replace(srv.URL, "http", "ws", 1)
+		ws, err := websocket.Dial(wsURL, "ws", localOrigin)
+		if err != nil {
+			panic(err)
+		}
+
+		authSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authAttempts++
+			if authAttempts < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(`{"auth":"baz"}`))
+		}))
+		defer authSrv.Close()
+
+		ch := &privateChannel{
+			&channel{
+				name: wantChannel,
+				client: &Client{
+					ws:               websocketConn{ws},
+					connected:        true,
+					AuthURL:          authSrv.URL,
+					AuthRetries:      2,
+					AuthRetryBackoff: time.Millisecond,
+				},
+			},
+		}
+		ch.client.subscribedChannels = subscribedChannels{wantChannel: ch}
+		defer ch.client.Disconnect()
+
+		go ch.client.listen()
+
+		err = ch.Subscribe(WithSuccessTimeout(100 * time.Millisecond))
+		if err != nil {
+			t.Fatalf("Expected subscribe to succeed after retries, got %v", err)
+		}
+		if authAttempts != 3 {
+			t.Errorf("Expected 3 auth attempts, got %d", authAttempts)
+		}
+	})
+
+	t.Run("authRetryPermanent", func(t *testing.T) {
+		var authAttempts int
+		authSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authAttempts++
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		defer authSrv.Close()
+
+		ch := &privateChannel{
+			&channel{
+				client: &Client{
+					connected:        true,
+					AuthURL:          authSrv.URL,
+					AuthRetries:      2,
+					AuthRetryBackoff: time.Millisecond,
+				},
+			},
+		}
+
+		err := ch.Subscribe()
+		if _, ok := err.(AuthError); !ok {
+			t.Errorf("Expected an AuthError, got %v", err)
+		}
+		if authAttempts != 1 {
+			t.Errorf("Expected 1 auth attempt, got %d", authAttempts)
+		}
+	})
+
+	t.Run("authRetryDisconnect", func(t *testing.T) {
+		authSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer authSrv.Close()
+
+		closed := make(chan struct{})
+		ch := &privateChannel{
+			&channel{
+				client: &Client{
+					connected:        true,
+					closed:           closed,
+					AuthURL:          authSrv.URL,
+					AuthRetries:      2,
+					AuthRetryBackoff: time.Hour,
+				},
+			},
+		}
+
+		errChan := make(chan error, 1)
+		go func() { errChan <- ch.Subscribe() }()
+		time.Sleep(50 * time.Millisecond)
+		close(closed)
+
+		select {
+		case err := <-errChan:
+			if err != ErrNotConnected {
+				t.Errorf("Expected error %v, got %v", ErrNotConnected, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected the retry backoff to stop once the client is closed")
+		}
+	})
+
+	t.Run("authInvalidJSON", func(t *testing.T) {
+		authSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`not json`))
+		}))
+		defer authSrv.Close()
+
+		ch := &privateChannel{
+			&channel{
+				client: &Client{
+					connected: true,
+					AuthURL:   authSrv.URL,
+				},
+			},
+		}
+
+		err := ch.Subscribe()
+		var authErr AuthError
+		if !errors.As(err, &authErr) {
+			t.Fatalf("Expected an AuthError, got %v", err)
+		}
+		if authErr.Err == nil {
+			t.Errorf("Expected auth error to have an underlying cause")
+		}
+	})
+
+	t.Run("authRequestInterceptor", func(t *testing.T) {
+		signatures := make(chan string, 1)
+		authSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			signatures <- r.Header.Get("X-Signature")
+			w.Write([]byte(`{"auth":"foo:bar"}`))
+		}))
+		defer authSrv.Close()
+
+		var wantSignature string
+		ch := &privateChannel{
+			&channel{
+				name: "private-foo",
+				client: &Client{
+					socketID: "123.456",
+					AuthURL:  authSrv.URL,
+					AuthRequestInterceptor: func(req *http.Request) error {
+						body, err := io.ReadAll(req.Body)
+						if err != nil {
+							return err
+						}
+						wantSignature = fmt.Sprintf("%x", sha256.Sum256(body))
+						req.Header.Set("X-Signature", wantSignature)
+						req.Body = io.NopCloser(bytes.NewReader(body))
+						return nil
+					},
+				},
+			},
+		}
+
+		chanData, _, err := ch.authorize()
+		if err != nil {
+			t.Fatalf("Expected error to be `nil`, got %v", err)
+		}
+		if chanData.Auth != "foo:bar" {
+			t.Errorf("Expected auth %q, got %q", "foo:bar", chanData.Auth)
+		}
+		if got := <-signatures; got != wantSignature {
+			t.Errorf("Expected signature header %q, got %q", wantSignature, got)
+		}
+	})
+
+	t.Run("authRequestInterceptorError", func(t *testing.T) {
+		requested := false
+		authSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested = true
+		}))
+		defer authSrv.Close()
+
+		wantErr := errors.New("foo")
+		ch := &privateChannel{
+			&channel{
+				client: &Client{
+					connected: true,
+					AuthURL:   authSrv.URL,
+					AuthRequestInterceptor: func(*http.Request) error {
+						return wantErr
+					},
+				},
+			},
+		}
+
+		err := ch.Subscribe()
+		var authErr AuthError
+		if !errors.As(err, &authErr) || !errors.Is(err, wantErr) {
+			t.Errorf("Expected an AuthError wrapping %v, got %v", wantErr, err)
+		}
+		if requested {
+			t.Error("Expected the auth request not to be sent")
+		}
+	})
 }
 
 func TestChannelIsSubscribed(t *testing.T) {
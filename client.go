@@ -1,14 +1,19 @@
 package pusher
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"runtime/debug"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/websocket"
@@ -26,12 +31,17 @@ const (
 	pusherConnEstablished       = "pusher:connection_established"
 	pusherSubSucceeded          = "pusher:subscription_succeeded"
 	pusherInternalSubSucceeded  = "pusher_internal:subscription_succeeded"
+	pusherSubError              = "pusher:subscription_error"
+	pusherInternalSubError      = "pusher_internal:subscription_error"
+	pusherSubCount              = "pusher:subscription_count"
+	pusherInternalSubCount      = "pusher_internal:subscription_count"
+	pusherCacheMiss             = "pusher:cache_miss"
 	pusherInternalMemberAdded   = "pusher_internal:member_added"
 	pusherInternalMemberRemoved = "pusher_internal:member_removed"
 
 	localOrigin = "http://localhost/"
 
-	connURLFormat     = "%s://%s:%d/app/%s?protocol=%s"
+	connURLFormat     = "%s://%s:%d/app/%s?protocol=%s&client=%s&version=%s"
 	secureScheme      = "wss"
 	securePort        = 443
 	insecureScheme    = "ws"
@@ -39,7 +49,11 @@ const (
 	defaultHost       = "ws.pusherapp.com"
 	clusterHostFormat = "ws-%s.pusher.com"
 	protocolVersion   = "7"
+	clientName        = "pusher-ws-go"
+	clientVersion     = "0.1.0"
 
+	// Default timeout for receiving the connection established event
+	defaultHandshakeTimeout = 30 * time.Second
 	// Default timeout for receiving a pong response after sending a ping
 	defaultPongTimeout = 30 * time.Second
 	// Number of failed pong responses before attempting to reconnect
@@ -48,61 +62,425 @@ const (
 	initialReconnectDelay = 1 * time.Second
 	// Maximum reconnect delay with exponential backoff
 	maxReconnectDelay = 60 * time.Second
+	// Initial delay between retries of transient authentication failures
+	defaultAuthRetryBackoff = 1 * time.Second
+	// Default maximum number of client events buffered while disconnected
+	defaultMaxBufferedEvents = 100
+	// Default time that a buffered client event remains eligible for sending
+	defaultBufferedEventTTL = 30 * time.Second
+	// Default maximum number of client events sent per second
+	defaultClientEventRate = 10
+	// Default maximum number of concurrent resubscriptions
+	defaultResubscribeConcurrency = 10
+	// Default maximum size of a received message
+	defaultMaxMessageSize = 1 << 20
+	// Time during which repeats of a pusher:error are counted rather than
+	// sent to Errors
+	errorCoalesceWindow = 1 * time.Second
 )
 
-type boundEventChans map[chan Event]struct{}
+var (
+	// ErrNotConnected is returned when an operation requires a connection to
+	// Pusher, but the client is not connected.
+	ErrNotConnected = errors.New("not connected")
+	// ErrAlreadyConnected is returned by Connect when the client is already
+	// connected.
+	ErrAlreadyConnected = errors.New("already connected")
+	// ErrBufferFull is returned by SendEvent when BufferOutbound is set and the
+	// event could not be buffered because the buffer is full.
+	ErrBufferFull = errors.New("outbound buffer full")
+	// ErrReconnectRequested is passed to OnDisconnect when the connection is
+	// closed by a call to Reconnect.
+	ErrReconnectRequested = errors.New("reconnection requested")
+	// ErrReceiveOnly is returned by SendEvent when ReceiveOnly is set and the
+	// event isn't a Pusher protocol event.
+	ErrReceiveOnly = errors.New("client is receive-only")
+	// ErrMessageTooLarge is sent to Errors when a message larger than
+	// MaxMessageSize is received and discarded.
+	ErrMessageTooLarge = errors.New("message too large")
+	// ErrMaxLifetimeReached is returned by Wait when the client has been
+	// disconnected because MaxLifetime has elapsed.
+	ErrMaxLifetimeReached = errors.New("max lifetime reached")
+	// ErrInvalidPayload is returned by Connect when PingPayload or PongPayload
+	// isn't a JSON event with the expected name.
+	ErrInvalidPayload = errors.New("invalid payload")
+)
+
+// watchdogMargin is added to the activity and pong timeouts to give the time
+// without activity after which the watchdog reconnects. It is a variable so
+// that tests can shorten it.
+var watchdogMargin = 10 * time.Second
+
+// clusterHost returns the host name of cluster. It is a variable so that tests
+// can direct clusters to local servers.
+var clusterHost = func(cluster string) string {
+	return fmt.Sprintf(clusterHostFormat, cluster)
+}
+
+type boundEventChans map[chan Event]chan struct{}
 
 type subscribedChannels map[string]internalChannel
 
 // Client represents a Pusher websocket client. After creating an instance, it
-// is necessary to call Connect to establish the connection with Pusher. Calling
-// any other methods before a connection is established is an invalid operation
-// and may panic.
+// is necessary to call Connect to establish the connection with Pusher. Methods
+// that send on the connection return ErrNotConnected when called before a
+// connection is established or after Disconnect.
 type Client struct {
 	// The cluster to connect to. The default is Pusher's "mt1" cluster in the
 	// "us-east-1" region.
 	Cluster string
+	// Clusters to connect to in order of priority. If provided, it takes
+	// precedence over Cluster. Each connection and reconnection attempt tries
+	// the clusters in order until one succeeds, so the client fails over when a
+	// cluster is unreachable and returns to the primary cluster when it
+	// recovers. ActiveCluster reports the cluster currently connected to.
+	Clusters []string
 	// Whether to connect to Pusher over an insecure websocket connection.
 	Insecure bool
+	// The client name and version reported to Pusher in the connection URL,
+	// which appear in the connection info on the Pusher dashboard. The defaults
+	// identify this library.
+	ClientName    string
+	ClientVersion string
+	// The Origin header sent in the websocket handshake. The default is
+	// "http://localhost/".
+	Origin string
+	// The User-Agent header sent in the websocket handshake. The default
+	// identifies this library, such as "pusher-ws-go/0.1.0".
+	UserAgent string
+	// If provided, Dial is called with the URL of each connection to Pusher and
+	// the origin to open it, instead of dialing a websocket connection, such as
+	// to use an in-memory connection in tests. The TLS settings and
+	// MaxMessageSize aren't applied to the connections it returns.
+	Dial func(url, origin string) (Conn, error)
+	// The TLS configuration used for secure connections. If nil, the default
+	// configuration is used.
+	TLSConfig *tls.Config
+	// SHA-256 fingerprints of the DER-encoded certificates to pin, in hex with
+	// or without colon separators. If provided, connecting fails with
+	// ErrCertificatePinMismatch unless one of the certificates presented by the
+	// server matches. Pinning is checked in addition to, not instead of, the
+	// normal certificate chain verification.
+	PinnedCertFingerprints []string
+	// Client certificates presented to servers that request one, for mutual
+	// TLS authentication. If GetClientCertificate is provided, it is used
+	// instead to select the certificate when the server requests one. These
+	// take precedence over the certificates in TLSConfig.
+	ClientCertificates   []tls.Certificate
+	GetClientCertificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
 
 	// The URL to call when authenticating private or presence channels.
 	AuthURL string
 	// Additional parameters to be sent in the POST body of an authentication request.
+	// Use SetAuthParams to change them once the client is in use.
 	AuthParams url.Values
-	// Additional HTTP headers to be sent in an authentication request.
+	// Additional HTTP headers to be sent in an authentication request. Use
+	// SetAuthHeaders to change them once the client is in use.
 	AuthHeaders http.Header
+	// The number of times a failed authentication request is retried when the
+	// failure is transient, such as a network error or a 5xx response. The
+	// default is 0, meaning no retries.
+	AuthRetries int
+	// The delay before the first authentication retry. The delay doubles with
+	// each subsequent retry. The default is 1 second.
+	AuthRetryBackoff time.Duration
+	// If provided, AuthRequestInterceptor is called with each authentication
+	// request before it's sent, such as to add a signature header computed
+	// from the body or a trace ID. The request is a POST to AuthURL with an
+	// application/x-www-form-urlencoded body holding socket_id, channel_name
+	// and AuthParams, and with AuthHeaders set. The interceptor may modify the
+	// request, including replacing its body. If it returns an error, the
+	// request isn't sent and the subscription fails with an AuthError
+	// wrapping it.
+	AuthRequestInterceptor func(*http.Request) error
+
+	// The delay before the first reconnection attempt after the connection is
+	// lost. The delay doubles with each failed attempt, up to
+	// MaxReconnectDelay. The default is 1 second.
+	InitialReconnectDelay time.Duration
+	// The maximum delay between reconnection attempts. If it is less than
+	// InitialReconnectDelay, the defaults are used for both. The default is 60
+	// seconds.
+	MaxReconnectDelay time.Duration
+
+	// Whether client events sent while the connection is down are buffered and
+	// sent once the connection is re-established, instead of failing with
+	// ErrNotConnected. Buffered events are sent in the order they were issued,
+	// after channels have been resubscribed. Events sent after the connection
+	// is re-established may be delivered before buffered events that are still
+	// being replayed. Pusher protocol events are never buffered.
+	BufferOutbound bool
+	// The maximum number of buffered client events. Events sent while the
+	// buffer is full are dropped. The default is 100.
+	MaxBufferedEvents int
+	// How long a buffered client event remains eligible for sending. Expired
+	// events are dropped when the connection is re-established. The default is
+	// 30 seconds.
+	BufferedEventTTL time.Duration
+
+	// The time to wait for Pusher to confirm that the connection has been
+	// established once the websocket handshake succeeds. Connect returns
+	// ErrTimedOut if it is exceeded. The default is 30 seconds.
+	HandshakeTimeout time.Duration
+
+	// The maximum size in bytes of a message received from Pusher. Larger
+	// messages are discarded without being read into memory, and an error
+	// wrapping ErrMessageTooLarge is sent to Errors; the connection remains
+	// open. The default is 1 MiB.
+	MaxMessageSize int64
+
+	// The maximum time without activity on the connection before a ping is
+	// sent to check that it is alive. The activity timeout reported by Pusher
+	// is used if it is smaller. The default is to use Pusher's value.
+	MaxActivityTimeout time.Duration
+
+	// The activity timeout the client would prefer, advertised to Pusher as
+	// the activity_timeout query parameter when connecting. Pusher has the
+	// final say: the timeout it reports is used unless the preferred timeout
+	// is smaller, since the client may ping more often than Pusher requires
+	// but not less. MaxActivityTimeout is applied afterwards. It is rounded
+	// down to whole seconds, and values under one second are ignored.
+	PreferredActivityTimeout time.Duration
+
+	// If true, a native websocket ping control frame is sent along with each
+	// pusher:ping message, for servers and intermediaries such as load
+	// balancers that only treat control frames as a sign of liveness. It
+	// supplements the pusher:ping heartbeat rather than replacing it: pongs to
+	// native pings are answered below the Conn, so the client can't observe
+	// them, and pong timeouts are still detected with pusher:pong. It is
+	// ignored if the Conn doesn't implement Pinger.
+	UseNativePing bool
+
+	// The message sent as a ping, for servers that expect extra fields. It
+	// must be a JSON event named pusher:ping, which Connect checks. The
+	// default is {"event":"pusher:ping","data":"{}"}.
+	PingPayload string
+	// The message sent in reply to a ping from the server. It must be a JSON
+	// event named pusher:pong, which Connect checks. The default is
+	// {"event":"pusher:pong","data":"{}"}.
+	PongPayload string
+
+	// The maximum number of client events sent per second, with bursts of up to
+	// one second's worth of events. Pusher disconnects clients that exceed its
+	// limit, so the default is 10, matching Pusher's default limit. A negative
+	// value disables rate limiting. Events other than client events are not
+	// limited.
+	ClientEventRate int
+	// Whether SendEvent waits until a client event may be sent when the rate
+	// limit is reached, instead of returning ErrRateLimited.
+	BlockOnRateLimit bool
+
+	// Whether the client refuses to send events, for integrations that must
+	// only receive. SendEvent and Channel.Trigger return ErrReceiveOnly without
+	// writing anything. Pusher protocol events, whose names start with
+	// "pusher:", are still sent, so pings and pongs keep the connection alive
+	// and channels can be subscribed and unsubscribed.
+	ReceiveOnly bool
+
+	// The time that subscription requests wait for a success response from
+	// Pusher before timing out with ErrTimedOut. The channel remains registered
+	// and may be retried with Channel.Subscribe. It can be overridden per
+	// subscription with WithSuccessTimeout. The default is 10 seconds.
+	SubscribeTimeout time.Duration
+
+	// The maximum number of subscription requests in flight at once while
+	// resubscribing to channels after a reconnection. Pusher has no batched
+	// subscribe, so each channel needs its own request and confirmation;
+	// sending them concurrently means resubscribing to n channels takes about
+	// n/ResubscribeConcurrency round trips rather than n. It also limits the
+	// subscriptions made at once by SubscribeMany. The default is 10.
+	ResubscribeConcurrency int
 
 	// If provided, errors that occur while receiving messages and errors emitted
-	// by Pusher will be sent to this channel.
+	// by Pusher will be sent to this channel. Errors are dropped rather than
+	// blocking the client when the channel is full. The number dropped is
+	// reported by DroppedErrors. Use SetErrors to change it once the client is
+	// in use. Errors that end a connection are also reported by LastError,
+	// whether or not Errors is set.
 	Errors chan error
-
-	socketID string
-	// TODO: make this configurable
+	// If provided, ErrorsOverflow is called with each error that is dropped
+	// because Errors is full. It may be called while the client's lock is held,
+	// so it must not call methods on the Client.
+	ErrorsOverflow func(dropped error)
+	// If provided, OnError is called with every error that would be sent to
+	// Errors, whether or not Errors is set or has room. Each call is made in
+	// its own goroutine, so no errors are lost and the client never waits for
+	// it to return, but calls may run concurrently and out of order.
+	OnError func(err error)
+
+	// If provided, OnSocketID is called each time a connection is established
+	// with the previous and new socket IDs, before OnConnect and before
+	// channels are resubscribed. It is called once the client's lock has been
+	// released, so it may call methods on the Client, such as SetAuthHeaders to
+	// refresh authentication tied to the old socket ID.
+	OnSocketID func(oldID, newID string)
+
+	// If provided, OnActivityTimeout is called with the effective activity
+	// timeout, which sets the heartbeat interval, when a connection is
+	// established with a timeout different from that of the previous
+	// connection, including the first. The timeout reported by Pusher may
+	// change across reconnections, and is capped by MaxActivityTimeout. It is
+	// called before OnSocketID, once the client's lock has been released, so it
+	// may call methods on the Client.
+	OnActivityTimeout func(d time.Duration)
+
+	// If provided, OnConnect is called with the socket ID each time a
+	// connection is established, including reconnections, before channels are
+	// resubscribed. It may send events on the connection, such as to sign in.
+	OnConnect func(socketID string)
+
+	// If provided, OnDisconnect is called each time the client goes from
+	// connected to disconnected, whether because of Disconnect, cancellation of
+	// Context, or the connection being lost. err is nil for Disconnect, the
+	// context's error for cancellation, and otherwise the error that caused the
+	// connection to be lost, after which the client attempts to reconnect.
+	OnDisconnect func(err error)
+
+	// If provided, OnFrame is called with the raw data of every frame sent to
+	// or received from Pusher, for diagnosing protocol issues. It may be called
+	// while the client's lock is held, so it must not call methods on the
+	// Client, and it must not retain or modify data.
+	OnFrame func(direction Direction, data []byte)
+
+	// If provided, OnPong is called with the round-trip time each time a pong
+	// is received in response to a ping sent by the client.
+	OnPong func(rtt time.Duration)
+
+	// If provided, Metrics receives counts of events and reconnects, pong
+	// latencies and changes to the connection state.
+	Metrics Metrics
+
+	// If provided, the client is disconnected when Context is cancelled, and no
+	// further reconnection attempts are made. Wait returns the context's error.
+	Context context.Context
+
+	// If positive, the client is disconnected once MaxLifetime has elapsed
+	// since Connect was called, and no further reconnection attempts are made,
+	// so that short-lived jobs don't leave connections behind. An error
+	// wrapping ErrMaxLifetimeReached is sent to Errors, and Wait returns
+	// ErrMaxLifetimeReached. The default is no limit.
+	MaxLifetime time.Duration
+
+	socketID        string
+	activeCluster   string
 	activityTimeout time.Duration
 	pongTimeout     time.Duration
+	// connectionInfo is the decoded data of the connection_established event
+	// of the current or most recent connection.
+	connectionInfo json.RawMessage
 
-	ws                 *websocket.Conn
+	ws                 Conn
 	connected          bool
-	activityTimer      *time.Timer
+	activityTimer      timer
 	activityTimerReset chan struct{}
-	pongTimer          *time.Timer
+	pongTimer          timer
 	pongReceived       chan struct{}
-	pongFailures       int
-	ReconnectDelay     time.Duration
+	pingWaiters        []chan struct{}
+	pingSentAt         time.Time
+	latency            time.Duration
+	connectedSince     time.Time
+	reconnectCount     int
+	lastReconnect      time.Time
+	eventsReceived     uint64
+	droppedErrors      atomic.Uint64
+	bytesSent          atomic.Uint64
+	bytesReceived      atomic.Uint64
 	appKey             string // Store the app key for reconnection
 	boundEvents        map[string]boundEventChans
+	// generation holds the counters of the current connection generation.
+	generation connGeneration
+	// ReconnectDelay is no longer used.
+	//
+	// Deprecated: the delay before the first reconnection attempt is set
+	// with InitialReconnectDelay.
+	ReconnectDelay time.Duration
+	// orderedChans holds the delivery queues of bindings made with
+	// WithOrderedDelivery.
+	orderedChans map[chan Event]*orderedQueue[Event]
+	// boundData holds the bindings made with BindData, and orderedDataChans the
+	// delivery queues of those made with WithOrderedDelivery.
+	boundData        map[string]boundDataChans
+	orderedDataChans map[chan json.RawMessage]*orderedQueue[json.RawMessage]
+	// onceEvents holds the bindings made with BindOnce.
+	onceEvents map[string]boundEventChans
+	// systemEvents holds the bindings made with BindSystem.
+	systemEvents map[string]boundEventChans
+	// boundPatterns holds the bindings made with BindPattern, keyed by
+	// pattern.
+	boundPatterns map[string]boundEventChans
+	// globalFuncs holds the delivery queues of the handlers registered with
+	// BindGlobalFunc, keyed by the channel each queue delivers to.
+	globalFuncs map[chan Event]*orderedQueue[Event]
+	// registeredEvents holds the prototypes of the events registered with
+	// RegisterEvent.
+	registeredEvents map[string]func() interface{}
 	// TODO: implement global bindings
 	// globalBindings     boundEventChans
 	subscribedChannels subscribedChannels
+	// unsubscribed holds the names of the channels unsubscribed from on the
+	// current connection. Pusher doesn't acknowledge unsubscriptions, so
+	// events for these channels may still arrive, and are dropped until a new
+	// subscription to the channel is confirmed.
+	unsubscribed map[string]struct{}
+	// lastPusherError is the most recent pusher:error sent to Errors, and
+	// lastPusherErrorAt when it was sent. Identical errors received within
+	// errorCoalesceWindow are counted in repeatedPusherErrors instead, so that
+	// a misbehaving server can't flood Errors.
+	lastPusherError      string
+	lastPusherErrorAt    time.Time
+	repeatedPusherErrors int
+	// outbound holds client events buffered while disconnected.
+	outbound []bufferedEvent
+	// limiter enforces ClientEventRate. It's created on first use.
+	limiter *rateLimiter
 
 	mutex sync.RWMutex
 	done  chan struct{}
+	// doneClosed records whether done has been closed, so that the
+	// connection can be torn down by Disconnect and a reconnection
+	// concurrently without closing it twice.
+	doneClosed bool
+	// wg tracks the goroutines reading from and writing to the connection so
+	// that Disconnect can wait for them to exit.
+	wg sync.WaitGroup
+	// deliveries counts the events being delivered to bound channels, so that
+	// DisconnectContext can wait for them. While draining is set, received
+	// events are no longer delivered.
+	deliveries deliveryCounter
+	draining   bool
+	// closed is closed once the client has been permanently shut down, and
+	// closeErr holds the error that caused the shutdown, if any.
+	closed   chan struct{}
+	closeErr error
+	// writeMutex serializes writes to the websocket connection.
+	writeMutex sync.Mutex
+	// outboundMutex is held while buffered events are being sent, so that
+	// Flush can wait for them.
+	outboundMutex sync.Mutex
+	// errorsMutex guards Errors and lastError.
+	errorsMutex sync.RWMutex
+	// lastError is the most recent error that ended a connection or
+	// prevented one from being established.
+	lastError error
+	// clock is the source of time for the heartbeat, timeouts and
+	// reconnection backoff. If nil, the time package is used.
+	clock clock
+	// activityMutex guards lastActivity, the time of the last successful read
+	// or write on the connection. It is used instead of mutex, since writes
+	// are made while mutex is held.
+	activityMutex sync.Mutex
+	lastActivity  time.Time
 
 	// used for testing
 	OverrideHost string
 	OverridePort int
 }
 
+type bufferedEvent struct {
+	event    Event
+	queuedAt time.Time
+}
+
 type connectionData struct {
 	SocketID        string `json:"socket_id"`
 	ActivityTimeout int    `json:"activity_timeout"`
@@ -121,7 +499,30 @@ func UnmarshalDataString(data json.RawMessage, dest interface{}) error {
 	return nil
 }
 
-func (c *Client) generateConnURL(appKey string) string {
+// UnmarshalData is a convenience function to unmarshal JSON data from a Pusher
+// event that is not double-encoded, such as an object sent directly in the
+// data field.
+func UnmarshalData(data json.RawMessage, dest interface{}) error {
+	if err := json.Unmarshal(data, dest); err != nil {
+		return fmt.Errorf("failed to unmarshal data to destination: %w", err)
+	}
+	return nil
+}
+
+// UnmarshalAuto unmarshals JSON data from a Pusher event whether or not it is
+// double-encoded. If data is a JSON string whose contents can be unmarshalled
+// into dest, they are used. Otherwise data is unmarshalled into dest directly,
+// so a plain string payload can be decoded into a string.
+func UnmarshalAuto(data json.RawMessage, dest interface{}) error {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '"' {
+		if err := UnmarshalDataString(data, dest); err == nil {
+			return nil
+		}
+	}
+	return UnmarshalData(data, dest)
+}
+
+func (c *Client) generateConnURL(appKey, cluster string) string {
 	scheme, port := secureScheme, securePort
 	if c.Insecure {
 		scheme, port = insecureScheme, insecurePort
@@ -131,66 +532,205 @@ func (c *Client) generateConnURL(appKey string) string {
 	}
 
 	host := defaultHost
-	if c.Cluster != "" {
-		host = fmt.Sprintf(clusterHostFormat, c.Cluster)
+	if cluster != "" {
+		host = clusterHost(cluster)
 	}
 	if c.OverrideHost != "" {
 		host = c.OverrideHost
 	}
 
-	return fmt.Sprintf(connURLFormat, scheme, host, port, appKey, protocolVersion)
+	name, version := clientName, clientVersion
+	if c.ClientName != "" {
+		name = c.ClientName
+	}
+	if c.ClientVersion != "" {
+		version = c.ClientVersion
+	}
+
+	connURL := fmt.Sprintf(connURLFormat, scheme, host, port, appKey, protocolVersion,
+		url.QueryEscape(name), url.QueryEscape(version))
+	if seconds := int(c.PreferredActivityTimeout / time.Second); seconds > 0 {
+		connURL += fmt.Sprintf("&activity_timeout=%d", seconds)
+	}
+	return connURL
 }
 
-// Connect establishes a connection to the Pusher app specified by appKey.
+// Connect establishes a connection to the Pusher app specified by appKey. It
+// returns ErrAlreadyConnected if the client is already connected, leaving the
+// existing connection in place. If it is called while the client is
+// reconnecting in the background, the reconnection stops once Connect has
+// succeeded. If Connect fails, the client is shut down, and Wait returns the
+// error; Connect may be called again.
 func (c *Client) Connect(appKey string) error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
+	if c.connected {
+		return ErrAlreadyConnected
+	}
+
 	c.appKey = appKey
+	if c.closed == nil || c.isClosedLocked() {
+		c.closed = make(chan struct{})
+		c.closeErr = nil
+	}
 	c.pongTimeout = defaultPongTimeout
-	c.ReconnectDelay = initialReconnectDelay
-	c.pongFailures = 0
+	c.resetGenerationLocked()
+	c.reconnectCount = 0
+	c.lastReconnect = time.Time{}
+	c.eventsReceived = 0
+	c.setLastError(nil)
+
+	// A failed Connect shuts the client down, so that Wait returns the error
+	// rather than waiting for a connection that won't be established.
+	if err := c.validatePayloads(); err != nil {
+		c.shutdownLocked(err)
+		return err
+	}
 
-	return c.connectInternal()
-}
+	if c.Context != nil {
+		if err := c.Context.Err(); err != nil {
+			c.shutdownLocked(err)
+			return err
+		}
+	}
 
-// connectInternal handles the actual connection logic
-func (c *Client) connectInternal() error {
-	var err error
-	c.ws, err = websocket.Dial(c.generateConnURL(c.appKey), "", localOrigin)
+	setup, err := c.connectInternal()
 	if err != nil {
+		c.shutdownLocked(err)
 		return err
 	}
 
-	var event Event
-	err = websocket.JSON.Receive(c.ws, &event)
+	// Channels subscribed before connecting are subscribed once the lock is
+	// released, since subscribing waits for listen to confirm success.
+	c.spawn(func() { c.completeConnection(setup) })
+
+	if c.Context != nil {
+		go c.watchContext(c.Context, c.closed)
+	}
+	if c.MaxLifetime > 0 {
+		go c.watchLifetime(c.MaxLifetime, c.closed)
+	}
+
+	return nil
+}
+
+// watchContext disconnects the client when ctx is cancelled. It returns once
+// the client has been shut down for any reason.
+func (c *Client) watchContext(ctx context.Context, closed chan struct{}) {
+	select {
+	case <-ctx.Done():
+		c.disconnect(ctx.Err())
+	case <-closed:
+	}
+}
+
+// watchLifetime disconnects the client once lifetime has elapsed. It returns
+// once the client has been shut down for any reason.
+func (c *Client) watchLifetime(lifetime time.Duration, closed chan struct{}) {
+	t := c.newTimer(lifetime)
+	select {
+	case <-t.C():
+		c.sendError(fmt.Errorf("%w after %v, disconnecting", ErrMaxLifetimeReached, lifetime))
+		c.disconnect(ErrMaxLifetimeReached)
+	case <-closed:
+		t.Stop()
+	}
+}
+
+// connSetup is the state captured by connectInternal that completeConnection
+// needs once the lock has been released.
+type connSetup struct {
+	// channels are the channels subscribed on the previous connection.
+	channels []internalChannel
+	// socketID is the socket ID of the new connection, and prevSocketID that
+	// of the connection it replaced.
+	socketID     string
+	prevSocketID string
+	// activityTimeout is the effective activity timeout of the new
+	// connection, and activityTimeoutChanged reports whether it differs from
+	// that of the previous one.
+	activityTimeout        time.Duration
+	activityTimeoutChanged bool
+}
+
+// connectInternal handles the actual connection logic. It returns the state
+// that the caller must pass to completeConnection after releasing the lock.
+func (c *Client) connectInternal() (connSetup, error) {
+	var err error
+	origin := localOrigin
+	if c.Origin != "" {
+		origin = c.Origin
+	}
+
+	// Try each cluster in order of priority
+	var dialErrs []error
+	for _, cluster := range c.clusters() {
+		c.ws, err = c.dial(cluster, origin)
+		if err == nil {
+			c.activeCluster = cluster
+			break
+		}
+		dialErrs = append(dialErrs, err)
+	}
+	if c.ws == nil || err != nil {
+		c.ws = nil
+		return connSetup{}, errors.Join(dialErrs...)
+	}
+
+	event, err := c.receiveHandshake(c.ws)
 	if err != nil {
-		return err
+		c.ws.Close()
+		return connSetup{}, err
 	}
+	c.sendSystemEventLocked(event)
 
 	switch event.Event {
 	case pusherError:
-		return extractEventError(event)
+		c.ws.Close()
+		return connSetup{}, extractEventError(event)
 	case pusherConnEstablished:
+		var connInfo json.RawMessage
 		var connData connectionData
-		err = UnmarshalDataString(event.Data, &connData)
+		err = UnmarshalDataString(event.Data, &connInfo)
+		if err == nil {
+			err = UnmarshalData(connInfo, &connData)
+		}
 		if err != nil {
-			return err
+			c.ws.Close()
+			return connSetup{}, err
 		}
 		c.connected = true
+		c.connectionInfo = connInfo
+		c.connectedSince = c.now()
 		c.done = make(chan struct{})
+		c.doneClosed = false
+		c.generation.next()
+		prevSocketID := c.socketID
 		c.socketID = connData.SocketID
+		oldActivityTimeout := c.activityTimeout
 		c.activityTimeout = time.Duration(connData.ActivityTimeout) * time.Second
-		c.activityTimer = time.NewTimer(c.activityTimeout)
+		if preferred := c.PreferredActivityTimeout.Truncate(time.Second); preferred > 0 && preferred < c.activityTimeout {
+			c.activityTimeout = preferred
+		}
+		if c.MaxActivityTimeout > 0 && c.activityTimeout > c.MaxActivityTimeout {
+			c.activityTimeout = c.MaxActivityTimeout
+		}
+		c.activityTimer = c.newTimer(c.activityTimeout)
 		c.activityTimerReset = make(chan struct{}, 1)
-		c.pongTimer = time.NewTimer(c.pongTimeout)
+		c.pongTimer = c.newTimer(c.pongTimeout)
 		if !c.pongTimer.Stop() {
 			select {
-			case <-c.pongTimer.C:
+			case <-c.pongTimer.C():
 			default:
 			}
 		}
 		c.pongReceived = make(chan struct{}, 1)
+		if c.limiter != nil {
+			// Pusher counts client events per connection, so a new connection
+			// starts with a full bucket.
+			c.limiter.reset(c.now())
+		}
 
 		if c.boundEvents == nil {
 			c.boundEvents = map[string]boundEventChans{}
@@ -198,28 +738,186 @@ func (c *Client) connectInternal() error {
 		if c.subscribedChannels == nil {
 			c.subscribedChannels = subscribedChannels{}
 		}
+		c.unsubscribed = nil
 
-		// Resubscribe to previously subscribed channels after reconnection
-		previousChannels := make([]string, 0, len(c.subscribedChannels))
-		for channelName, ch := range c.subscribedChannels {
-			previousChannels = append(previousChannels, channelName)
+		// Resubscribe to previously subscribed channels after reconnection,
+		// except those subscribed with WithoutAutoResubscribe, which are
+		// dropped
+		previousChannels := make([]internalChannel, 0, len(c.subscribedChannels))
+		for name, ch := range c.subscribedChannels {
 			ch.ResetSubscriptionState()
+			if !ch.resubscribeOnReconnect() {
+				delete(c.subscribedChannels, name)
+				continue
+			}
+			previousChannels = append(previousChannels, ch)
 		}
 
-		go c.heartbeat()
-		go c.listen()
+		c.recordActivity()
+		c.spawn(c.heartbeat)
+		c.spawn(c.listen)
+		c.spawn(c.watchdog)
+
+		return connSetup{
+			channels:               previousChannels,
+			socketID:               c.socketID,
+			prevSocketID:           prevSocketID,
+			activityTimeout:        c.activityTimeout,
+			activityTimeoutChanged: c.activityTimeout != oldActivityTimeout,
+		}, nil
+	default:
+		c.ws.Close()
+		return connSetup{}, UnexpectedEventError{Event: event}
+	}
+}
 
-		// Resubscribe to all channels
-		for _, channelName := range previousChannels {
-			if ch, ok := c.subscribedChannels[channelName]; ok {
-				ch.Subscribe()
-			}
+// clusters returns the clusters to connect to in order of priority. An empty
+// cluster name refers to the default host.
+func (c *Client) clusters() []string {
+	if len(c.Clusters) > 0 {
+		return c.Clusters
+	}
+	return []string{c.Cluster}
+}
+
+// dial opens a connection to cluster, with Dial if it's provided.
+func (c *Client) dial(cluster, origin string) (Conn, error) {
+	connURL := c.generateConnURL(c.appKey, cluster)
+	if c.Dial != nil {
+		return c.Dial(connURL, origin)
+	}
+
+	config, err := websocket.NewConfig(connURL, origin)
+	if err != nil {
+		return nil, err
+	}
+	config.TlsConfig = c.tlsConfig()
+	userAgent := c.UserAgent
+	if userAgent == "" {
+		userAgent = clientName + "/" + clientVersion
+	}
+	config.Header.Set("User-Agent", userAgent)
+
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		// DialError doesn't implement Unwrap, so expose the cause to errors.Is
+		var dialErr *websocket.DialError
+		if errors.As(err, &dialErr) {
+			return nil, fmt.Errorf("websocket dial %s: %w", dialErr.Location, dialErr.Err)
 		}
+		return nil, err
+	}
+	ws.MaxPayloadBytes = int(c.maxMessageSize())
+	return websocketConn{ws}, nil
+}
 
-		return nil
-	default:
-		return fmt.Errorf("got unknown event type from Pusher: %s", event.Event)
+// maxMessageSize returns MaxMessageSize, or the default if it isn't set.
+func (c *Client) maxMessageSize() int64 {
+	if c.MaxMessageSize > 0 {
+		return c.MaxMessageSize
+	}
+	return defaultMaxMessageSize
+}
+
+// receiveHandshake receives the first event sent by Pusher on ws, waiting at
+// most HandshakeTimeout for it to arrive.
+func (c *Client) receiveHandshake(ws Conn) (Event, error) {
+	timeout := defaultHandshakeTimeout
+	if c.HandshakeTimeout > 0 {
+		timeout = c.HandshakeTimeout
+	}
+	if err := ws.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return Event{}, err
+	}
+
+	events, err := c.receive(ws)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return Event{}, fmt.Errorf("waiting for connection to be established: %w", ErrTimedOut)
+	}
+	if err != nil {
+		return Event{}, err
+	}
+	if len(events) != 1 {
+		return Event{}, fmt.Errorf("expected a single event while connecting, got %d", len(events))
+	}
+
+	return events[0], ws.SetReadDeadline(time.Time{})
+}
+
+// completeConnection runs the setup that follows each successful connection
+// once the lock has been released: it reports the connection to Metrics,
+// calls OnActivityTimeout, OnSocketID and OnConnect, resubscribes to channels,
+// and sends buffered events. It returns the resubscription error.
+func (c *Client) completeConnection(setup connSetup) error {
+	c.mutex.RLock()
+	onConnect, onSocketID := c.OnConnect, c.OnSocketID
+	onActivityTimeout, metrics := c.OnActivityTimeout, c.Metrics
+	c.mutex.RUnlock()
+
+	if metrics != nil {
+		metrics.SetConnected(true)
+	}
+	if onActivityTimeout != nil && setup.activityTimeoutChanged {
+		onActivityTimeout(setup.activityTimeout)
+	}
+	if onSocketID != nil {
+		onSocketID(setup.prevSocketID, setup.socketID)
+	}
+	if onConnect != nil {
+		onConnect(setup.socketID)
+	}
+
+	err := c.resubscribe(setup.channels)
+	c.flushOutbound()
+	return err
+}
+
+// spawn runs f in a goroutine tracked by the client's wait group.
+func (c *Client) spawn(f func()) {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		f()
+	}()
+}
+
+// resubscribe subscribes to channels that were subscribed before the client
+// reconnected, and waits for each subscription to succeed or fail. Up to
+// ResubscribeConcurrency subscriptions are sent concurrently so that one slow
+// confirmation doesn't delay the others. Each failed subscription is reported
+// on Errors with the name of the channel, and the returned error joins them.
+func (c *Client) resubscribe(channels []internalChannel) error {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, c.subscribeConcurrency())
+	errs := make([]error, len(channels))
+	for i, ch := range channels {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, ch internalChannel) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := ch.Subscribe(); err != nil {
+				errs[i] = fmt.Errorf("resubscription to %s failed: %w", ch.Name(), err)
+				c.sendError(errs[i])
+			}
+		}(i, ch)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// subscribeConcurrency returns the maximum number of subscriptions to send at
+// once when subscribing to several channels.
+func (c *Client) subscribeConcurrency() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if c.ResubscribeConcurrency <= 0 {
+		return defaultResubscribeConcurrency
 	}
+	return c.ResubscribeConcurrency
 }
 
 func (c *Client) isConnected() bool {
@@ -229,124 +927,659 @@ func (c *Client) isConnected() bool {
 	return c.connected
 }
 
+// SocketID returns the socket ID assigned by Pusher for the current
+// connection. It is empty until a connection is established, and changes each
+// time the client reconnects.
+func (c *Client) SocketID() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.socketID
+}
+
+// ActivityTimeout returns the activity timeout negotiated with Pusher for the
+// current connection.
+func (c *Client) ActivityTimeout() time.Duration {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.activityTimeout
+}
+
+// ConnectionInfo returns the data sent by Pusher in the connection_established
+// event of the current or most recent connection, decoded from its
+// double-encoded form, such as `{"socket_id":"123.456","activity_timeout":120}`.
+// It holds every field sent, including those the client doesn't use, which
+// can help when debugging the handshake. It is nil until a connection is
+// established.
+func (c *Client) ConnectionInfo() json.RawMessage {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return bytes.Clone(c.connectionInfo)
+}
+
+// ActiveCluster returns the cluster of the current or most recent connection.
+// It is empty when connected to the default host.
+func (c *Client) ActiveCluster() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.activeCluster
+}
+
+// Latency returns the round-trip time of the most recent ping answered by
+// Pusher. It is zero until a pong has been received.
+func (c *Client) Latency() time.Duration {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.latency
+}
+
+// sendPing records the time the ping is sent, so that the round trip can be
+// measured when the pong is received, and then sends it on ws.
+func (c *Client) sendPing(ws Conn) error {
+	c.mutex.Lock()
+	c.pingSentAt = c.now()
+	useNativePing := c.UseNativePing
+	payload := c.pingMessage()
+	c.mutex.Unlock()
+
+	if pinger, ok := ws.(Pinger); ok && useNativePing {
+		if err := c.writePing(pinger); err != nil {
+			return err
+		}
+	}
+	return c.write(ws, payload)
+}
+
+// pingMessage returns the message sent as a ping.
+func (c *Client) pingMessage() []byte {
+	if c.PingPayload == "" {
+		return []byte(pingPayload)
+	}
+	return []byte(c.PingPayload)
+}
+
+// pongMessage returns the message sent in reply to a ping.
+func (c *Client) pongMessage() []byte {
+	if c.PongPayload == "" {
+		return []byte(pongPayload)
+	}
+	return []byte(c.PongPayload)
+}
+
+// validatePayloads checks that PingPayload and PongPayload, if set, are events
+// with the expected names, so that a mistake is reported by Connect rather
+// than by the connection timing out.
+func (c *Client) validatePayloads() error {
+	for _, p := range []struct {
+		field, payload, event string
+	}{
+		{"PingPayload", c.PingPayload, pusherPing},
+		{"PongPayload", c.PongPayload, pusherPong},
+	} {
+		if p.payload == "" {
+			continue
+		}
+		var event Event
+		if err := json.Unmarshal([]byte(p.payload), &event); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidPayload, p.field, err)
+		}
+		if event.Event != p.event {
+			return fmt.Errorf("%w: %s: expected event %q, got %q", ErrInvalidPayload, p.field, p.event, event.Event)
+		}
+	}
+	return nil
+}
+
+// writePing sends a native ping control frame on pinger. It's serialized with
+// the other writes.
+func (c *Client) writePing(pinger Pinger) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	return pinger.WritePing(nil)
+}
+
+// write sends msg on ws as a single message. Writes are serialized so that
+// frames sent from different goroutines never interleave.
+func (c *Client) write(ws Conn, msg []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if c.OnFrame != nil {
+		c.OnFrame(DirectionSent, msg)
+	}
+
+	if err := ws.WriteMessage(msg); err != nil {
+		return err
+	}
+	c.bytesSent.Add(uint64(len(msg)))
+	c.recordActivity()
+	return nil
+}
+
+// receive receives a message from ws and decodes the events it contains.
+// Errors are returned as a ReadError.
+func (c *Client) receive(ws Conn) ([]Event, error) {
+	msg, err := ws.ReadMessage()
+	if err != nil {
+		if errors.Is(err, websocket.ErrFrameTooLarge) {
+			// The rest of the message is discarded by the next receive
+			return nil, ReadError{
+				Kind: ReadErrorTooLarge,
+				Err:  fmt.Errorf("%w: exceeds %d bytes", ErrMessageTooLarge, c.maxMessageSize()),
+			}
+		}
+		return nil, classifyReadError(err)
+	}
+	c.bytesReceived.Add(uint64(len(msg)))
+	c.recordActivity()
+
+	if c.OnFrame != nil {
+		c.OnFrame(DirectionReceived, msg)
+	}
+
+	events, err := decodeEvents(msg)
+	if err != nil {
+		return events, ReadError{Kind: ReadErrorDecode, Err: err}
+	}
+	return events, nil
+}
+
+// decodeEvents decodes the events in msg, which is either a single event or,
+// for servers that batch events, an array of them. The elements of an array
+// that can't be decoded are skipped, and the returned error joins their
+// errors, so that one malformed element doesn't lose the rest of the batch.
+func decodeEvents(msg []byte) ([]Event, error) {
+	if trimmed := bytes.TrimLeft(msg, " \t\r\n"); len(trimmed) == 0 || trimmed[0] != '[' {
+		var event Event
+		if err := json.Unmarshal(msg, &event); err != nil {
+			return nil, err
+		}
+		return []Event{event}, nil
+	}
+
+	var elems []json.RawMessage
+	if err := json.Unmarshal(msg, &elems); err != nil {
+		return nil, fmt.Errorf("decoding batched events: %w", err)
+	}
+	events := make([]Event, 0, len(elems))
+	var errs []error
+	for i, elem := range elems {
+		var event Event
+		if err := json.Unmarshal(elem, &event); err != nil {
+			errs = append(errs, fmt.Errorf("decoding batched event %d: %w", i, err))
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, errors.Join(errs...)
+}
+
+// writeEvent encodes e as JSON and sends it on ws with write.
+func (c *Client) writeEvent(ws Conn, e Event) error {
+	msg, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return c.write(ws, msg)
+}
+
+// isClosedLocked reports whether the client has been permanently shut down. The
+// mutex must be held by the caller.
+func (c *Client) isClosedLocked() bool {
+	if c.closed == nil {
+		return false
+	}
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// shutdownLocked marks the client as permanently shut down, releasing any
+// callers blocked in Wait. The mutex must be held by the caller.
+func (c *Client) shutdownLocked(err error) {
+	if c.closed == nil {
+		c.closed = make(chan struct{})
+	}
+	if c.isClosedLocked() {
+		return
+	}
+	c.closeErr = err
+	close(c.closed)
+	if err != nil {
+		c.setLastError(err)
+	}
+}
+
 func (c *Client) resetActivityTimer() {
+	c.mutex.RLock()
+	activityTimerReset := c.activityTimerReset
+	c.mutex.RUnlock()
+
 	select {
-	case c.activityTimerReset <- struct{}{}:
+	case activityTimerReset <- struct{}{}:
 		return
 	default:
 		// Timer reset is already requested.
 	}
 }
 
+// recordActivity records a successful read or write on the connection.
+func (c *Client) recordActivity() {
+	c.activityMutex.Lock()
+	c.lastActivity = c.now()
+	c.activityMutex.Unlock()
+}
+
+// watchdog forces a reconnection if nothing has been read from or written to
+// the connection for longer than the activity and pong timeouts allow. The
+// heartbeat normally keeps the connection active or reconnects itself, so
+// this only guards against the heartbeat stopping unexpectedly.
+func (c *Client) watchdog() {
+	// Capture the state of the connection this goroutine serves, since a
+	// reconnection replaces it.
+	c.mutex.RLock()
+	ws, done := c.ws, c.done
+	maxIdle := c.activityTimeout + c.pongTimeout + watchdogMargin
+	c.mutex.RUnlock()
+
+	if maxIdle <= 0 {
+		return
+	}
+
+	interval := maxIdle / 4
+	ticker := c.newTimer(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C():
+			ticker.Reset(interval)
+
+			c.activityMutex.Lock()
+			idle := c.since(c.lastActivity)
+			c.activityMutex.Unlock()
+
+			if idle > maxIdle {
+				err := fmt.Errorf("no activity on connection for %v", idle.Round(time.Millisecond))
+				c.sendError(fmt.Errorf("%w, attempting reconnect", err))
+				c.attemptReconnect(ws, err)
+				return
+			}
+		}
+	}
+}
+
 func (c *Client) heartbeat() {
+	// Capture the state of the connection this goroutine serves, since a
+	// reconnection replaces it.
+	c.mutex.RLock()
+	ws, done := c.ws, c.done
+	activityTimer, activityTimerReset := c.activityTimer, c.activityTimerReset
+	activityTimeout := c.activityTimeout
+	pongTimer, pongTimeout, pongReceived := c.pongTimer, c.pongTimeout, c.pongReceived
+	generation := c.generation.id
+	c.mutex.RUnlock()
+
+	// The timers are created with the connection, so there is nothing to do
+	// without them. A reconnection installs new timers rather than reusing
+	// these, so this goroutine never touches those of the new connection.
+	if activityTimer == nil {
+		return
+	}
+
 	for c.isConnected() {
 		select {
-		case <-c.done:
+		case <-done:
 			return
-		case <-c.activityTimerReset:
-			if c.activityTimer == nil {
+		case <-activityTimerReset:
+			if !activityTimer.Stop() {
+				<-activityTimer.C()
+			}
+			activityTimer.Reset(activityTimeout)
+
+		case <-activityTimer.C():
+			// If the connection was closed while the timer fired, don't ping
+			// it, since it may already have been replaced
+			select {
+			case <-done:
 				return
+			default:
 			}
-			if !c.activityTimer.Stop() {
-				<-c.activityTimer.C
+
+			// Discard any pong that answered an earlier ping, such as one sent
+			// by Ping, so it isn't mistaken for a response to this one
+			select {
+			case <-pongReceived:
+			default:
 			}
-			c.activityTimer.Reset(c.activityTimeout)
 
-		case <-c.activityTimer.C:
 			// Send ping and start pong timeout timer
-			err := websocket.Message.Send(c.ws, pingPayload)
+			err := c.sendPing(ws)
 			if err != nil {
-				c.attemptReconnect()
+				c.attemptReconnect(ws, err)
 				return
 			}
 
 			// Reset and start pong timer
-			if c.pongTimer == nil {
+			if pongTimer == nil {
 				return
 			}
-			if !c.pongTimer.Stop() {
+			if !pongTimer.Stop() {
 				select {
-				case <-c.pongTimer.C:
+				case <-pongTimer.C():
 				default:
 				}
 			}
-			c.pongTimer.Reset(c.pongTimeout)
+			pongTimer.Reset(pongTimeout)
 
 			// Start goroutine to wait for pong response
-			go func() {
+			c.spawn(func() {
 				select {
-				case <-c.pongReceived:
+				case <-pongReceived:
 					// Pong was received, reset failure counter
 					c.mutex.Lock()
-					c.pongFailures = 0
-					c.ReconnectDelay = initialReconnectDelay
+					if c.generation.id == generation {
+						c.resetGenerationLocked()
+					}
 					c.mutex.Unlock()
-				case <-c.pongTimer.C:
+				case <-pongTimer.C():
 					// Pong timeout occurred
 					c.mutex.Lock()
-					c.pongFailures++
+					if c.generation.id != generation {
+						// The connection has already been replaced
+						c.mutex.Unlock()
+						return
+					}
+					c.generation.pongFailures++
+					pongFailures := c.generation.pongFailures
 					c.mutex.Unlock()
 
-					c.sendError(fmt.Errorf("pong timeout occurred, failure count: %d", c.pongFailures))
+					c.sendError(fmt.Errorf("pong timeout occurred, failure count: %d", pongFailures))
 
-					if c.pongFailures >= maxPongFailures {
+					if pongFailures >= maxPongFailures {
 						c.sendError(fmt.Errorf("max pong failures reached (%d), attempting reconnect", maxPongFailures))
-						c.attemptReconnect()
+						c.attemptReconnect(ws, fmt.Errorf("no pong received after %d pings", maxPongFailures))
 						return
 					}
-				case <-c.done:
+				case <-done:
 					return
 				}
-			}()
+			})
 
-			c.activityTimer.Reset(c.activityTimeout)
+			activityTimer.Reset(activityTimeout)
 		}
 	}
 }
 
-func (c *Client) attemptReconnect() {
-	c.mutex.Lock()
-
-	// Don't attempt reconnection if we're already disconnected
-	if !c.connected {
-		c.mutex.Unlock()
-		return
+// attemptReconnect replaces the connection ws, which has failed with cause,
+// with a new connection.
+func (c *Client) attemptReconnect(ws Conn, cause error) {
+	if c.dropConnection(ws, cause) {
+		c.setLastError(cause)
+		// If Pusher asked for an immediate reconnection, the first attempt
+		// isn't delayed.
+		c.reconnect(reconnectImmediately(cause))
 	}
+}
 
-	// Close the current connection and mark as disconnected
-	if c.done != nil {
-		close(c.done)
+// Reconnect replaces the current connection with a new one and resubscribes
+// to the subscribed channels, without waiting for the reconnect delay. It can
+// be used when the connection is known to be stale, such as after waking from
+// sleep. If the new connection can't be established, the error is returned and
+// reconnection continues in the background as if the connection had been
+// lost. It returns ErrNotConnected if the client isn't connected.
+func (c *Client) Reconnect() error {
+	c.mutex.RLock()
+	connected, ws := c.connected, c.ws
+	c.mutex.RUnlock()
+
+	if !connected || !c.dropConnection(ws, ErrReconnectRequested) {
+		return ErrNotConnected
+	}
+
+	c.mutex.Lock()
+	if c.isClosedLocked() {
+		c.mutex.Unlock()
+		return ErrNotConnected
+	}
+	c.resetGenerationLocked()
+	setup, err := c.connectInternal()
+	if err == nil {
+		c.recordReconnectLocked()
+	}
+	metrics := c.Metrics
+	c.mutex.Unlock()
+	if err != nil {
+		c.spawn(func() { c.reconnect(false) })
+		return fmt.Errorf("reconnecting: %w", err)
+	}
+
+	if metrics != nil {
+		metrics.IncReconnects()
+	}
+	return c.completeConnection(setup)
+}
+
+// closeDoneLocked closes the done channel of the current connection, unless it
+// has already been closed. The mutex must be held by the caller.
+func (c *Client) closeDoneLocked() {
+	if c.done != nil && !c.doneClosed {
+		close(c.done)
+		c.doneClosed = true
+	}
+}
+
+// dropConnection closes the connection ws and marks the client as
+// disconnected, reporting cause to OnDisconnect. It returns false if ws is no
+// longer the current connection, such as when it has already been dropped.
+func (c *Client) dropConnection(ws Conn, cause error) bool {
+	c.mutex.Lock()
+
+	// Don't drop the connection if we're already disconnected, or if ws has
+	// already been replaced
+	if !c.connected || c.ws != ws {
+		c.mutex.Unlock()
+		return false
 	}
+
+	// Close the current connection and mark as disconnected
+	c.closeDoneLocked()
 	c.connected = false
 	oldWs := c.ws
+	metrics, onDisconnect := c.Metrics, c.OnDisconnect
 	c.mutex.Unlock()
 
+	if metrics != nil {
+		metrics.SetConnected(false)
+	}
+	if onDisconnect != nil {
+		onDisconnect(cause)
+	}
+
 	// Close old websocket outside of lock
 	oldWs.Close()
+	return true
+}
 
-	// Implement exponential backoff for reconnection attempts
+// reconnect attempts to connect until it succeeds or the client is closed,
+// with exponential backoff between attempts. If immediate is true, the first
+// attempt isn't delayed.
+func (c *Client) reconnect(immediate bool) {
 	for {
-		c.mutex.Lock()
-		delay := c.ReconnectDelay
-		c.ReconnectDelay = min(c.ReconnectDelay*2, maxReconnectDelay)
-		c.mutex.Unlock()
+		c.mutex.RLock()
+		closed := c.closed
+		c.mutex.RUnlock()
+
+		if immediate {
+			immediate = false
+			c.sendError(fmt.Errorf("attempting reconnection immediately"))
+		} else {
+			c.mutex.Lock()
+			_, maxDelay := c.reconnectDelays()
+			delay := c.generation.reconnectDelay
+			c.generation.reconnectDelay = min(delay*2, maxDelay)
+			c.mutex.Unlock()
 
-		c.sendError(fmt.Errorf("attempting reconnection after %v", delay))
-		time.Sleep(delay)
+			c.sendError(fmt.Errorf("attempting reconnection after %v", delay))
+			timer := c.newTimer(delay)
+			select {
+			case <-timer.C():
+			case <-closed:
+				timer.Stop()
+				return
+			}
+		}
 
 		c.mutex.Lock()
-		err := c.connectInternal()
+		if c.isClosedLocked() {
+			c.mutex.Unlock()
+			return
+		}
+		if c.connected {
+			// Connect or Reconnect was called while waiting, and replacing
+			// their connection would leak it.
+			c.mutex.Unlock()
+			return
+		}
+		setup, err := c.connectInternal()
 		if err == nil {
+			c.recordReconnectLocked()
+		}
+		if isPermanentError(err) {
+			// Retrying won't succeed, so give up.
+			c.shutdownLocked(err)
 			c.mutex.Unlock()
-			c.sendError(fmt.Errorf("reconnection successful"))
+			c.sendError(fmt.Errorf("reconnection failed: %w", err))
 			return
 		}
 		c.mutex.Unlock()
+		if err == nil {
+			// Reconnection is only complete once the previous subscriptions
+			// have been confirmed. Failures have already been reported.
+			c.completeConnection(setup)
+			c.mutex.RLock()
+			metrics := c.Metrics
+			c.mutex.RUnlock()
+			if metrics != nil {
+				metrics.IncReconnects()
+			}
+			c.sendError(fmt.Errorf("reconnection successful"))
+			return
+		}
 
-		c.sendError(fmt.Errorf("reconnection failed: %w", err))
+		err = fmt.Errorf("reconnection failed: %w", err)
+		c.setLastError(err)
+		c.sendError(err)
 	}
 }
 
+// connGeneration holds the counters of a connection generation: the
+// connection established by Connect or Reconnect, and those that replace it
+// after it's lost. They're only accessed under the client's mutex, and are
+// updated together with the connection state so that they can't go stale.
+type connGeneration struct {
+	// id identifies the current connection, so that goroutines serving a
+	// connection that has been replaced don't update the counters of its
+	// successor.
+	id uint64
+	// pongFailures is the number of consecutive pings not answered on the
+	// current connection.
+	pongFailures int
+	// reconnectDelay is the delay before the next reconnection attempt. It
+	// backs off across failed attempts and is only reset once a connection is
+	// known to be healthy.
+	reconnectDelay time.Duration
+}
+
+// next starts the generation of a new connection.
+func (g *connGeneration) next() {
+	g.id++
+	g.pongFailures = 0
+}
+
+// resetGenerationLocked resets the pong failure count and the reconnect delay
+// of the current connection generation. The mutex must be held by the caller.
+func (c *Client) resetGenerationLocked() {
+	initialDelay, _ := c.reconnectDelays()
+	c.generation.pongFailures = 0
+	c.generation.reconnectDelay = initialDelay
+}
+
+// recordReconnectLocked records a successful reconnection in the client's
+// stats. The mutex must be held by the caller.
+func (c *Client) recordReconnectLocked() {
+	c.reconnectCount++
+	c.lastReconnect = c.now()
+}
+
+// reconnectImmediately reports whether err is a Pusher error in the 4200-4299
+// range, which asks the client to reconnect without backing off.
+func reconnectImmediately(err error) bool {
+	var eventErr EventError
+	return errors.As(err, &eventErr) && eventErr.Code >= 4200 && eventErr.Code < 4300
+}
+
+// coalescePusherError reports whether err, received in a pusher:error event,
+// should be sent to Errors. Repeats of the previous error within
+// errorCoalesceWindow are only counted, and the next repeat sent afterwards
+// reports how many were skipped.
+func (c *Client) coalescePusherError(err error) (error, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := err.Error()
+	if key == c.lastPusherError && c.since(c.lastPusherErrorAt) < errorCoalesceWindow {
+		c.repeatedPusherErrors++
+		return nil, false
+	}
+
+	repeated := 0
+	if key == c.lastPusherError {
+		repeated = c.repeatedPusherErrors
+	}
+	c.lastPusherError = key
+	c.lastPusherErrorAt = c.now()
+	c.repeatedPusherErrors = 0
+	if repeated > 0 {
+		return fmt.Errorf("%w (repeated %d more times)", err, repeated), true
+	}
+	return err, true
+}
+
+// isPermanentError reports whether err is a Pusher error in the 4000-4099
+// range, after which Pusher closes the connection and reconnecting won't
+// succeed.
+func isPermanentError(err error) bool {
+	var eventErr EventError
+	return errors.As(err, &eventErr) && eventErr.Code >= 4000 && eventErr.Code < 4100
+}
+
+// reconnectDelays returns the initial and maximum reconnect delays, falling
+// back to the defaults if the configured values are unset or inconsistent.
+func (c *Client) reconnectDelays() (initialDelay, maxDelay time.Duration) {
+	initialDelay, maxDelay = initialReconnectDelay, maxReconnectDelay
+	if c.InitialReconnectDelay > 0 {
+		initialDelay = c.InitialReconnectDelay
+	}
+	if c.MaxReconnectDelay > 0 {
+		maxDelay = c.MaxReconnectDelay
+	}
+	if maxDelay < initialDelay {
+		return initialReconnectDelay, maxReconnectDelay
+	}
+	return initialDelay, maxDelay
+}
+
 // Helper function to get the minimum of two durations
 func min(a, b time.Duration) time.Duration {
 	if a < b {
@@ -356,24 +1589,141 @@ func min(a, b time.Duration) time.Duration {
 }
 
 func (c *Client) sendError(err error) {
-	if c.Errors == nil {
+	// errorsMutex is used instead of mutex, since errors are sent while mutex
+	// is held.
+	c.errorsMutex.RLock()
+	errs := c.Errors
+	c.errorsMutex.RUnlock()
+
+	if c.OnError != nil {
+		go c.OnError(err)
+	}
+
+	if errs == nil {
 		return
 	}
 
 	select {
-	case c.Errors <- err:
+	case errs <- err:
 	default:
+		c.droppedErrors.Add(1)
+		if c.ErrorsOverflow != nil {
+			c.ErrorsOverflow(err)
+		}
 	}
 }
 
+// SetErrors replaces the channel that errors are sent to. Unlike assigning
+// Errors directly, it is safe to call while the client is connected. A nil
+// channel stops errors from being sent.
+func (c *Client) SetErrors(errs chan error) {
+	c.errorsMutex.Lock()
+	defer c.errorsMutex.Unlock()
+
+	c.Errors = errs
+}
+
+// SetAuthParams replaces the additional parameters sent in authentication
+// requests. Unlike assigning AuthParams directly, it is safe to call while
+// subscriptions are in progress. params is copied, so it may be modified
+// afterwards.
+func (c *Client) SetAuthParams(params url.Values) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.AuthParams = cloneValues(params)
+}
+
+// SetAuthHeaders replaces the additional HTTP headers sent in authentication
+// requests, such as to rotate an expiring bearer token. Unlike assigning
+// AuthHeaders directly, it is safe to call while subscriptions are in
+// progress. headers is copied, so it may be modified afterwards.
+func (c *Client) SetAuthHeaders(headers http.Header) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.AuthHeaders = headers.Clone()
+}
+
+// authConfig returns the current authentication endpoint, parameters and
+// headers. The returned values must not be modified.
+func (c *Client) authConfig() (string, url.Values, http.Header) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.AuthURL, c.AuthParams, c.AuthHeaders
+}
+
+func cloneValues(values url.Values) url.Values {
+	if values == nil {
+		return nil
+	}
+	clone := make(url.Values, len(values))
+	for key, vals := range values {
+		clone[key] = append([]string(nil), vals...)
+	}
+	return clone
+}
+
+// setLastError records err as the error reported by LastError. It may be
+// called while the mutex is held.
+func (c *Client) setLastError(err error) {
+	c.errorsMutex.Lock()
+	defer c.errorsMutex.Unlock()
+
+	c.lastError = err
+}
+
+// LastError returns the most recent error that ended a connection or
+// prevented one from being established, such as the cause of a lost
+// connection, a failed reconnection attempt or an error from Pusher that shut
+// the client down. Unlike errors sent to Errors, it's recorded even if Errors
+// is nil, so that failures aren't silent. It returns nil if no such error has
+// occurred since Connect was called.
+func (c *Client) LastError() error {
+	c.errorsMutex.RLock()
+	defer c.errorsMutex.RUnlock()
+
+	return c.lastError
+}
+
+// DroppedErrors returns the number of errors that have been dropped because
+// the Errors channel was full.
+func (c *Client) DroppedErrors() uint64 {
+	return c.droppedErrors.Load()
+}
+
+// BytesSent returns the number of bytes of messages sent to Pusher over the
+// lifetime of the client, across reconnections. Websocket framing and control
+// frames aren't counted.
+func (c *Client) BytesSent() uint64 {
+	return c.bytesSent.Load()
+}
+
+// BytesReceived returns the number of bytes of messages received from Pusher
+// over the lifetime of the client, across reconnections, including messages
+// that couldn't be decoded. Websocket framing, control frames and messages
+// discarded for exceeding MaxMessageSize aren't counted.
+func (c *Client) BytesReceived() uint64 {
+	return c.bytesReceived.Load()
+}
+
 func (c *Client) listen() {
+	// Capture the state of the connection this goroutine serves, since a
+	// reconnection replaces it.
+	c.mutex.RLock()
+	ws, done, pongReceived := c.ws, c.done, c.pongReceived
+	c.mutex.RUnlock()
+
 	for c.isConnected() {
 		select {
-		case <-c.done:
+		case <-done:
 			return
 		default:
-			var event Event
-			err := websocket.JSON.Receive(c.ws, &event)
+			events, err := c.receive(ws)
+			for _, event := range events {
+				c.handleEvent(ws, pongReceived, event)
+			}
 			if err != nil {
 				// If the websocket connection was closed, Receive will return an error.
 				// This is expected for an explicit disconnect.
@@ -381,39 +1731,124 @@ func (c *Client) listen() {
 					return
 				}
 				c.sendError(err)
-				// If EOF, the connection has been closed
-				if errors.Is(err, io.EOF) {
-					c.attemptReconnect()
+				// Unless only the message was bad, the connection can't be
+				// read from anymore, so it has been lost
+				var readErr ReadError
+				if errors.As(err, &readErr) && readErr.ConnectionLost() {
+					c.attemptReconnect(ws, err)
 					return
 				}
 				continue
 			}
+		}
+	}
+}
 
-			c.resetActivityTimer()
+// handleEvent processes an event received on ws. A panic while handling the
+// event, such as in a user-provided callback, is recovered and reported on
+// Errors so that the read loop can continue.
+func (c *Client) handleEvent(ws Conn, pongReceived chan struct{}, event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.sendError(fmt.Errorf("recovered from panic while handling %q event: %v\n%s", event.Event, r, debug.Stack()))
+		}
+	}()
 
-			switch event.Event {
-			case pusherPing:
-				websocket.Message.Send(c.ws, pongPayload)
-			case pusherPong:
-				// Signal that pong was received
+	c.resetActivityTimer()
+
+	if metrics := c.Metrics; metrics != nil {
+		metrics.IncEventsReceived(event.Event)
+	}
+
+	c.mutex.Lock()
+	c.eventsReceived++
+	c.sendSystemEventLocked(event)
+	c.sendGlobalLocked(event)
+	if event.Event == pusherInternalSubSucceeded || event.Event == pusherInternalSubError {
+		// Pusher handles requests in order, so the response to a new
+		// subscription follows every event of an earlier one.
+		delete(c.unsubscribed, event.Channel)
+	}
+	c.mutex.Unlock()
+
+	switch event.Event {
+	case pusherPing:
+		c.write(ws, c.pongMessage())
+	case pusherPong:
+		// Signal that pong was received
+		select {
+		case pongReceived <- struct{}{}:
+		default:
+		}
+
+		c.mutex.Lock()
+		for _, waiter := range c.pingWaiters {
+			close(waiter)
+		}
+		c.pingWaiters = nil
+		var rtt time.Duration
+		measured := !c.pingSentAt.IsZero()
+		if measured {
+			rtt = c.since(c.pingSentAt)
+			c.latency = rtt
+			c.pingSentAt = time.Time{}
+		}
+		onPong, metrics := c.OnPong, c.Metrics
+		c.mutex.Unlock()
+
+		if measured && onPong != nil {
+			onPong(rtt)
+		}
+		if measured && metrics != nil {
+			metrics.ObservePongLatency(rtt)
+		}
+	case pusherError:
+		err := extractEventError(event)
+		if coalesced, ok := c.coalescePusherError(err); ok {
+			c.sendError(coalesced)
+		}
+		// Errors in the 4200-4299 range ask the client to reconnect
+		// immediately. Reconnection closes the connection's done channel, so
+		// the read loop serving ws stops once this returns.
+		if reconnectImmediately(err) {
+			c.attemptReconnect(ws, err)
+		}
+		// Errors in the 4000-4099 range mean that Pusher has closed the
+		// connection and that reconnecting with the same parameters won't
+		// succeed, so the client is shut down rather than retrying forever.
+		if isPermanentError(err) {
+			c.disconnect(err)
+		}
+	default:
+		c.deliverOnce(event)
+
+		c.mutex.RLock()
+		defer c.mutex.RUnlock()
+		if c.draining {
+			return
+		}
+		for boundChan, doneChan := range c.boundEvents[event.Event] {
+			if queue := c.orderedChans[boundChan]; queue != nil {
+				queue.push(event)
+				continue
+			}
+			deliver(&c.deliveries, func() {
 				select {
-				case c.pongReceived <- struct{}{}:
-				default:
+				case boundChan <- event:
+				case <-doneChan:
 				}
-			case pusherError:
-				c.sendError(extractEventError(event))
-			default:
-				c.mutex.RLock()
-				for boundChan := range c.boundEvents[event.Event] {
-					go func(boundChan chan Event, event Event) {
-						boundChan <- event
-					}(boundChan, event)
-				}
-				if subChan, ok := c.subscribedChannels[event.Channel]; ok {
-					subChan.handleEvent(event.Event, event.Data)
-				}
-				c.mutex.RUnlock()
-			}
+			})
+		}
+		// Most clients bind no patterns, so exact matches don't pay for them
+		if len(c.boundPatterns) > 0 {
+			c.sendPatternLocked(event)
+		}
+		sendDataMessage(c.boundData[event.Event], c.orderedDataChans, &c.deliveries, event.Data)
+		if _, stale := c.unsubscribed[event.Channel]; stale {
+			return
+		}
+		if subChan, ok := c.subscribedChannels[event.Channel]; ok {
+			subChan.handleEvent(event.Event, event.Data)
 		}
 	}
 }
@@ -423,45 +1858,139 @@ func (c *Client) listen() {
 // already been subscribed, this method will return the existing Channel
 // instance.
 //
-// A channel is always returned, regardless of any errors. The error value
-// indicates if the subscription succeeded. Failed subscriptions may be retried
-// with `Channel.Subscribe()`.
+// A channel is returned regardless of any errors, unless the channel name is
+// invalid, in which case an error wrapping ErrInvalidChannelName is returned
+// without contacting Pusher. Otherwise the error value indicates if the
+// subscription succeeded. Failed subscriptions may be retried with
+// `Channel.Subscribe()`.
+//
+// If the client isn't connected, such as before Connect is called or while it
+// is reconnecting, Subscribe fails fast: ErrNotConnected is returned along
+// with the channel only if it was already registered. With
+// WithQueueWhenDisconnected, the channel is registered instead, and subscribed
+// to once the client connects.
 //
 // See SubscribePresence() for presence channels.
 func (c *Client) Subscribe(channelName string, opts ...SubscribeOption) (Channel, error) {
-	c.mutex.RLock()
-	ch, ok := c.subscribedChannels[channelName]
-	c.mutex.RUnlock()
+	if err := ValidateChannelName(channelName); err != nil {
+		return nil, err
+	}
+	var o subscribeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
 
+	c.mutex.Lock()
+	ch, ok := c.subscribedChannels[channelName]
+	if !c.connected && !o.queueWhenDisconnected {
+		c.mutex.Unlock()
+		return ch, ErrNotConnected
+	}
 	if !ok {
 		baseChan := &channel{
 			name:        channelName,
 			boundEvents: map[string]boundDataChans{},
 			client:      c,
 		}
-		switch {
-		case strings.HasPrefix(channelName, "private-"):
+		switch channelTypeForName(channelName) {
+		case ChannelTypePrivate, ChannelTypePrivateEncrypted:
 			ch = &privateChannel{baseChan}
-		case strings.HasPrefix(channelName, "presence-"):
+		case ChannelTypePresence:
 			ch = newPresenceChannel(baseChan)
 		default:
 			ch = baseChan
 		}
-		c.mutex.Lock()
+		if c.subscribedChannels == nil {
+			c.subscribedChannels = subscribedChannels{}
+		}
 		c.subscribedChannels[channelName] = ch
-		c.mutex.Unlock()
 	}
+	connected := c.connected
+	c.mutex.Unlock()
 
+	if !connected {
+		return ch, nil
+	}
 	return ch, ch.Subscribe(opts...)
 }
 
+// SubscribeMany subscribes to each of the named channels as Subscribe does,
+// and returns the channels keyed by name. Up to ResubscribeConcurrency
+// subscriptions are made concurrently, so that authorization requests and
+// confirmations for different channels overlap.
+//
+// If any subscription fails, the error is a SubscribeManyError holding the
+// error for each channel that failed. As with Subscribe, the returned map
+// holds every channel whose name is valid, including those that failed, which
+// may be retried with `Channel.Subscribe()`.
+func (c *Client) SubscribeMany(channelNames ...string) (map[string]Channel, error) {
+	var (
+		wg       sync.WaitGroup
+		mutex    sync.Mutex
+		channels = make(map[string]Channel, len(channelNames))
+		errs     = SubscribeManyError{}
+	)
+	sem := make(chan struct{}, c.subscribeConcurrency())
+	seen := make(map[string]struct{}, len(channelNames))
+	for _, name := range channelNames {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(name string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			ch, err := c.Subscribe(name)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if ch != nil {
+				channels[name] = ch
+			}
+			if err != nil {
+				errs[name] = err
+			}
+		}(name)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return channels, errs
+	}
+	return channels, nil
+}
+
+// WaitForSubscription blocks until the channel named channelName is
+// subscribed, or until ctx is done, in which case the context's error is
+// returned. It returns immediately if the channel is already subscribed, and
+// returns an error wrapping ErrNotSubscribed if no channel with that name has
+// been subscribed to with Subscribe.
+func (c *Client) WaitForSubscription(ctx context.Context, channelName string) error {
+	c.mutex.RLock()
+	ch, ok := c.subscribedChannels[channelName]
+	c.mutex.RUnlock()
+	if !ok {
+		return fmt.Errorf("channel %q is not registered: %w", channelName, ErrNotSubscribed)
+	}
+
+	select {
+	case <-ch.subscribedChan():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // SubscribePresence creates a subscription to the specified presence channel.
 // If the channel has already been subscribed, this method will return the
 // existing channel instance.
 //
-// A channel is always returned, regardless of any errors. The error value
-// indicates if the subscription succeeded. Failed subscriptions may be retried
-// with `Channel.Subscribe()`.
+// A channel is returned regardless of any errors, unless the channel name is
+// invalid. Otherwise the error value indicates if the subscription succeeded.
+// Failed subscriptions may be retried with `Channel.Subscribe()`.
 //
 // An error is returned if channelName is not a presence channel. Use
 // Subscribe() for other channel types.
@@ -471,60 +2000,618 @@ func (c *Client) SubscribePresence(channelName string, opts ...SubscribeOption)
 	}
 
 	ch, subscribeErr := c.Subscribe(channelName, opts...)
+	if ch == nil {
+		return nil, subscribeErr
+	}
 	return ch.(*presenceChannel), subscribeErr
 }
 
 // Unsubscribe unsubscribes from the specified channel. Events will no longer
 // be received from that channe. Note that a nil error does not mean that the
 // unsubscription was successful, just that the request was sent.
+//
+// Pusher doesn't acknowledge unsubscriptions, so events sent before it handled
+// the request may still arrive. They are dropped, including when the channel
+// is subscribed to again, until the new subscription is confirmed.
 func (c *Client) Unsubscribe(channelName string) error {
 	c.mutex.Lock()
-	defer c.mutex.Unlock()
-
 	ch, ok := c.subscribedChannels[channelName]
 	if !ok || ch == nil {
+		c.mutex.Unlock()
 		return nil
 	}
 
 	delete(c.subscribedChannels, channelName)
+	if c.unsubscribed == nil {
+		c.unsubscribed = map[string]struct{}{}
+	}
+	c.unsubscribed[channelName] = struct{}{}
+	c.mutex.Unlock()
+
 	return ch.Unsubscribe()
 }
 
 // Bind returns a channel to which all matching events received on the connection
-// will be sent.
-func (c *Client) Bind(event string) chan Event {
+// will be sent. Events may be delivered out of order unless WithOrderedDelivery
+// is given.
+func (c *Client) Bind(event string, opts ...BindOption) chan Event {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
 	boundChan := make(chan Event)
+	doneChan := make(chan struct{})
 
+	if c.boundEvents == nil {
+		c.boundEvents = map[string]boundEventChans{}
+	}
 	if c.boundEvents[event] == nil {
 		c.boundEvents[event] = boundEventChans{}
 	}
-	c.boundEvents[event][boundChan] = struct{}{}
+	c.boundEvents[event][boundChan] = doneChan
+
+	if newBindOptions(opts).ordered {
+		if c.orderedChans == nil {
+			c.orderedChans = map[chan Event]*orderedQueue[Event]{}
+		}
+		c.orderedChans[boundChan] = newOrderedQueue(boundChan, doneChan, &c.deliveries)
+	}
+
+	return boundChan
+}
+
+// BindPattern returns a channel to which all events received on the
+// connection whose names match pattern will be sent. In pattern, * matches any
+// sequence of characters, including none, and every other character matches
+// itself, so "order.*" matches "order.created" and "order.updated", and
+// "*-updated" matches "user-updated". A pattern without * only matches the
+// event of that name, as with Bind. Events may be delivered out of order.
+func (c *Client) BindPattern(pattern string) chan Event {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	boundChan := make(chan Event)
+
+	if c.boundPatterns == nil {
+		c.boundPatterns = map[string]boundEventChans{}
+	}
+	if c.boundPatterns[pattern] == nil {
+		c.boundPatterns[pattern] = boundEventChans{}
+	}
+	c.boundPatterns[pattern][boundChan] = make(chan struct{})
+
+	return boundChan
+}
+
+// UnbindPattern removes bindings made with BindPattern for a pattern. If chans
+// are passed, only those bindings will be removed. Otherwise, all bindings for
+// the pattern will be removed.
+func (c *Client) UnbindPattern(pattern string, chans ...chan Event) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(chans) == 0 {
+		stopBindings(c.boundPatterns[pattern])
+		delete(c.boundPatterns, pattern)
+		return
+	}
+
+	for _, boundChan := range chans {
+		if doneChan, ok := c.boundPatterns[pattern][boundChan]; ok {
+			close(doneChan)
+			delete(c.boundPatterns[pattern], boundChan)
+		}
+	}
+}
+
+// sendPatternLocked sends event to the channels bound with BindPattern to
+// patterns that match its name. The mutex must be held by the caller.
+func (c *Client) sendPatternLocked(event Event) {
+	for pattern, boundChans := range c.boundPatterns {
+		if !matchPattern(pattern, event.Event) {
+			continue
+		}
+		for boundChan, doneChan := range boundChans {
+			deliver(&c.deliveries, func() {
+				select {
+				case boundChan <- event:
+				case <-doneChan:
+				}
+			})
+		}
+	}
+}
+
+// matchPattern reports whether name matches pattern, in which * matches any
+// sequence of characters.
+func matchPattern(pattern, name string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == name
+	}
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+	// Matching each middle part at its first occurrence leaves as much of
+	// name as possible for the parts that follow.
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(name, part)
+		if i < 0 {
+			return false
+		}
+		name = name[i+len(part):]
+	}
+	return strings.HasSuffix(name, parts[len(parts)-1])
+}
+
+// BindSystem returns a channel to which all matching pusher: system events
+// received on the connection will be sent, such as pusher:connection_established,
+// pusher:ping, pusher:pong and pusher:error. These events are still handled
+// by the client as usual; the binding only observes them, which is useful for
+// diagnostics. Events may be delivered out of order.
+func (c *Client) BindSystem(event string) chan Event {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	boundChan := make(chan Event)
+
+	if c.systemEvents == nil {
+		c.systemEvents = map[string]boundEventChans{}
+	}
+	if c.systemEvents[event] == nil {
+		c.systemEvents[event] = boundEventChans{}
+	}
+	c.systemEvents[event][boundChan] = make(chan struct{})
 
 	return boundChan
 }
 
-// Unbind removes bindings for an event. If chans are passed, only those bindings
-// will be removed. Otherwise, all bindings for an event will be removed.
+// UnbindSystem removes bindings made with BindSystem for an event. If chans are
+// passed, only those bindings will be removed. Otherwise, all bindings for an
+// event will be removed.
+func (c *Client) UnbindSystem(event string, chans ...chan Event) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(chans) == 0 {
+		stopBindings(c.systemEvents[event])
+		delete(c.systemEvents, event)
+		return
+	}
+
+	for _, boundChan := range chans {
+		if doneChan, ok := c.systemEvents[event][boundChan]; ok {
+			close(doneChan)
+			delete(c.systemEvents[event], boundChan)
+		}
+	}
+}
+
+// sendSystemEventLocked sends event to the channels bound to it with
+// BindSystem, if it is a pusher: system event. The sends happen in their own
+// goroutines, so a slow receiver can't hold up the protocol handling. The mutex
+// must be held by the caller.
+func (c *Client) sendSystemEventLocked(event Event) {
+	if c.draining || !strings.HasPrefix(event.Event, "pusher:") {
+		return
+	}
+	for boundChan, doneChan := range c.systemEvents[event.Event] {
+		deliver(&c.deliveries, func() {
+			select {
+			case boundChan <- event:
+			case <-doneChan:
+			}
+		})
+	}
+}
+
+// BindGlobalFunc registers handler to be called with every event received on
+// the connection, including Pusher protocol events, such as for logging. The
+// handler runs in its own goroutine and is called with one event at a time, in
+// the order they were received, so a slow handler doesn't block the client.
+// A panic in the handler is recovered and sent to Errors. The returned
+// function removes the binding; events not yet passed to the handler are
+// discarded.
+func (c *Client) BindGlobalFunc(handler func(Event)) (unbind func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	events := make(chan Event)
+	done := make(chan struct{})
+	if c.globalFuncs == nil {
+		c.globalFuncs = map[chan Event]*orderedQueue[Event]{}
+	}
+	c.globalFuncs[events] = newOrderedQueue(events, done, &c.deliveries)
+
+	go func() {
+		for {
+			select {
+			case event := <-events:
+				c.callGlobalFunc(handler, event)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+
+			if queue := c.globalFuncs[events]; queue != nil {
+				close(queue.done)
+				delete(c.globalFuncs, events)
+			}
+		})
+	}
+}
+
+// callGlobalFunc calls handler with event, reporting a panic on Errors.
+func (c *Client) callGlobalFunc(handler func(Event), event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.sendError(fmt.Errorf("recovered from panic in global handler for %q event: %v\n%s", event.Event, r, debug.Stack()))
+		}
+	}()
+
+	handler(event)
+}
+
+// sendGlobalLocked queues event for the handlers registered with
+// BindGlobalFunc. The mutex must be held by the caller.
+func (c *Client) sendGlobalLocked(event Event) {
+	if c.draining {
+		return
+	}
+	for _, queue := range c.globalFuncs {
+		queue.push(event)
+	}
+}
+
+// RegisterEvent registers the type of the data of events named name, so that
+// they are decoded and passed to the handlers bound with BindDecoded. proto
+// returns a new value to decode each event's data into, and must return a
+// pointer, such as `func() interface{} { return new(Message) }`. Registering
+// an event again replaces its prototype, and a nil proto removes it. Events
+// that aren't registered are only delivered undecoded, as they are to the
+// other bindings whether or not they are registered.
+func (c *Client) RegisterEvent(name string, proto func() interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if proto == nil {
+		delete(c.registeredEvents, name)
+		return
+	}
+	if c.registeredEvents == nil {
+		c.registeredEvents = map[string]func() interface{}{}
+	}
+	c.registeredEvents[name] = proto
+}
+
+// BindDecoded calls handler with each received event registered with
+// RegisterEvent, and with its data decoded into a new value from the event's
+// prototype, so that an application can handle its catalog of events in one
+// place, such as with a type switch on value. Double-encoded data is handled
+// as by UnmarshalAuto. Data that fails to decode is not passed to handler, and
+// an error naming the event is sent to Errors instead. As with
+// BindGlobalFunc, handler runs in its own goroutine and is called with one
+// event at a time, in the order they were received. The returned function
+// removes the binding.
+func (c *Client) BindDecoded(handler func(event Event, value interface{})) (unbind func()) {
+	return c.BindGlobalFunc(func(event Event) {
+		c.mutex.RLock()
+		proto := c.registeredEvents[event.Event]
+		c.mutex.RUnlock()
+		if proto == nil {
+			return
+		}
+
+		value := proto()
+		if err := UnmarshalAuto(event.Data, value); err != nil {
+			c.sendError(fmt.Errorf("error decoding %q event: %w", event.Event, err))
+			return
+		}
+		handler(event, value)
+	})
+}
+
+// BindOnce returns a channel to which the next matching event received on the
+// connection will be sent, after which the binding is removed. The channel is
+// buffered, so the event is delivered even if it isn't being received yet. The
+// binding can be removed before an event arrives with Unbind.
+func (c *Client) BindOnce(event string) chan Event {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	boundChan := make(chan Event, 1)
+
+	if c.onceEvents == nil {
+		c.onceEvents = map[string]boundEventChans{}
+	}
+	if c.onceEvents[event] == nil {
+		c.onceEvents[event] = boundEventChans{}
+	}
+	// The channel is buffered, so deliveries don't need to be abandoned.
+	c.onceEvents[event][boundChan] = nil
+
+	return boundChan
+}
+
+// deliverOnce sends event to the channels bound to it with BindOnce, and
+// removes their bindings.
+func (c *Client) deliverOnce(event Event) {
+	c.mutex.Lock()
+	onceChans := c.onceEvents[event.Event]
+	delete(c.onceEvents, event.Event)
+	c.mutex.Unlock()
+
+	for boundChan := range onceChans {
+		// The channel is buffered and only ever sent one event, so this
+		// doesn't block.
+		boundChan <- event
+	}
+}
+
+// stopBindings closes the done channels of bindings, abandoning their
+// deliveries in progress and stopping the delivery queues of those made with
+// WithOrderedDelivery.
+func stopBindings(bindings boundEventChans) {
+	for _, doneChan := range bindings {
+		close(doneChan)
+	}
+}
+
+// renewBindingsLocked abandons the deliveries in progress to the bindings made
+// with Bind, BindSystem and BindPattern, so that a binding nobody receives from
+// doesn't hold them up after disconnecting, and gives each binding a new done
+// channel so that it keeps receiving events if the client connects again. The
+// mutex must be held by the caller.
+func (c *Client) renewBindingsLocked() {
+	for _, bindings := range []map[string]boundEventChans{c.boundEvents, c.systemEvents, c.boundPatterns} {
+		for _, boundChans := range bindings {
+			for boundChan, doneChan := range boundChans {
+				close(doneChan)
+				doneChan = make(chan struct{})
+				boundChans[boundChan] = doneChan
+				if c.orderedChans[boundChan] != nil {
+					c.orderedChans[boundChan] = newOrderedQueue(boundChan, doneChan, &c.deliveries)
+				}
+			}
+		}
+	}
+}
+
+// Unbind removes bindings for an event, including those made with BindOnce. If
+// chans are passed, only those bindings will be removed. Otherwise, all
+// bindings for an event will be removed.
 func (c *Client) Unbind(event string, chans ...chan Event) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
 	if len(chans) == 0 {
+		for boundChan, doneChan := range c.boundEvents[event] {
+			close(doneChan)
+			delete(c.orderedChans, boundChan)
+		}
 		delete(c.boundEvents, event)
+		delete(c.onceEvents, event)
 		return
 	}
 
 	eventBoundChans := c.boundEvents[event]
 	for _, boundChan := range chans {
+		if doneChan, ok := eventBoundChans[boundChan]; ok {
+			close(doneChan)
+			delete(eventBoundChans, boundChan)
+			delete(c.orderedChans, boundChan)
+		}
+		delete(c.onceEvents[event], boundChan)
+	}
+}
+
+// BindData returns a channel to which the data of all matching events received
+// on the connection will be sent. Data may be delivered out of order unless
+// WithOrderedDelivery is given.
+func (c *Client) BindData(event string, opts ...BindOption) chan json.RawMessage {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	boundChan := make(chan json.RawMessage)
+	doneChan := make(chan struct{})
+
+	if c.boundData == nil {
+		c.boundData = map[string]boundDataChans{}
+	}
+	if c.boundData[event] == nil {
+		c.boundData[event] = boundDataChans{}
+	}
+	c.boundData[event][boundChan] = doneChan
+
+	if newBindOptions(opts).ordered {
+		if c.orderedDataChans == nil {
+			c.orderedDataChans = map[chan json.RawMessage]*orderedQueue[json.RawMessage]{}
+		}
+		c.orderedDataChans[boundChan] = newOrderedQueue(boundChan, doneChan, &c.deliveries)
+	}
+
+	return boundChan
+}
+
+// UnbindData removes bindings made with BindData for an event. If chans are
+// passed, only those bindings will be removed. Otherwise, all bindings for an
+// event will be removed.
+func (c *Client) UnbindData(event string, chans ...chan json.RawMessage) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(chans) == 0 {
+		for boundChan, doneChan := range c.boundData[event] {
+			close(doneChan)
+			delete(c.orderedDataChans, boundChan)
+		}
+		delete(c.boundData, event)
+		return
+	}
+
+	eventBoundChans := c.boundData[event]
+	for _, boundChan := range chans {
+		doneChan, exists := eventBoundChans[boundChan]
+		if !exists {
+			continue
+		}
+
+		close(doneChan)
 		delete(eventBoundChans, boundChan)
+		delete(c.orderedDataChans, boundChan)
 	}
 }
 
-// SendEvent sends an event on the Pusher connection.
+// BindTyped calls handler with the data of each matching event received on
+// the connection, unmarshalled into a new T. Double-encoded data is handled as
+// by UnmarshalAuto. Data that fails to unmarshal is not passed to handler, and
+// the error is sent to the client's error channel instead. The returned
+// function removes the binding.
+func BindTyped[T any](c *Client, event string, handler func(T), opts ...BindOption) (unbind func()) {
+	dataChan := c.BindData(event, opts...)
+	stop := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case data := <-dataChan:
+				var v T
+				if err := UnmarshalAuto(data, &v); err != nil {
+					c.sendError(fmt.Errorf("error decoding %q event: %w", event, err))
+					continue
+				}
+				handler(v)
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.UnbindData(event, dataChan)
+			close(stop)
+		})
+	}
+}
+
+// UnbindAll removes all event bindings on the connection, including those made
+// with BindData, BindOnce, BindSystem, BindPattern and BindGlobalFunc. The
+// bound channels are not closed.
+func (c *Client) UnbindAll() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, bindings := range []map[string]boundEventChans{c.boundEvents, c.systemEvents, c.boundPatterns} {
+		for _, boundChans := range bindings {
+			stopBindings(boundChans)
+		}
+	}
+	for _, queue := range c.globalFuncs {
+		close(queue.done)
+	}
+	c.globalFuncs = nil
+	c.boundEvents = map[string]boundEventChans{}
+	c.orderedChans = nil
+	c.onceEvents = nil
+	c.systemEvents = nil
+	c.boundPatterns = nil
+
+	for _, eventBoundChans := range c.boundData {
+		for _, doneChan := range eventBoundChans {
+			close(doneChan)
+		}
+	}
+	c.boundData = nil
+	c.orderedDataChans = nil
+}
+
+// Ping sends a ping to Pusher and waits for the pong response. ErrTimedOut is
+// returned if the pong isn't received within the pong timeout, and
+// ErrNotConnected is returned if the client is not connected or the connection
+// is lost while waiting.
+func (c *Client) Ping() error {
+	c.mutex.Lock()
+	if !c.connected || c.ws == nil {
+		c.mutex.Unlock()
+		return ErrNotConnected
+	}
+	ws, done, timeout := c.ws, c.done, c.pongTimeout
+	pong := make(chan struct{})
+	c.pingWaiters = append(c.pingWaiters, pong)
+	c.mutex.Unlock()
+
+	if timeout <= 0 {
+		timeout = defaultPongTimeout
+	}
+
+	err := c.sendPing(ws)
+	if err == nil {
+		timer := c.newTimer(timeout)
+		defer timer.Stop()
+
+		select {
+		case <-pong:
+			return nil
+		case <-done:
+			err = ErrNotConnected
+		case <-timer.C():
+			err = ErrTimedOut
+		}
+	}
+
+	c.mutex.Lock()
+	for i, waiter := range c.pingWaiters {
+		if waiter == pong {
+			c.pingWaiters = append(c.pingWaiters[:i], c.pingWaiters[i+1:]...)
+			break
+		}
+	}
+	c.mutex.Unlock()
+
+	return err
+}
+
+// SendEventContext sends an event on the Pusher connection like SendEvent, but
+// returns the context's error if ctx is done before the event is written, such
+// as when the connection is congested or waiting on ClientEventRate. The write
+// isn't cancelled, so the event may still be sent after SendEventContext has
+// returned.
+func (c *Client) SendEventContext(ctx context.Context, event string, data interface{}, channelName string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- c.SendEvent(event, data, channelName)
+	}()
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SendEvent sends an event on the Pusher connection. ErrNotConnected is
+// returned if the client is not connected, unless BufferOutbound is set. Client
+// events are subject to ClientEventRate. ErrReceiveOnly is returned for events
+// other than Pusher protocol events if ReceiveOnly is set.
 func (c *Client) SendEvent(event string, data interface{}, channelName string) error {
+	if c.ReceiveOnly && !strings.HasPrefix(event, "pusher:") {
+		return ErrReceiveOnly
+	}
+
 	dataJSON, err := json.Marshal(data)
 	if err != nil {
 		return err
@@ -536,25 +2623,247 @@ func (c *Client) SendEvent(event string, data interface{}, channelName string) e
 		Channel: channelName,
 	}
 
+	c.mutex.RLock()
+	connected, ws := c.connected, c.ws
+	c.mutex.RUnlock()
+	if !connected || ws == nil {
+		var buffered bool
+		if ws, buffered, err = c.buffer(e); buffered || err != nil {
+			return err
+		}
+	}
+
+	if err = c.limitClientEvent(event, c.BlockOnRateLimit); err != nil {
+		return err
+	}
+
 	c.resetActivityTimer()
 
-	return websocket.JSON.Send(c.ws, e)
+	return c.writeEvent(ws, e)
 }
 
-// Disconnect closes the websocket connection to Pusher. Any subsequent operations
-// are invalid until Connect is called again.
-func (c *Client) Disconnect() error {
+// buffer adds e to the outbound buffer if BufferOutbound is set, and reports
+// whether it did. If the client has connected since the caller checked, the
+// connection is returned instead so that e can be sent. Only buffering takes
+// the write lock, so that sending while connected never waits for the read
+// loop, which holds the read lock while it dispatches events.
+func (c *Client) buffer(e Event) (Conn, bool, error) {
+	if !c.BufferOutbound || strings.HasPrefix(e.Event, "pusher:") {
+		return nil, false, ErrNotConnected
+	}
+
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
+	if c.isClosedLocked() {
+		return nil, false, ErrNotConnected
+	}
+	if c.connected && c.ws != nil {
+		return c.ws, false, nil
+	}
+	return nil, true, c.bufferLocked(e)
+}
+
+// bufferLocked adds e to the outbound buffer. The mutex must be held by the
+// caller.
+func (c *Client) bufferLocked(e Event) error {
+	maxEvents := c.MaxBufferedEvents
+	if maxEvents <= 0 {
+		maxEvents = defaultMaxBufferedEvents
+	}
+	if len(c.outbound) >= maxEvents {
+		err := fmt.Errorf("dropping event %q: %w", e.Event, ErrBufferFull)
+		c.sendError(err)
+		return err
+	}
+
+	c.outbound = append(c.outbound, bufferedEvent{event: e, queuedAt: c.now()})
+	return nil
+}
+
+// flushOutbound sends the client events buffered while disconnected. Events
+// that have expired are dropped. If sending fails, the unsent events remain
+// buffered and the error is returned.
+func (c *Client) flushOutbound() error {
+	c.outboundMutex.Lock()
+	defer c.outboundMutex.Unlock()
+
+	c.mutex.Lock()
+	queued := c.outbound
+	c.outbound = nil
+	ttl := c.BufferedEventTTL
+	c.mutex.Unlock()
+
+	if ttl <= 0 {
+		ttl = defaultBufferedEventTTL
+	}
+
+	for i, queuedEvent := range queued {
+		if c.since(queuedEvent.queuedAt) > ttl {
+			c.sendError(fmt.Errorf("dropping expired buffered event %q", queuedEvent.event.Event))
+			continue
+		}
+
+		c.mutex.RLock()
+		connected, ws := c.connected, c.ws
+		c.mutex.RUnlock()
+
+		var err error
+		if !connected || ws == nil {
+			err = ErrNotConnected
+		} else if err = c.limitClientEvent(queuedEvent.event.Event, true); err == nil {
+			err = c.writeEvent(ws, queuedEvent.event)
+		}
+		if err != nil {
+			c.sendError(fmt.Errorf("sending buffered event %q: %w", queuedEvent.event.Event, err))
+
+			c.mutex.Lock()
+			c.outbound = append(queued[i:len(queued):len(queued)], c.outbound...)
+			c.mutex.Unlock()
+			return fmt.Errorf("%d buffered events not sent: %w", len(queued)-i, err)
+		}
+	}
+	return nil
+}
+
+// Flush blocks until every event passed to SendEvent before it was called has
+// been written to the connection, including client events buffered while
+// disconnected, which are sent if the client is connected. It can be used
+// before Disconnect to make sure a burst of events isn't cut short. Written
+// events have been handed to the operating system, but may not have reached
+// Pusher yet; Pusher doesn't acknowledge client events. If buffered events
+// can't be sent, an error wrapping the cause is returned and they remain
+// buffered.
+func (c *Client) Flush() error {
+	err := c.flushOutbound()
+
+	// Writes in progress hold the write lock until they complete.
+	c.writeMutex.Lock()
+	c.writeMutex.Unlock()
+
+	return err
+}
+
+// Disconnect closes the websocket connection to Pusher and waits for the
+// goroutines serving the connection to exit. Any subsequent operations return
+// ErrNotConnected until Connect is called again. Events that channels bound
+// with Bind, BindSystem or BindPattern haven't received yet are discarded.
+//
+// Disconnect must not be called from a function invoked by the client, such as
+// an OnSocketID callback, since it waits for the client's goroutines to exit.
+func (c *Client) Disconnect() error {
+	err := c.disconnect(nil)
+	c.wg.Wait()
+	return err
+}
+
+// DisconnectContext gracefully closes the websocket connection to Pusher. It
+// stops delivering received events to bound channels, waits for the events
+// already being delivered to be received, and then disconnects as Disconnect
+// does. If ctx is done before the pending deliveries complete, the connection
+// is closed anyway and the context's error is returned.
+//
+// Like Disconnect, it must not be called from a function invoked by the
+// client.
+func (c *Client) DisconnectContext(ctx context.Context) error {
+	c.mutex.Lock()
+	c.draining = true
+	c.mutex.Unlock()
+
+	var ctxErr error
+	select {
+	case <-c.deliveries.idle():
+	case <-ctx.Done():
+		ctxErr = ctx.Err()
+	}
+
+	err := c.Disconnect()
+
+	c.mutex.Lock()
+	c.draining = false
+	c.mutex.Unlock()
+
+	if ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// DisconnectWait is like Disconnect, but waits at most timeout for the
+// goroutines serving the connection to exit. ErrTimedOut is returned if they
+// haven't exited by then.
+func (c *Client) DisconnectWait(timeout time.Duration) error {
+	err := c.disconnect(nil)
+
+	exited := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(exited)
+	}()
+
+	timer := c.newTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-exited:
+		return err
+	case <-timer.C():
+		return ErrTimedOut
+	}
+}
+
+// disconnect closes the websocket connection and shuts down the client,
+// recording err as the reason reported by Wait.
+func (c *Client) disconnect(err error) error {
+	c.mutex.Lock()
+
+	c.shutdownLocked(err)
+	c.renewBindingsLocked()
+
 	if !c.connected {
+		c.mutex.Unlock()
 		return nil
 	}
 
-	if c.done != nil {
-		close(c.done)
-	}
+	c.closeDoneLocked()
 	c.connected = false
+	metrics, onDisconnect := c.Metrics, c.OnDisconnect
+	closeErr := c.ws.Close()
+	c.mutex.Unlock()
+
+	if metrics != nil {
+		metrics.SetConnected(false)
+	}
+	if onDisconnect != nil {
+		onDisconnect(err)
+	}
+
+	return closeErr
+}
+
+// Wait blocks until the client has been permanently shut down, and returns the
+// error that ended the connection:
+//   - nil if Disconnect, DisconnectContext or DisconnectWait was called
+//   - the error of Context if it was cancelled
+//   - ErrMaxLifetimeReached if MaxLifetime elapsed
+//   - the EventError sent by Pusher if its code was in the 4000-4099 range,
+//     which means that reconnecting won't succeed
+//   - the error returned by Connect if it failed
+//
+// Wait may be called before Connect, in which case it waits for the connection
+// that Connect establishes.
+func (c *Client) Wait() error {
+	c.mutex.Lock()
+	if c.closed == nil {
+		c.closed = make(chan struct{})
+	}
+	closed := c.closed
+	c.mutex.Unlock()
+
+	<-closed
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 
-	return c.ws.Close()
+	return c.closeErr
 }
@@ -1,6 +1,7 @@
 package pusher
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,12 +9,14 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
 	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -39,6 +42,71 @@ func TestUnmarshalDataString(t *testing.T) {
 	}
 }
 
+func TestUnmarshalData(t *testing.T) {
+	dest := map[string]interface{}{}
+	err := UnmarshalData(json.RawMessage(`{"foo":"A","bar":1}`), &dest)
+	if err != nil {
+		t.Errorf("Expected error to be `nil`, got %v", err)
+	}
+
+	wantData := map[string]interface{}{
+		"foo": "A",
+		"bar": 1.0,
+	}
+	if !reflect.DeepEqual(dest, wantData) {
+		t.Errorf("Expected dest to deep-equal %+v, got %+v", wantData, dest)
+	}
+
+	if err = UnmarshalData(json.RawMessage(`"{\"foo\":\"A\"}"`), &dest); err == nil {
+		t.Error("Expected an error unmarshalling double-encoded data")
+	}
+}
+
+func TestUnmarshalAuto(t *testing.T) {
+	wantData := map[string]interface{}{
+		"foo": "A",
+		"bar": 1.0,
+	}
+
+	testCases := []struct {
+		name string
+		data json.RawMessage
+	}{
+		{"doubleEncoded", json.RawMessage(`"{\"foo\":\"A\",\"bar\":1}"`)},
+		{"singleEncoded", json.RawMessage(`{"foo":"A","bar":1}`)},
+		{"whitespace", json.RawMessage(` "{\"foo\":\"A\",\"bar\":1}"`)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dest := map[string]interface{}{}
+			if err := UnmarshalAuto(tc.data, &dest); err != nil {
+				t.Errorf("Expected error to be `nil`, got %v", err)
+			}
+			if !reflect.DeepEqual(dest, wantData) {
+				t.Errorf("Expected dest to deep-equal %+v, got %+v", wantData, dest)
+			}
+		})
+	}
+
+	t.Run("plainString", func(t *testing.T) {
+		var dest string
+		if err := UnmarshalAuto(json.RawMessage(`"hello"`), &dest); err != nil {
+			t.Errorf("Expected error to be `nil`, got %v", err)
+		}
+		if dest != "hello" {
+			t.Errorf("Expected dest to be %q, got %q", "hello", dest)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		dest := map[string]interface{}{}
+		if err := UnmarshalAuto(json.RawMessage(`[1,2]`), &dest); err == nil {
+			t.Error("Expected an error unmarshalling an array into a map")
+		}
+	})
+}
+
 func TestClientIsConnected(t *testing.T) {
 	t.Run("false", func(t *testing.T) {
 		client := &Client{connected: false}
@@ -54,6 +122,26 @@ func TestClientIsConnected(t *testing.T) {
 	})
 }
 
+func TestClientSocketID(t *testing.T) {
+	client := &Client{}
+	if socketID := client.SocketID(); socketID != "" {
+		t.Errorf("Expected socket ID to be empty before connecting, got %q", socketID)
+	}
+
+	client.socketID = "foo"
+	if socketID := client.SocketID(); socketID != "foo" {
+		t.Errorf("Expected socket ID to be %q, got %q", "foo", socketID)
+	}
+}
+
+func TestClientActivityTimeout(t *testing.T) {
+	wantTimeout := 120 * time.Second
+	client := &Client{activityTimeout: wantTimeout}
+	if timeout := client.ActivityTimeout(); timeout != wantTimeout {
+		t.Errorf("Expected activity timeout to be %v, got %v", wantTimeout, timeout)
+	}
+}
+
 func TestClientResetActivityTimer(t *testing.T) {
 	client := &Client{
 		activityTimerReset: make(chan struct{}, 1),
@@ -72,21 +160,98 @@ func TestClientResetActivityTimer(t *testing.T) {
 }
 
 func TestClientSendError(t *testing.T) {
-	errChan := make(chan error)
-	wantErr := errors.New("foo")
-	client := &Client{Errors: errChan}
+	t.Run("delivered", func(t *testing.T) {
+		errChan := make(chan error, 1)
+		wantErr := errors.New("foo")
+		client := &Client{Errors: errChan}
 
-	var gotErr error
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
-	go func() { gotErr = <-errChan; wg.Done() }()
-	runtime.Gosched()
-	client.sendError(wantErr)
-	wg.Wait()
+		client.sendError(wantErr)
 
-	if !reflect.DeepEqual(gotErr, wantErr) {
-		t.Errorf("Expected to value from error chan to be %+v, got %+v", wantErr, gotErr)
-	}
+		if gotErr := <-errChan; !reflect.DeepEqual(gotErr, wantErr) {
+			t.Errorf("Expected to value from error chan to be %+v, got %+v", wantErr, gotErr)
+		}
+		if client.DroppedErrors() != 0 {
+			t.Errorf("Expected no dropped errors, got %d", client.DroppedErrors())
+		}
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		errChan := make(chan error, 1)
+		var dropped []error
+		client := &Client{
+			Errors: errChan,
+			ErrorsOverflow: func(err error) {
+				dropped = append(dropped, err)
+			},
+		}
+
+		first, second, third := errors.New("foo"), errors.New("bar"), errors.New("baz")
+		client.sendError(first)
+		client.sendError(second)
+		client.sendError(third)
+
+		if gotErr := <-errChan; gotErr != first {
+			t.Errorf("Expected to value from error chan to be %+v, got %+v", first, gotErr)
+		}
+		if client.DroppedErrors() != 2 {
+			t.Errorf("Expected 2 dropped errors, got %d", client.DroppedErrors())
+		}
+		if !reflect.DeepEqual(dropped, []error{second, third}) {
+			t.Errorf("Expected ErrorsOverflow to be called with %v, got %v", []error{second, third}, dropped)
+		}
+	})
+
+	t.Run("setErrors", func(t *testing.T) {
+		client := &Client{}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 100; i++ {
+				client.sendError(errors.New("foo"))
+			}
+		}()
+
+		errChan := make(chan error, 1)
+		client.SetErrors(errChan)
+		<-done
+
+		client.sendError(errors.New("bar"))
+		select {
+		case <-errChan:
+		default:
+			t.Error("Expected errors to be sent to the channel passed to SetErrors")
+		}
+	})
+
+	t.Run("onError", func(t *testing.T) {
+		gotErrs := make(chan error, 3)
+		client := &Client{
+			Errors: make(chan error),
+			OnError: func(err error) {
+				gotErrs <- err
+			},
+		}
+
+		wantErrs := map[error]bool{errors.New("foo"): true, errors.New("bar"): true, errors.New("baz"): true}
+		for err := range wantErrs {
+			client.sendError(err)
+		}
+
+		for i := 0; i < 3; i++ {
+			select {
+			case err := <-gotErrs:
+				if !wantErrs[err] {
+					t.Errorf("Expected OnError to be called with one of %v, got %v", wantErrs, err)
+				}
+				delete(wantErrs, err)
+			case <-time.After(time.Second):
+				t.Fatalf("Expected OnError to be called for every error, missing %v", wantErrs)
+			}
+		}
+		if client.DroppedErrors() != 3 {
+			t.Errorf("Expected 3 errors dropped from the Errors channel, got %d", client.DroppedErrors())
+		}
+	})
 }
 
 func TestClientBind(t *testing.T) {
@@ -104,11 +269,460 @@ func TestClientBind(t *testing.T) {
 	}
 }
 
+func TestClientBindOrdered(t *testing.T) {
+	client := &Client{}
+	boundChan := client.Bind("foo", WithOrderedDelivery())
+
+	for i := 0; i < 100; i++ {
+		client.handleEvent(nil, nil, Event{Event: "foo", Data: json.RawMessage(strconv.Itoa(i))})
+	}
+
+	for i := 0; i < 100; i++ {
+		select {
+		case event := <-boundChan:
+			if want := strconv.Itoa(i); string(event.Data) != want {
+				t.Fatalf("Expected event %s, got %s", want, event.Data)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected to receive event %d", i)
+		}
+	}
+
+	client.Unbind("foo", boundChan)
+	if len(client.orderedChans) != 0 {
+		t.Errorf("Expected Unbind to stop the delivery queue, got %+v", client.orderedChans)
+	}
+}
+
+func TestClientBindData(t *testing.T) {
+	client := &Client{}
+	dataChan := client.BindData("foo")
+	orderedChan := client.BindData("foo", WithOrderedDelivery())
+	wantData := json.RawMessage(`"bar"`)
+
+	client.handleEvent(nil, nil, Event{Event: "foo", Channel: "baz", Data: wantData})
+
+	for _, boundChan := range []chan json.RawMessage{dataChan, orderedChan} {
+		select {
+		case data := <-boundChan:
+			if !reflect.DeepEqual(data, wantData) {
+				t.Errorf("Expected to receive data %s, got %s", wantData, data)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected to receive data")
+		}
+	}
+
+	client.UnbindData("foo", dataChan)
+	if _, ok := client.boundData["foo"][dataChan]; ok {
+		t.Error("Expected UnbindData to remove the binding")
+	}
+	if _, ok := client.boundData["foo"][orderedChan]; !ok {
+		t.Error("Expected UnbindData to keep other bindings")
+	}
+
+	client.UnbindData("foo")
+	if len(client.boundData) != 0 || len(client.orderedDataChans) != 0 {
+		t.Errorf("Expected UnbindData to remove all bindings, got %+v", client.boundData)
+	}
+}
+
+func TestBindTyped(t *testing.T) {
+	type payload struct {
+		Foo string `json:"foo"`
+	}
+
+	t.Run("decoded", func(t *testing.T) {
+		client := &Client{}
+		received := make(chan payload)
+		unbind := BindTyped(client, "foo", func(p payload) { received <- p }, WithOrderedDelivery())
+		defer unbind()
+
+		client.handleEvent(nil, nil, Event{Event: "foo", Data: json.RawMessage(`"{\"foo\":\"A\"}"`)})
+		client.handleEvent(nil, nil, Event{Event: "foo", Data: json.RawMessage(`{"foo":"B"}`)})
+
+		for _, want := range []string{"A", "B"} {
+			select {
+			case p := <-received:
+				if p.Foo != want {
+					t.Errorf("Expected Foo to be %q, got %q", want, p.Foo)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Expected handler to be called")
+			}
+		}
+	})
+
+	t.Run("decodeError", func(t *testing.T) {
+		errChan := make(chan error, 1)
+		client := &Client{Errors: errChan}
+		unbind := BindTyped(client, "foo", func(p payload) {
+			t.Errorf("Expected handler not to be called, got %+v", p)
+		})
+		defer unbind()
+
+		client.handleEvent(nil, nil, Event{Event: "foo", Data: json.RawMessage(`[1]`)})
+
+		select {
+		case err := <-errChan:
+			if !strings.Contains(err.Error(), `"foo"`) {
+				t.Errorf("Expected error to mention the event, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected decode error to be sent")
+		}
+	})
+
+	t.Run("unbind", func(t *testing.T) {
+		client := &Client{}
+		unbind := BindTyped(client, "foo", func(payload) {})
+		unbind()
+		unbind()
+
+		if len(client.boundData["foo"]) != 0 {
+			t.Errorf("Expected unbind to remove the binding, got %+v", client.boundData)
+		}
+	})
+}
+
+func TestClientBindOnce(t *testing.T) {
+	t.Run("deliversOnce", func(t *testing.T) {
+		client := &Client{}
+		onceChan := client.BindOnce("foo")
+
+		client.handleEvent(nil, nil, Event{Event: "foo", Data: json.RawMessage(`"1"`)})
+		client.handleEvent(nil, nil, Event{Event: "foo", Data: json.RawMessage(`"2"`)})
+
+		select {
+		case event := <-onceChan:
+			if string(event.Data) != `"1"` {
+				t.Errorf("Expected the first event, got %+v", event)
+			}
+		default:
+			t.Fatal("Expected the event to be buffered")
+		}
+		select {
+		case event := <-onceChan:
+			t.Errorf("Expected only one event, got %+v", event)
+		default:
+		}
+
+		client.mutex.RLock()
+		defer client.mutex.RUnlock()
+		if len(client.onceEvents) != 0 {
+			t.Errorf("Expected the binding to be removed, got %+v", client.onceEvents)
+		}
+	})
+
+	t.Run("unbind", func(t *testing.T) {
+		client := &Client{}
+		onceChan := client.BindOnce("foo")
+		otherChan := client.BindOnce("foo")
+		client.Unbind("foo", onceChan)
+
+		client.handleEvent(nil, nil, Event{Event: "foo"})
+
+		select {
+		case event := <-onceChan:
+			t.Errorf("Expected no event after Unbind, got %+v", event)
+		default:
+		}
+		select {
+		case <-otherChan:
+		default:
+			t.Error("Expected other bindings to receive the event")
+		}
+	})
+}
+
+func TestClientBindSystem(t *testing.T) {
+	pongs := make(chan struct{}, 1)
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		websocket.JSON.Send(ws, connEstablishedEvent("foo", 120))
+		websocket.Message.Send(ws, pingPayload)
+
+		var event Event
+		for websocket.JSON.Receive(ws, &event) == nil {
+			if event.Event == pusherPong {
+				pongs <- struct{}{}
+			}
+		}
+	}))
+	defer srv.Close()
+
+	host, port, _ := getServerHostPort(srv)
+	client := &Client{
+		Insecure:     true,
+		OverrideHost: host,
+		OverridePort: port,
+	}
+	connChan := client.BindSystem(pusherConnEstablished)
+	pingChan := client.BindSystem(pusherPing)
+	fooChan := client.BindSystem("foo")
+	if err := client.Connect("foo"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	for _, boundChan := range []chan Event{connChan, pingChan} {
+		select {
+		case <-boundChan:
+		case <-time.After(time.Second):
+			t.Fatal("Expected to receive the system event")
+		}
+	}
+	select {
+	case <-pongs:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the client to still answer the ping")
+	}
+
+	client.handleEvent(nil, nil, Event{Event: "foo"})
+	select {
+	case event := <-fooChan:
+		t.Errorf("Expected only pusher: events to be delivered, got %+v", event)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	client.UnbindSystem(pusherPing, pingChan)
+	client.mutex.RLock()
+	defer client.mutex.RUnlock()
+	if len(client.systemEvents[pusherPing]) != 0 {
+		t.Errorf("Expected UnbindSystem to remove the binding, got %+v", client.systemEvents)
+	}
+}
+
+func TestClientBindPattern(t *testing.T) {
+	client := &Client{}
+	orderChan := client.BindPattern("order.*")
+	exactChan := client.BindPattern("order.created")
+	userChan := client.BindPattern("user.*")
+
+	client.handleEvent(nil, nil, Event{Event: "order.updated", Channel: "foo"})
+	select {
+	case event := <-orderChan:
+		if event.Event != "order.updated" {
+			t.Errorf("Expected the order.updated event, got %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the event to be delivered to the matching pattern")
+	}
+	for _, boundChan := range []chan Event{exactChan, userChan} {
+		select {
+		case event := <-boundChan:
+			t.Errorf("Expected only matching patterns to receive the event, got %+v", event)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	client.UnbindPattern("order.*", orderChan)
+	client.handleEvent(nil, nil, Event{Event: "order.created", Channel: "foo"})
+	select {
+	case <-exactChan:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the event to be delivered to the matching pattern")
+	}
+	select {
+	case event := <-orderChan:
+		t.Errorf("Expected UnbindPattern to remove the binding, got %+v", event)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"order.created", "order.created", true},
+		{"order.created", "order.updated", false},
+		{"order.*", "order.created", true},
+		{"order.*", "order.", true},
+		{"order.*", "order", false},
+		{"order.*", "user.created", false},
+		{"*", "", true},
+		{"*-updated", "user-updated", true},
+		{"*-updated", "user-created", false},
+		{"order.*.done", "order.item.done", true},
+		{"order.*.done", "order.item.done.later", false},
+		{"a*b*c", "abc", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "acb", false},
+		{"a*a", "a", false},
+		{"**", "foo", true},
+	}
+
+	for _, tc := range testCases {
+		if got := matchPattern(tc.pattern, tc.name); got != tc.want {
+			t.Errorf("Expected matchPattern(%q, %q) to return %v, got %v", tc.pattern, tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestClientBindDecoded(t *testing.T) {
+	type message struct {
+		Text string `json:"text"`
+	}
+	type count struct {
+		N int `json:"n"`
+	}
+
+	client := &Client{Errors: make(chan error, 1)}
+	client.RegisterEvent("message", func() interface{} { return new(message) })
+	client.RegisterEvent("count", func() interface{} { return new(count) })
+	client.RegisterEvent("removed", func() interface{} { return new(count) })
+	client.RegisterEvent("removed", nil)
+
+	type decoded struct {
+		event string
+		value interface{}
+	}
+	received := make(chan decoded, 10)
+	unbind := client.BindDecoded(func(event Event, value interface{}) {
+		received <- decoded{event.Event, value}
+	})
+	defer unbind()
+
+	for _, event := range []Event{
+		{Event: "message", Data: json.RawMessage(`"{\"text\":\"foo\"}"`)},
+		{Event: "unregistered", Data: json.RawMessage(`{}`)},
+		{Event: "removed", Data: json.RawMessage(`{"n":1}`)},
+		{Event: "count", Data: json.RawMessage(`{"n":"bar"}`)},
+		{Event: "count", Data: json.RawMessage(`{"n":2}`)},
+	} {
+		client.handleEvent(nil, nil, event)
+	}
+
+	for _, want := range []decoded{
+		{"message", &message{Text: "foo"}},
+		{"count", &count{N: 2}},
+	} {
+		select {
+		case got := <-received:
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Expected %+v, got %+v", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected the handler to be called with %+v", want)
+		}
+	}
+	select {
+	case got := <-received:
+		t.Errorf("Expected only registered events to be decoded, got %+v", got)
+	default:
+	}
+
+	select {
+	case err := <-client.Errors:
+		if !strings.Contains(err.Error(), `"count"`) {
+			t.Errorf("Expected the decode error to name the event, got %v", err)
+		}
+	default:
+		t.Error("Expected the decode error to be sent to Errors")
+	}
+}
+
+func TestClientBindGlobalFunc(t *testing.T) {
+	t.Run("ordered", func(t *testing.T) {
+		client := &Client{}
+		received := make(chan Event, 10)
+		unbind := client.BindGlobalFunc(func(event Event) {
+			received <- event
+		})
+
+		wantEvents := []Event{
+			{Event: "foo", Channel: "bar", Data: json.RawMessage(`1`)},
+			{Event: pusherConnEstablished},
+			{Event: "baz"},
+		}
+		for _, event := range wantEvents {
+			client.handleEvent(nil, nil, event)
+		}
+		for _, wantEvent := range wantEvents {
+			select {
+			case event := <-received:
+				if !reflect.DeepEqual(event, wantEvent) {
+					t.Errorf("Expected event %+v, got %+v", wantEvent, event)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Expected the handler to be called with %+v", wantEvent)
+			}
+		}
+
+		unbind()
+		unbind()
+		client.handleEvent(nil, nil, Event{Event: "foo"})
+		select {
+		case event := <-received:
+			t.Errorf("Expected the handler not to be called once unbound, got %+v", event)
+		case <-time.After(20 * time.Millisecond):
+		}
+	})
+
+	t.Run("slowHandler", func(t *testing.T) {
+		client := &Client{}
+		gate := make(chan struct{})
+		defer close(gate)
+		defer client.BindGlobalFunc(func(Event) { <-gate })()
+
+		handled := make(chan struct{})
+		go func() {
+			for i := 0; i < 10; i++ {
+				client.handleEvent(nil, nil, Event{Event: "foo"})
+			}
+			close(handled)
+		}()
+		select {
+		case <-handled:
+		case <-time.After(time.Second):
+			t.Fatal("Expected a blocked handler not to block event handling")
+		}
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		client := &Client{Errors: make(chan error, 1)}
+		received := make(chan Event, 1)
+		defer client.BindGlobalFunc(func(event Event) {
+			if event.Event == "foo" {
+				panic("bar")
+			}
+			received <- event
+		})()
+
+		client.handleEvent(nil, nil, Event{Event: "foo"})
+		client.handleEvent(nil, nil, Event{Event: "baz"})
+		select {
+		case err := <-client.Errors:
+			if !strings.Contains(err.Error(), "bar") {
+				t.Errorf("Expected the panic to be reported, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected the panic to be reported")
+		}
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			t.Fatal("Expected the handler to keep being called after a panic")
+		}
+	})
+
+	t.Run("unbindAll", func(t *testing.T) {
+		client := &Client{}
+		unbind := client.BindGlobalFunc(func(Event) {})
+		client.UnbindAll()
+		unbind()
+		if len(client.globalFuncs) != 0 {
+			t.Errorf("Expected UnbindAll to remove the handler, got %+v", client.globalFuncs)
+		}
+	})
+}
+
 func TestClientUnbind(t *testing.T) {
 	wantChan := "foo"
 	t.Run("eventOnly", func(t *testing.T) {
 		client := Client{boundEvents: map[string]boundEventChans{
-			wantChan: {make(chan Event): struct{}{}},
+			wantChan: {make(chan Event): make(chan struct{})},
 		}}
 		client.Unbind(wantChan)
 
@@ -124,9 +738,9 @@ func TestClientUnbind(t *testing.T) {
 		ch3 := make(chan Event)
 		client := Client{boundEvents: map[string]boundEventChans{
 			wantChan: {
-				ch1: struct{}{},
-				ch2: struct{}{},
-				ch3: struct{}{},
+				ch1: make(chan struct{}),
+				ch2: make(chan struct{}),
+				ch3: make(chan struct{}),
 			},
 		}}
 		client.Unbind(wantChan, ch1, ch3)
@@ -150,18 +764,64 @@ func TestClientUnbind(t *testing.T) {
 	})
 }
 
-func TestClientSendEvent(t *testing.T) {
-	wantEvent := Event{
-		Channel: "foo",
-		Event:   "bar",
-		Data:    json.RawMessage(`{"bar":1,"foo":"A"}`),
+func TestClientUnbindAll(t *testing.T) {
+	client := Client{boundEvents: map[string]boundEventChans{
+		"foo": {make(chan Event): make(chan struct{})},
+		"bar": {make(chan Event): make(chan struct{})},
+	}}
+	client.UnbindAll()
+
+	if len(client.boundEvents) != 0 {
+		t.Errorf("Expected client bound events to be empty, got %+v instead", client.boundEvents)
 	}
 
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
-	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
-		event := Event{}
-		err := websocket.JSON.Receive(ws, &event)
+	// Binding after UnbindAll must still work.
+	client.Bind("foo")
+	if _, ok := client.boundEvents["foo"]; !ok {
+		t.Errorf("Expected client bound events to contain 'foo', got %+v instead", client.boundEvents)
+	}
+}
+
+func TestClientSendEventReadLocked(t *testing.T) {
+	conn := newFakeConn()
+	client := &Client{
+		ws:                 conn,
+		connected:          true,
+		activityTimerReset: make(chan struct{}, 1),
+	}
+
+	// The read loop holds the read lock while it dispatches events, which may
+	// lead to sending, such as when a channel is unsubscribed from.
+	client.mutex.RLock()
+	defer client.mutex.RUnlock()
+
+	errChan := make(chan error, 1)
+	go func() { errChan <- client.SendEvent("foo", "bar", "baz") }()
+	select {
+	case err := <-errChan:
+		if err != nil {
+			t.Errorf("Expected error to be `nil`, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected SendEvent not to wait for the write lock while connected")
+	}
+	if e := conn.next(t); e.Event != "foo" {
+		t.Errorf("Expected the foo event to be sent, got %+v", e)
+	}
+}
+
+func TestClientSendEvent(t *testing.T) {
+	wantEvent := Event{
+		Channel: "foo",
+		Event:   "bar",
+		Data:    json.RawMessage(`{"bar":1,"foo":"A"}`),
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		event := Event{}
+		err := websocket.JSON.Receive(ws, &event)
 		if err != nil {
 			panic(err)
 		}
@@ -178,7 +838,8 @@ func TestClientSendEvent(t *testing.T) {
 	}
 
 	client := &Client{
-		ws:                 ws,
+		ws:                 websocketConn{ws},
+		connected:          true,
 		activityTimerReset: make(chan struct{}, 1),
 	}
 	defer client.Disconnect()
@@ -192,101 +853,229 @@ func TestClientSendEvent(t *testing.T) {
 	<-client.activityTimerReset
 }
 
-func TestClientSubscribe(t *testing.T) {
-	t.Run("existingSubscription", func(t *testing.T) {
-		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {}))
-		defer srv.Close()
-		wsURL := strings.Replace(srv.URL, "http", "ws", 1)
-		ws, err := websocket.Dial(wsURL, "ws", localOrigin)
-		if err != nil {
-			panic(err)
+func TestClientSendEventContext(t *testing.T) {
+	received := make(chan Event, 1)
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		var event Event
+		for websocket.JSON.Receive(ws, &event) == nil {
+			received <- event
 		}
+	}))
+	defer srv.Close()
+	wsURL := strings.Replace(srv.URL, "http", "ws", 1)
+	ws, err := websocket.Dial(wsURL, "ws", localOrigin)
+	if err != nil {
+		panic(err)
+	}
 
-		channelName := "foo"
-		ch := &channel{name: channelName, subscribed: true}
-		client := &Client{
-			subscribedChannels: map[string]internalChannel{channelName: ch},
-			ws:                 ws,
+	client := &Client{
+		ws:                 websocketConn{ws},
+		connected:          true,
+		activityTimerReset: make(chan struct{}, 1),
+	}
+	defer client.Disconnect()
+
+	t.Run("sent", func(t *testing.T) {
+		if err := client.SendEventContext(context.Background(), "foo", "bar", ""); err != nil {
+			t.Fatalf("Expected error to be `nil`, got %v", err)
 		}
-		defer client.Disconnect()
-		ch.client = client
-		subCh, err := client.Subscribe(channelName)
-		if err != nil {
-			panic(err)
+		select {
+		case event := <-received:
+			if event.Event != "foo" {
+				t.Errorf("Expected to receive event foo, got %+v", event)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected the event to be sent")
 		}
+	})
 
-		if ch != subCh.(*channel) {
-			t.Errorf("Expected subCh to be %+v, got %+v", ch, subCh)
+	t.Run("blocked", func(t *testing.T) {
+		// Holding the write lock simulates a write stuck on the connection
+		client.writeMutex.Lock()
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		err := client.SendEventContext(ctx, "foo", "bar", "")
+		client.writeMutex.Unlock()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected context.DeadlineExceeded, got %v", err)
 		}
+		<-received
 	})
 
-	t.Run("newPublicChannel", func(t *testing.T) {
-		channelName := "foo"
-
-		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
-			var evt Event
-			err := websocket.JSON.Receive(ws, &evt)
-			if err != nil {
-				panic(err)
-			}
-			if evt.Event != pusherSubscribe {
-				t.Errorf("Expected event to be %q, got %q", pusherSubscribe, evt.Event)
-			}
+	t.Run("contextDone", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
 
-			err = websocket.JSON.Send(ws, Event{
-				Event:   pusherInternalSubSucceeded,
-				Channel: channelName,
-			})
-			if err != nil {
-				panic(err)
-			}
-		}))
-		defer srv.Close()
-		wsURL := strings.Replace(srv.URL, "http", "ws", 1)
-		ws, err := websocket.Dial(wsURL, "ws", localOrigin)
-		if err != nil {
-			panic(err)
+		if err := client.SendEventContext(ctx, "foo", "bar", ""); !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+		select {
+		case event := <-received:
+			t.Errorf("Expected no event to be sent, got %+v", event)
+		case <-time.After(20 * time.Millisecond):
 		}
+	})
+}
 
-		client := &Client{
-			subscribedChannels: map[string]internalChannel{},
-			ws:                 ws,
-			connected:          true,
+func TestClientReceiveOnly(t *testing.T) {
+	received := make(chan Event, 10)
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		var event Event
+		for websocket.JSON.Receive(ws, &event) == nil {
+			received <- event
 		}
-		defer client.Disconnect()
+	}))
+	defer srv.Close()
+	wsURL := strings.Replace(srv.URL, "http", "ws", 1)
+	ws, err := websocket.Dial(wsURL, "ws", localOrigin)
+	if err != nil {
+		panic(err)
+	}
 
-		go client.listen()
+	client := &Client{
+		ws:                 websocketConn{ws},
+		connected:          true,
+		activityTimerReset: make(chan struct{}, 1),
+		ReceiveOnly:        true,
+	}
+	defer client.Disconnect()
+	ch := &channel{name: "private-foo", client: client}
 
-		subCh, err := client.Subscribe(channelName)
-		if err != nil {
-			panic(err)
+	if err := client.SendEvent("client-foo", "bar", "private-foo"); !errors.Is(err, ErrReceiveOnly) {
+		t.Errorf("Expected SendEvent to return ErrReceiveOnly, got %v", err)
+	}
+	if err := ch.Trigger("client-foo", "bar"); !errors.Is(err, ErrReceiveOnly) {
+		t.Errorf("Expected Trigger to return ErrReceiveOnly, got %v", err)
+	}
+
+	// Protocol events are still sent.
+	if err := client.SendEvent(pusherUnsubscribe, channelData{Channel: "private-foo"}, ""); err != nil {
+		t.Fatalf("Expected error to be `nil`, got %v", err)
+	}
+	select {
+	case event := <-received:
+		if event.Event != pusherUnsubscribe {
+			t.Errorf("Expected only the unsubscribe event to be sent, got %+v", event)
 		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the unsubscribe event to be sent")
+	}
+}
 
-		baseCh := subCh.(*channel)
-		if baseCh.name != channelName {
-			t.Errorf("Expected channel name to be %q, got %q", channelName, baseCh.name)
+func TestClientConcurrentWrites(t *testing.T) {
+	const sends = 50
+	received := make(chan int, 1)
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		count := 0
+		for {
+			var msg string
+			if err := websocket.Message.Receive(ws, &msg); err != nil {
+				break
+			}
+			var event Event
+			if err := json.Unmarshal([]byte(msg), &event); err != nil {
+				t.Errorf("Expected each frame to be a complete event, got %q", msg)
+			}
+			if event.Event == pusherPing {
+				continue
+			}
+			if count++; count == sends {
+				received <- count
+			}
 		}
-	})
+	}))
+	defer srv.Close()
+	wsURL := strings.Replace(srv.URL, "http", "ws", 1)
+	ws, err := websocket.Dial(wsURL, "ws", localOrigin)
+	if err != nil {
+		panic(err)
+	}
 
-	t.Run("newPrivateChannel", func(t *testing.T) {
-		channelName := "private-foo"
+	client := &Client{
+		ws:                 websocketConn{ws},
+		connected:          true,
+		activityTimerReset: make(chan struct{}, 1),
+		ClientEventRate:    -1,
+	}
+	defer client.Disconnect()
 
-		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
-			var evt Event
-			err := websocket.JSON.Receive(ws, &evt)
-			if err != nil {
-				panic(err)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < sends; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := client.SendEvent("client-foo", strings.Repeat("a", 4096), "bar"); err != nil {
+				t.Errorf("Expected SendEvent to succeed, got %v", err)
 			}
-			if evt.Event != pusherSubscribe {
-				t.Errorf("Expected event to be %q, got %q", pusherSubscribe, evt.Event)
+		}()
+		go func() {
+			defer wg.Done()
+			if err := client.sendPing(websocketConn{ws}); err != nil {
+				t.Errorf("Expected ping to be sent, got %v", err)
 			}
+		}()
+	}
+	wg.Wait()
 
-			err = websocket.JSON.Send(ws, Event{
-				Event:   pusherInternalSubSucceeded,
-				Channel: channelName,
-			})
-			if err != nil {
-				panic(err)
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected server to receive %d events", sends)
+	}
+}
+
+func TestClientNotConnected(t *testing.T) {
+	client := &Client{}
+
+	if err := client.SendEvent("foo", nil, "bar"); err != ErrNotConnected {
+		t.Errorf("Expected SendEvent to return %v, got %v", ErrNotConnected, err)
+	}
+
+	ch, err := client.Subscribe("bar")
+	if err != ErrNotConnected {
+		t.Errorf("Expected Subscribe to return %v, got %v", ErrNotConnected, err)
+	}
+	if ch != nil {
+		t.Errorf("Expected Subscribe not to register the channel, got %v", ch)
+	}
+
+	ch, err = client.Subscribe("bar", WithQueueWhenDisconnected())
+	if err != nil {
+		t.Errorf("Expected Subscribe with WithQueueWhenDisconnected to return `nil`, got %v", err)
+	}
+
+	if err = ch.Trigger("foo", nil); err != ErrNotConnected {
+		t.Errorf("Expected Trigger to return %v, got %v", ErrNotConnected, err)
+	}
+
+	if err = client.Unsubscribe("bar"); err != ErrNotConnected {
+		t.Errorf("Expected Unsubscribe to return %v, got %v", ErrNotConnected, err)
+	}
+}
+
+func TestClientBufferOutbound(t *testing.T) {
+	t.Run("replay", func(t *testing.T) {
+		wantEvents := []Event{
+			{Event: "client-foo", Channel: "private-bar", Data: json.RawMessage(`1`)},
+			{Event: "client-foo", Channel: "private-bar", Data: json.RawMessage(`2`)},
+		}
+
+		client := &Client{BufferOutbound: true}
+		for _, e := range wantEvents {
+			if err := client.SendEvent(e.Event, e.Data, e.Channel); err != nil {
+				t.Fatalf("Expected event to be buffered, got %v", err)
+			}
+		}
+
+		received := make(chan Event, len(wantEvents))
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			for range wantEvents {
+				var event Event
+				if err := websocket.JSON.Receive(ws, &event); err != nil {
+					return
+				}
+				received <- event
 			}
 		}))
 		defer srv.Close()
@@ -296,50 +1085,475 @@ func TestClientSubscribe(t *testing.T) {
 			panic(err)
 		}
 
-		authSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Write([]byte(`{}`))
-		}))
+		client.mutex.Lock()
+		client.ws = websocketConn{ws}
+		client.connected = true
+		client.mutex.Unlock()
+		defer client.Disconnect()
+
+		client.flushOutbound()
+
+		for _, wantEvent := range wantEvents {
+			if gotEvent := <-received; !reflect.DeepEqual(gotEvent, wantEvent) {
+				t.Errorf("Expected to receive event %+v, got %+v", wantEvent, gotEvent)
+			}
+		}
+		if len(client.outbound) != 0 {
+			t.Errorf("Expected outbound buffer to be empty, got %+v", client.outbound)
+		}
+	})
 
+	t.Run("full", func(t *testing.T) {
 		client := &Client{
-			subscribedChannels: map[string]internalChannel{},
-			ws:                 ws,
-			connected:          true,
-			AuthURL:            authSrv.URL,
+			BufferOutbound:    true,
+			MaxBufferedEvents: 1,
+			Errors:            make(chan error, 1),
 		}
-		defer client.Disconnect()
+		if err := client.SendEvent("client-foo", nil, "private-bar"); err != nil {
+			t.Fatalf("Expected event to be buffered, got %v", err)
+		}
+		if err := client.SendEvent("client-foo", nil, "private-bar"); !errors.Is(err, ErrBufferFull) {
+			t.Errorf("Expected SendEvent to return %v, got %v", ErrBufferFull, err)
+		}
+		if err := <-client.Errors; !errors.Is(err, ErrBufferFull) {
+			t.Errorf("Expected %v on the Errors channel, got %v", ErrBufferFull, err)
+		}
+	})
 
-		go client.listen()
+	t.Run("expired", func(t *testing.T) {
+		client := &Client{
+			BufferOutbound:   true,
+			BufferedEventTTL: time.Millisecond,
+		}
+		if err := client.SendEvent("client-foo", nil, "private-bar"); err != nil {
+			t.Fatalf("Expected event to be buffered, got %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
 
-		subCh, err := client.Subscribe(channelName)
-		if err != nil {
-			panic(err)
+		// The client isn't connected, so any event that isn't dropped for being
+		// expired would be buffered again.
+		client.flushOutbound()
+		if len(client.outbound) != 0 {
+			t.Errorf("Expected expired event to be dropped, got %+v", client.outbound)
 		}
+	})
 
-		baseCh := subCh.(*privateChannel)
-		if baseCh.name != channelName {
-			t.Errorf("Expected channel name to be %q, got %q", channelName, baseCh.name)
+	t.Run("protocolEvent", func(t *testing.T) {
+		client := &Client{BufferOutbound: true}
+		if err := client.SendEvent(pusherUnsubscribe, nil, ""); err != ErrNotConnected {
+			t.Errorf("Expected SendEvent to return %v, got %v", ErrNotConnected, err)
 		}
 	})
+}
 
-	t.Run("newPresenceChannel", func(t *testing.T) {
-		channelName := "presence-foo"
+func TestClientFlush(t *testing.T) {
+	received := make(chan Event, 10)
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		var event Event
+		for websocket.JSON.Receive(ws, &event) == nil {
+			received <- event
+		}
+	}))
+	defer srv.Close()
 
-		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
-			var evt Event
-			err := websocket.JSON.Receive(ws, &evt)
-			if err != nil {
-				panic(err)
-			}
-			if evt.Event != pusherSubscribe {
-				t.Errorf("Expected event to be %q, got %q", pusherSubscribe, evt.Event)
+	client := &Client{
+		BufferOutbound:     true,
+		activityTimerReset: make(chan struct{}, 1),
+	}
+	if err := client.SendEvent("client-foo", nil, "private-bar"); err != nil {
+		t.Fatalf("Expected event to be buffered, got %v", err)
+	}
+
+	t.Run("notConnected", func(t *testing.T) {
+		if err := client.Flush(); !errors.Is(err, ErrNotConnected) {
+			t.Errorf("Expected Flush to return an error wrapping %v, got %v", ErrNotConnected, err)
+		}
+		if len(client.outbound) != 1 {
+			t.Errorf("Expected the event to remain buffered, got %+v", client.outbound)
+		}
+	})
+
+	wsURL := strings.Replace(srv.URL, "http", "ws", 1)
+	ws, err := websocket.Dial(wsURL, "ws", localOrigin)
+	if err != nil {
+		panic(err)
+	}
+	client.mutex.Lock()
+	client.ws = websocketConn{ws}
+	client.connected = true
+	client.mutex.Unlock()
+	defer client.Disconnect()
+
+	t.Run("buffered", func(t *testing.T) {
+		if err := client.Flush(); err != nil {
+			t.Fatalf("Expected error to be `nil`, got %v", err)
+		}
+		select {
+		case event := <-received:
+			if event.Event != "client-foo" {
+				t.Errorf("Expected the buffered event to be sent, got %+v", event)
 			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected the buffered event to be sent")
+		}
+	})
 
-			data, err := json.Marshal(`
-				{
-					"presence": {
-						"ids": ["1", "2"],
-						"hash": {
-							"1": { "name": "name-1" },
+	t.Run("writeInProgress", func(t *testing.T) {
+		// Holding the write lock simulates a write that hasn't completed.
+		client.writeMutex.Lock()
+		flushed := make(chan error, 1)
+		go func() {
+			flushed <- client.Flush()
+		}()
+
+		select {
+		case <-flushed:
+			t.Fatal("Expected Flush to wait for the write in progress")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		client.writeMutex.Unlock()
+		select {
+		case err := <-flushed:
+			if err != nil {
+				t.Errorf("Expected error to be `nil`, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected Flush to return once the write completed")
+		}
+	})
+}
+
+func TestClientWaitForSubscription(t *testing.T) {
+	newClient := func() (*Client, *channel) {
+		client := &Client{}
+		ch := &channel{name: "foo", client: client}
+		client.subscribedChannels = subscribedChannels{"foo": ch}
+		return client, ch
+	}
+
+	t.Run("subscribed", func(t *testing.T) {
+		client, ch := newClient()
+
+		errChan := make(chan error, 1)
+		go func() {
+			errChan <- client.WaitForSubscription(context.Background(), "foo")
+		}()
+		select {
+		case err := <-errChan:
+			t.Fatalf("Expected to wait for the subscription, got %v", err)
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		ch.handleEvent(pusherInternalSubSucceeded, json.RawMessage(`"{}"`))
+
+		select {
+		case err := <-errChan:
+			if err != nil {
+				t.Errorf("Expected error to be `nil`, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected WaitForSubscription to return once subscribed")
+		}
+
+		if err := client.WaitForSubscription(context.Background(), "foo"); err != nil {
+			t.Errorf("Expected an already subscribed channel to return `nil`, got %v", err)
+		}
+	})
+
+	t.Run("contextDone", func(t *testing.T) {
+		client, _ := newClient()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		if err := client.WaitForSubscription(ctx, "foo"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+		}
+	})
+
+	t.Run("notRegistered", func(t *testing.T) {
+		client, _ := newClient()
+		if err := client.WaitForSubscription(context.Background(), "bar"); !errors.Is(err, ErrNotSubscribed) {
+			t.Errorf("Expected ErrNotSubscribed, got %v", err)
+		}
+	})
+}
+
+func TestClientSubscribe(t *testing.T) {
+	t.Run("existingSubscription", func(t *testing.T) {
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {}))
+		defer srv.Close()
+		wsURL := strings.Replace(srv.URL, "http", "ws", 1)
+		ws, err := websocket.Dial(wsURL, "ws", localOrigin)
+		if err != nil {
+			panic(err)
+		}
+
+		channelName := "foo"
+		ch := &channel{name: channelName, subscribed: true}
+		client := &Client{
+			subscribedChannels: map[string]internalChannel{channelName: ch},
+			ws:                 websocketConn{ws},
+			connected:          true,
+		}
+		defer client.Disconnect()
+		ch.client = client
+		subCh, err := client.Subscribe(channelName)
+		if err != nil {
+			panic(err)
+		}
+
+		if ch != subCh.(*channel) {
+			t.Errorf("Expected subCh to be %+v, got %+v", ch, subCh)
+		}
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		channelName := "foo"
+		subscribes := make(chan Event, 100)
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			var event Event
+			for websocket.JSON.Receive(ws, &event) == nil {
+				if event.Event != pusherSubscribe {
+					continue
+				}
+				subscribes <- event
+				time.Sleep(50 * time.Millisecond)
+				websocket.JSON.Send(ws, Event{Event: pusherInternalSubSucceeded, Channel: channelName})
+			}
+		}))
+		defer srv.Close()
+		wsURL := strings.Replace(srv.URL, "http", "ws", 1)
+		ws, err := websocket.Dial(wsURL, "ws", localOrigin)
+		if err != nil {
+			panic(err)
+		}
+
+		client := &Client{
+			ws:        websocketConn{ws},
+			connected: true,
+		}
+		defer client.Disconnect()
+
+		go client.listen()
+
+		const calls = 20
+		channels := make(chan Channel, calls)
+		wg := &sync.WaitGroup{}
+		for i := 0; i < calls; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ch, err := client.Subscribe(channelName)
+				if err != nil {
+					t.Errorf("Expected Subscribe to succeed, got %v", err)
+				}
+				channels <- ch
+			}()
+		}
+		wg.Wait()
+		close(channels)
+
+		first := <-channels
+		for ch := range channels {
+			if ch != first {
+				t.Errorf("Expected every call to return the same channel, got %p and %p", first, ch)
+			}
+		}
+		if len(subscribes) != 1 {
+			t.Errorf("Expected exactly 1 subscribe event, got %d", len(subscribes))
+		}
+	})
+
+	t.Run("invalidChannelName", func(t *testing.T) {
+		client := &Client{connected: true}
+		ch, err := client.Subscribe("foo bar")
+		if !errors.Is(err, ErrInvalidChannelName) {
+			t.Errorf("Expected error %v, got %v", ErrInvalidChannelName, err)
+		}
+		if ch != nil {
+			t.Errorf("Expected no channel to be returned, got %+v", ch)
+		}
+		if len(client.subscribedChannels) != 0 {
+			t.Errorf("Expected no channel to be registered, got %+v", client.subscribedChannels)
+		}
+	})
+
+	t.Run("notConnected", func(t *testing.T) {
+		client := &Client{}
+		ch, err := client.Subscribe("foo")
+		if err != ErrNotConnected {
+			t.Errorf("Expected error %v, got %v", ErrNotConnected, err)
+		}
+		if ch != nil {
+			t.Errorf("Expected no channel to be returned, got %+v", ch)
+		}
+		if len(client.subscribedChannels) != 0 {
+			t.Errorf("Expected no channel to be registered, got %+v", client.subscribedChannels)
+		}
+	})
+
+	t.Run("queueWhenDisconnected", func(t *testing.T) {
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			websocket.JSON.Send(ws, connEstablishedEvent("foo", 120))
+
+			var event Event
+			for websocket.JSON.Receive(ws, &event) == nil {
+				if event.Event == pusherSubscribe {
+					var data channelData
+					json.Unmarshal(event.Data, &data)
+					websocket.JSON.Send(ws, Event{Event: pusherInternalSubSucceeded, Channel: data.Channel, Data: json.RawMessage(`"{}"`)})
+				}
+			}
+		}))
+		defer srv.Close()
+		host, port, _ := getServerHostPort(srv)
+
+		client := &Client{
+			Insecure:     true,
+			OverrideHost: host,
+			OverridePort: port,
+		}
+		ch, err := client.Subscribe("foo", WithQueueWhenDisconnected())
+		if err != nil {
+			t.Fatalf("Expected error to be `nil`, got %v", err)
+		}
+		if ch.IsSubscribed() {
+			t.Error("Expected the channel not to be subscribed before connecting")
+		}
+
+		if err := client.Connect("foo"); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer client.Disconnect()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := client.WaitForSubscription(ctx, "foo"); err != nil {
+			t.Errorf("Expected the queued subscription to succeed once connected, got %v", err)
+		}
+	})
+
+	t.Run("newPublicChannel", func(t *testing.T) {
+		channelName := "foo"
+
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			var evt Event
+			err := websocket.JSON.Receive(ws, &evt)
+			if err != nil {
+				panic(err)
+			}
+			if evt.Event != pusherSubscribe {
+				t.Errorf("Expected event to be %q, got %q", pusherSubscribe, evt.Event)
+			}
+
+			err = websocket.JSON.Send(ws, Event{
+				Event:   pusherInternalSubSucceeded,
+				Channel: channelName,
+			})
+			if err != nil {
+				panic(err)
+			}
+		}))
+		defer srv.Close()
+		wsURL := strings.Replace(srv.URL, "http", "ws", 1)
+		ws, err := websocket.Dial(wsURL, "ws", localOrigin)
+		if err != nil {
+			panic(err)
+		}
+
+		client := &Client{
+			subscribedChannels: map[string]internalChannel{},
+			ws:                 websocketConn{ws},
+			connected:          true,
+		}
+		defer client.Disconnect()
+
+		go client.listen()
+
+		subCh, err := client.Subscribe(channelName)
+		if err != nil {
+			panic(err)
+		}
+
+		baseCh := subCh.(*channel)
+		if baseCh.name != channelName {
+			t.Errorf("Expected channel name to be %q, got %q", channelName, baseCh.name)
+		}
+	})
+
+	t.Run("newPrivateChannel", func(t *testing.T) {
+		channelName := "private-foo"
+
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			var evt Event
+			err := websocket.JSON.Receive(ws, &evt)
+			if err != nil {
+				panic(err)
+			}
+			if evt.Event != pusherSubscribe {
+				t.Errorf("Expected event to be %q, got %q", pusherSubscribe, evt.Event)
+			}
+
+			err = websocket.JSON.Send(ws, Event{
+				Event:   pusherInternalSubSucceeded,
+				Channel: channelName,
+			})
+			if err != nil {
+				panic(err)
+			}
+		}))
+		defer srv.Close()
+		wsURL := strings.Replace(srv.URL, "http", "ws", 1)
+		ws, err := websocket.Dial(wsURL, "ws", localOrigin)
+		if err != nil {
+			panic(err)
+		}
+
+		authSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{}`))
+		}))
+
+		client := &Client{
+			subscribedChannels: map[string]internalChannel{},
+			ws:                 websocketConn{ws},
+			connected:          true,
+			AuthURL:            authSrv.URL,
+		}
+		defer client.Disconnect()
+
+		go client.listen()
+
+		subCh, err := client.Subscribe(channelName)
+		if err != nil {
+			panic(err)
+		}
+
+		baseCh := subCh.(*privateChannel)
+		if baseCh.name != channelName {
+			t.Errorf("Expected channel name to be %q, got %q", channelName, baseCh.name)
+		}
+	})
+
+	t.Run("newPresenceChannel", func(t *testing.T) {
+		channelName := "presence-foo"
+
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			var evt Event
+			err := websocket.JSON.Receive(ws, &evt)
+			if err != nil {
+				panic(err)
+			}
+			if evt.Event != pusherSubscribe {
+				t.Errorf("Expected event to be %q, got %q", pusherSubscribe, evt.Event)
+			}
+
+			data, err := json.Marshal(`
+				{
+					"presence": {
+						"ids": ["1", "2"],
+						"hash": {
+							"1": { "name": "name-1" },
 							"2": { "name": "name-2" }
 						},
 						"count": 2
@@ -350,98 +1564,2185 @@ func TestClientSubscribe(t *testing.T) {
 				t.Fatal("error marshaling data: ", err)
 			}
 
-			err = websocket.JSON.Send(ws, Event{
-				Event:   pusherInternalSubSucceeded,
-				Channel: channelName,
-				Data:    data,
-			})
-			if err != nil {
-				panic(err)
+			err = websocket.JSON.Send(ws, Event{
+				Event:   pusherInternalSubSucceeded,
+				Channel: channelName,
+				Data:    data,
+			})
+			if err != nil {
+				panic(err)
+			}
+		}))
+		defer srv.Close()
+		wsURL := strings.Replace(srv.URL, "http", "ws", 1)
+		ws, err := websocket.Dial(wsURL, "ws", localOrigin)
+		if err != nil {
+			panic(err)
+		}
+
+		authSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{}`))
+		}))
+
+		client := &Client{
+			subscribedChannels: map[string]internalChannel{},
+			ws:                 websocketConn{ws},
+			connected:          true,
+			AuthURL:            authSrv.URL,
+		}
+		defer client.Disconnect()
+
+		go client.listen()
+
+		subCh, err := client.SubscribePresence(channelName)
+		if err != nil {
+			panic(err)
+		}
+
+		baseCh := subCh.(*presenceChannel)
+		if baseCh.name != channelName {
+			t.Errorf("Expected channel name to be %q, got %q", channelName, baseCh.name)
+		}
+	})
+}
+
+func TestClientSetAuthConfig(t *testing.T) {
+	type authRequest struct {
+		token, param string
+	}
+	requests := make(chan authRequest, 100)
+	authSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		requests <- authRequest{r.Header.Get("Authorization"), r.Form.Get("foo")}
+		w.Write([]byte(`{"auth":"foo:bar"}`))
+	}))
+	defer authSrv.Close()
+
+	client := &Client{AuthURL: authSrv.URL}
+	ch := &privateChannel{&channel{name: "private-foo", client: client}}
+
+	// Rotate the configuration while authorization requests are being made,
+	// so that -race catches unguarded reads.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if _, _, err := ch.authorize(); err != nil {
+				t.Errorf("Expected error to be `nil`, got %v", err)
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		client.SetAuthHeaders(http.Header{"Authorization": {fmt.Sprintf("Bearer %d", i)}})
+		client.SetAuthParams(url.Values{"foo": {fmt.Sprint(i)}})
+	}
+	wg.Wait()
+	for len(requests) > 0 {
+		<-requests
+	}
+
+	// The values passed to the setters are copied.
+	headers := http.Header{"Authorization": {"Bearer new"}}
+	params := url.Values{"foo": {"new"}}
+	client.SetAuthHeaders(headers)
+	client.SetAuthParams(params)
+	headers.Set("Authorization", "Bearer modified")
+	params.Set("foo", "modified")
+
+	if _, _, err := ch.authorize(); err != nil {
+		t.Fatalf("Expected error to be `nil`, got %v", err)
+	}
+	if got, want := <-requests, (authRequest{"Bearer new", "new"}); got != want {
+		t.Errorf("Expected auth request with %+v, got %+v", want, got)
+	}
+}
+
+func TestClientUnsubscribe(t *testing.T) {
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {}))
+	defer srv.Close()
+	wsURL := strings.Replace(srv.URL, "http", "ws", 1)
+	ws, err := websocket.Dial(wsURL, "ws", localOrigin)
+	if err != nil {
+		panic(err)
+	}
+
+	ch := &channel{name: "foo"}
+	client := &Client{
+		subscribedChannels: map[string]internalChannel{"foo": ch},
+		ws:                 websocketConn{ws},
+		connected:          true,
+	}
+	defer client.Disconnect()
+	ch.client = client
+	err = client.Unsubscribe("foo")
+	if err != nil {
+		panic(err)
+	}
+
+	if _, ok := client.subscribedChannels["foo"]; ok {
+		t.Errorf("Expected client subscribed channels not to contain 'foo', got %+v", client.subscribedChannels)
+	}
+}
+
+func TestClientUnsubscribeResubscribe(t *testing.T) {
+	// The server sends an event published before it handled the
+	// unsubscription once the channel is subscribed to again, ahead of
+	// confirming the new subscription.
+	gate := make(chan struct{})
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		websocket.JSON.Send(ws, connEstablishedEvent("foo", 120))
+
+		subscriptions := 0
+		var event Event
+		for websocket.JSON.Receive(ws, &event) == nil {
+			if event.Event != pusherSubscribe {
+				continue
+			}
+			subscriptions++
+			if subscriptions == 2 {
+				<-gate
+				websocket.JSON.Send(ws, Event{Event: "bar", Channel: "foo", Data: json.RawMessage(`"late"`)})
+			}
+			websocket.JSON.Send(ws, Event{Event: pusherInternalSubSucceeded, Channel: "foo", Data: json.RawMessage(`"{}"`)})
+			if subscriptions == 2 {
+				websocket.JSON.Send(ws, Event{Event: "bar", Channel: "foo", Data: json.RawMessage(`"fresh"`)})
+			}
+		}
+	}))
+	defer srv.Close()
+
+	host, port, _ := getServerHostPort(srv)
+	client := &Client{
+		Insecure:     true,
+		OverrideHost: host,
+		OverridePort: port,
+	}
+	if err := client.Connect("foo"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	if _, err := client.Subscribe("foo"); err != nil {
+		t.Fatalf("Expected error to be `nil`, got %v", err)
+	}
+	if err := client.Unsubscribe("foo"); err != nil {
+		t.Fatalf("Expected error to be `nil`, got %v", err)
+	}
+
+	subscribeErr := make(chan error, 1)
+	go func() {
+		_, err := client.Subscribe("foo")
+		subscribeErr <- err
+	}()
+
+	// Bind on the new channel before the server replies.
+	var ch internalChannel
+	for deadline := time.Now().Add(time.Second); ch == nil; {
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for the channel to be registered")
+		}
+		client.mutex.RLock()
+		ch = client.subscribedChannels["foo"]
+		client.mutex.RUnlock()
+		time.Sleep(time.Millisecond)
+	}
+	dataChan := ch.Bind("bar", WithOrderedDelivery())
+	close(gate)
+
+	if err := <-subscribeErr; err != nil {
+		t.Fatalf("Expected error to be `nil`, got %v", err)
+	}
+	select {
+	case data := <-dataChan:
+		if string(data) != `"fresh"` {
+			t.Errorf("Expected the event of the earlier subscription to be dropped, got %s", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected to receive the event of the new subscription")
+	}
+}
+
+func TestClientDisconnect(t *testing.T) {
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {}))
+	defer srv.Close()
+	wsURL := strings.Replace(srv.URL, "http", "ws", 1)
+	ws, err := websocket.Dial(wsURL, "ws", localOrigin)
+	if err != nil {
+		panic(err)
+	}
+
+	client := &Client{
+		connected: true,
+		ws:        websocketConn{ws},
+	}
+
+	err = client.Disconnect()
+	if err != nil {
+		panic(err)
+	}
+
+	if client.connected {
+		t.Errorf("Expected client connected to be false, got true")
+	}
+	if err = ws.Close(); err == nil {
+		t.Errorf("Expected websocket connection to have been closed, got no error closing again")
+	}
+}
+
+func TestClientDisconnectGoroutines(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		websocket.JSON.Send(ws, connEstablishedEvent("foo", 120))
+		var event Event
+		websocket.JSON.Receive(ws, &event)
+	}))
+	host, port, _ := getServerHostPort(srv)
+
+	client := &Client{
+		Insecure:     true,
+		OverrideHost: host,
+		OverridePort: port,
+	}
+
+	if err := client.Connect(""); err != nil {
+		panic(err)
+	}
+	if err := client.Disconnect(); err != nil {
+		panic(err)
+	}
+	srv.Close()
+
+	// Goroutines belonging to the test server may take a moment to exit after
+	// it's closed, but the client's own goroutines must already have exited.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > baseline {
+		t.Errorf("Expected goroutine count to return to %d after Disconnect, got %d", baseline, n)
+	}
+}
+
+func TestClientDisconnectDuringReconnect(t *testing.T) {
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		websocket.JSON.Send(ws, connEstablishedEvent("foo", 120))
+		var event Event
+		for websocket.JSON.Receive(ws, &event) == nil {
+		}
+	}))
+	defer srv.Close()
+	host, port, _ := getServerHostPort(srv)
+
+	// Tearing the connection down from both sides at once must close its done
+	// channel only once. Run with -race to also check for unguarded access.
+	for i := 0; i < 20; i++ {
+		client := &Client{
+			Insecure:              true,
+			OverrideHost:          host,
+			OverridePort:          port,
+			InitialReconnectDelay: time.Millisecond,
+		}
+		if err := client.Connect("foo"); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		client.mutex.RLock()
+		ws := client.ws
+		client.mutex.RUnlock()
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			client.attemptReconnect(ws, errors.New("connection lost"))
+		}()
+		go func() {
+			defer wg.Done()
+			client.Disconnect()
+		}()
+		wg.Wait()
+		client.Disconnect()
+	}
+
+	t.Run("closeTwice", func(t *testing.T) {
+		client := &Client{done: make(chan struct{})}
+		client.closeDoneLocked()
+		client.closeDoneLocked()
+		select {
+		case <-client.done:
+		default:
+			t.Error("Expected done to be closed")
+		}
+	})
+}
+
+func TestClientDisconnectWait(t *testing.T) {
+	client := &Client{}
+	client.wg.Add(1)
+	defer client.wg.Done()
+
+	if err := client.DisconnectWait(10 * time.Millisecond); err != ErrTimedOut {
+		t.Errorf("Expected DisconnectWait to return %v, got %v", ErrTimedOut, err)
+	}
+}
+
+func TestClientDisconnectContext(t *testing.T) {
+	newClient := func(t *testing.T) *Client {
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			var event Event
+			for websocket.JSON.Receive(ws, &event) == nil {
+			}
+		}))
+		t.Cleanup(srv.Close)
+		wsURL := strings.Replace(srv.URL, "http", "ws", 1)
+		ws, err := websocket.Dial(wsURL, "ws", localOrigin)
+		if err != nil {
+			panic(err)
+		}
+		return &Client{connected: true, ws: websocketConn{ws}}
+	}
+
+	t.Run("drained", func(t *testing.T) {
+		client := newClient(t)
+		eventChan := client.Bind("foo")
+		orderedChan := client.Bind("foo", WithOrderedDelivery())
+		client.handleEvent(nil, nil, Event{Event: "foo"})
+
+		received := make(chan struct{})
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			<-eventChan
+			<-orderedChan
+			close(received)
+		}()
+
+		if err := client.DisconnectContext(context.Background()); err != nil {
+			t.Errorf("Expected error to be `nil`, got %v", err)
+		}
+		select {
+		case <-received:
+		default:
+			t.Error("Expected pending events to be delivered before disconnecting")
+		}
+		if client.isConnected() {
+			t.Error("Expected client to be disconnected")
+		}
+	})
+
+	t.Run("presenceMembers", func(t *testing.T) {
+		client := newClient(t)
+		ch := newPresenceChannel(&channel{name: "presence-foo", client: client})
+		client.subscribedChannels = subscribedChannels{"presence-foo": ch}
+		addedChan := ch.BindMemberAdded()
+		removedChan := ch.BindMemberRemoved()
+		client.handleEvent(nil, nil, Event{
+			Event:   pusherInternalMemberAdded,
+			Channel: "presence-foo",
+			Data:    json.RawMessage(`"{\"user_id\":\"1\"}"`),
+		})
+		client.handleEvent(nil, nil, Event{
+			Event:   pusherInternalMemberRemoved,
+			Channel: "presence-foo",
+			Data:    json.RawMessage(`"{\"user_id\":\"1\"}"`),
+		})
+
+		received := make(chan struct{})
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			<-addedChan
+			<-removedChan
+			close(received)
+		}()
+
+		if err := client.DisconnectContext(context.Background()); err != nil {
+			t.Errorf("Expected error to be `nil`, got %v", err)
+		}
+		select {
+		case <-received:
+		default:
+			t.Error("Expected pending member events to be delivered before disconnecting")
+		}
+	})
+
+	t.Run("contextDone", func(t *testing.T) {
+		client := newClient(t)
+		client.Bind("foo")
+		client.handleEvent(nil, nil, Event{Event: "foo"})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if err := client.DisconnectContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+		}
+		if client.isConnected() {
+			t.Error("Expected client to be disconnected after the deadline")
+		}
+
+		// Disconnecting abandons the deliveries to bindings nobody receives
+		// from, so they don't hold up later calls.
+		select {
+		case <-client.deliveries.idle():
+		case <-time.After(time.Second):
+			t.Error("Expected pending deliveries to be abandoned after disconnecting")
+		}
+	})
+
+	t.Run("unbound", func(t *testing.T) {
+		client := newClient(t)
+		eventChan := client.Bind("foo")
+		systemChan := client.BindSystem("pusher:foo")
+		patternChan := client.BindPattern("foo*")
+		client.handleEvent(nil, nil, Event{Event: "foo"})
+		client.handleEvent(nil, nil, Event{Event: "pusher:foo"})
+		client.Unbind("foo", eventChan)
+		client.UnbindSystem("pusher:foo", systemChan)
+		client.UnbindPattern("foo*", patternChan)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := client.DisconnectContext(ctx); err != nil {
+			t.Errorf("Expected deliveries to removed bindings to be abandoned, got %v", err)
+		}
+	})
+
+	t.Run("reconnected", func(t *testing.T) {
+		var conn *fakeConn
+		client := &Client{
+			Dial: func(url, origin string) (Conn, error) {
+				conn = newConnectedFakeConn(t, "foo", 120)
+				return conn, nil
+			},
+		}
+		eventChan := client.Bind("foo")
+		orderedChan := client.Bind("foo", WithOrderedDelivery())
+		if err := client.Connect(""); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		// Events are handled in order, so foo is being delivered once bar has
+		// been received.
+		barChan := client.BindOnce("bar")
+		conn.push(t, Event{Event: "foo"})
+		conn.push(t, Event{Event: "bar"})
+		<-barChan
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if err := client.DisconnectContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+		}
+
+		// The bindings still receive events once connected again
+		if err := client.Connect(""); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer client.Disconnect()
+		conn.push(t, Event{Event: "foo", Data: json.RawMessage(`"bar"`)})
+		for _, ch := range []chan Event{eventChan, orderedChan} {
+			select {
+			case event := <-ch:
+				if string(event.Data) != `"bar"` {
+					t.Errorf("Expected the event received after reconnecting, got %s", event.Data)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Expected the binding to receive events after reconnecting")
+			}
+		}
+	})
+}
+
+func TestClientWait(t *testing.T) {
+	t.Run("disconnect", func(t *testing.T) {
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {}))
+		defer srv.Close()
+		wsURL := strings.Replace(srv.URL, "http", "ws", 1)
+		ws, err := websocket.Dial(wsURL, "ws", localOrigin)
+		if err != nil {
+			panic(err)
+		}
+
+		client := &Client{
+			connected: true,
+			ws:        websocketConn{ws},
+		}
+
+		waitErr := make(chan error)
+		go func() { waitErr <- client.Wait() }()
+
+		select {
+		case err := <-waitErr:
+			t.Fatalf("Expected Wait to block while connected, returned %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		client.Disconnect()
+
+		select {
+		case err := <-waitErr:
+			if err != nil {
+				t.Errorf("Expected Wait to return nil after Disconnect, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Timeout waiting for Wait to return after Disconnect")
+		}
+	})
+
+	t.Run("beforeConnect", func(t *testing.T) {
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			websocket.JSON.Send(ws, connEstablishedEvent("foo", 120))
+			var event Event
+			websocket.JSON.Receive(ws, &event)
+		}))
+		defer srv.Close()
+		host, port, _ := getServerHostPort(srv)
+
+		client := &Client{
+			Insecure:     true,
+			OverrideHost: host,
+			OverridePort: port,
+		}
+
+		waitErr := make(chan error)
+		go func() { waitErr <- client.Wait() }()
+		runtime.Gosched()
+
+		if err := client.Connect(""); err != nil {
+			panic(err)
+		}
+
+		select {
+		case err := <-waitErr:
+			t.Fatalf("Expected Wait to block after Connect, returned %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		client.Disconnect()
+
+		select {
+		case <-waitErr:
+		case <-time.After(time.Second):
+			t.Errorf("Timeout waiting for Wait to return after Disconnect")
+		}
+	})
+
+	t.Run("connectFailed", func(t *testing.T) {
+		dialErr := errors.New("dial failed")
+		client := &Client{
+			Dial: func(url, origin string) (Conn, error) {
+				return nil, dialErr
+			},
+		}
+
+		waitErr := make(chan error, 1)
+		go func() { waitErr <- client.Wait() }()
+		runtime.Gosched()
+
+		if err := client.Connect("foo"); !errors.Is(err, dialErr) {
+			t.Fatalf("Expected Connect to fail with %v, got %v", dialErr, err)
+		}
+
+		select {
+		case err := <-waitErr:
+			if !errors.Is(err, dialErr) {
+				t.Errorf("Expected Wait to return %v, got %v", dialErr, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected Wait to return once Connect failed")
+		}
+
+		// Connect may be retried
+		client.Dial = func(url, origin string) (Conn, error) {
+			return newConnectedFakeConn(t, "foo", 120), nil
+		}
+		if err := client.Connect("foo"); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		client.Disconnect()
+	})
+}
+
+func TestClientLastError(t *testing.T) {
+	established := connEstablishedEvent("foo", 120)
+	permanentErr := Event{Event: pusherError, Data: json.RawMessage(`{"message":"foo","code":4001}`)}
+
+	t.Run("permanentError", func(t *testing.T) {
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			websocket.JSON.Send(ws, established)
+			websocket.JSON.Send(ws, permanentErr)
+			var event Event
+			for websocket.JSON.Receive(ws, &event) == nil {
+			}
+		}))
+		defer srv.Close()
+		host, port, _ := getServerHostPort(srv)
+
+		client := &Client{
+			Insecure:     true,
+			OverrideHost: host,
+			OverridePort: port,
+		}
+		if err := client.Connect("foo"); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer client.Disconnect()
+
+		waitErr := make(chan error, 1)
+		go func() { waitErr <- client.Wait() }()
+
+		var eventErr EventError
+		select {
+		case err := <-waitErr:
+			if !errors.As(err, &eventErr) || eventErr.Code != 4001 {
+				t.Errorf("Expected Wait to return the Pusher error, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected the client to shut down after a 4001 error")
+		}
+		if err := client.LastError(); !errors.As(err, &eventErr) || eventErr.Code != 4001 {
+			t.Errorf("Expected LastError to return the Pusher error, got %v", err)
+		}
+	})
+
+	t.Run("reconnectionFailed", func(t *testing.T) {
+		var connections atomic.Int32
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			// The first connection is closed once established, and Pusher
+			// rejects the reconnection.
+			if connections.Add(1) > 1 {
+				websocket.JSON.Send(ws, permanentErr)
+				return
+			}
+			websocket.JSON.Send(ws, established)
+		}))
+		defer srv.Close()
+		host, port, _ := getServerHostPort(srv)
+
+		client := &Client{
+			Insecure:              true,
+			OverrideHost:          host,
+			OverridePort:          port,
+			InitialReconnectDelay: time.Millisecond,
+		}
+		if err := client.Connect("foo"); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer client.Disconnect()
+
+		waitErr := make(chan error, 1)
+		go func() { waitErr <- client.Wait() }()
+
+		var eventErr EventError
+		select {
+		case err := <-waitErr:
+			if !errors.As(err, &eventErr) || eventErr.Code != 4001 {
+				t.Errorf("Expected Wait to return the Pusher error, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected reconnection to stop after a 4001 error")
+		}
+		if err := client.LastError(); !errors.As(err, &eventErr) || eventErr.Code != 4001 {
+			t.Errorf("Expected LastError to return the Pusher error, got %v", err)
+		}
+		if n := connections.Load(); n != 2 {
+			t.Errorf("Expected 2 connection attempts, got %d", n)
+		}
+	})
+
+	t.Run("connectionLost", func(t *testing.T) {
+		closeConn := make(chan struct{})
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			websocket.JSON.Send(ws, established)
+			<-closeConn
+		}))
+		defer srv.Close()
+		host, port, _ := getServerHostPort(srv)
+
+		client := &Client{
+			Insecure:              true,
+			OverrideHost:          host,
+			OverridePort:          port,
+			InitialReconnectDelay: time.Hour,
+		}
+		if err := client.Connect("foo"); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer client.Disconnect()
+
+		if err := client.LastError(); err != nil {
+			t.Errorf("Expected no error while connected, got %v", err)
+		}
+		close(closeConn)
+
+		deadline := time.Now().Add(time.Second)
+		for client.LastError() == nil && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if err := client.LastError(); !errors.Is(err, io.EOF) {
+			t.Errorf("Expected LastError to wrap %v, got %v", io.EOF, err)
+		}
+	})
+
+	t.Run("connectFailed", func(t *testing.T) {
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			websocket.JSON.Send(ws, permanentErr)
+		}))
+		defer srv.Close()
+		host, port, _ := getServerHostPort(srv)
+
+		client := &Client{
+			Insecure:     true,
+			OverrideHost: host,
+			OverridePort: port,
+		}
+		if err := client.Connect("foo"); err == nil {
+			t.Fatal("Expected Connect to fail")
+		}
+		var eventErr EventError
+		if err := client.LastError(); !errors.As(err, &eventErr) || eventErr.Code != 4001 {
+			t.Errorf("Expected LastError to return the Pusher error, got %v", err)
+		}
+	})
+}
+
+func TestClientPusherErrorFlood(t *testing.T) {
+	errEvent := func(code int) Event {
+		return Event{Event: pusherError, Data: json.RawMessage(fmt.Sprintf(`{"message":"foo","code":%d}`, code))}
+	}
+
+	t.Run("coalesce", func(t *testing.T) {
+		conn := newConnectedFakeConn(t, "foo", 120)
+
+		clock := newFakeClock()
+		errChan := make(chan error, 10)
+		client := &Client{
+			Errors: errChan,
+			Dial: func(url, origin string) (Conn, error) {
+				return conn, nil
+			},
+			clock: clock,
+		}
+		if err := client.Connect("foo"); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer client.Disconnect()
+		processed := client.Bind("processed")
+
+		// send pushes events and waits for listen to handle them.
+		send := func(events ...Event) {
+			t.Helper()
+			for _, e := range events {
+				conn.push(t, e)
+			}
+			conn.push(t, Event{Event: "processed"})
+			select {
+			case <-processed:
+			case <-time.After(time.Second):
+				t.Fatal("Timeout waiting for the events to be handled")
+			}
+		}
+		errorCodes := func() []int {
+			var codes []int
+			for {
+				select {
+				case err := <-errChan:
+					var eventErr EventError
+					if errors.As(err, &eventErr) {
+						codes = append(codes, eventErr.Code)
+					}
+				default:
+					return codes
+				}
+			}
+		}
+
+		send(errEvent(1234), errEvent(1234), errEvent(1234), errEvent(1235), errEvent(1235))
+		if got, want := errorCodes(), []int{1234, 1235}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected errors with codes %v, got %v", want, got)
+		}
+
+		clock.Advance(errorCoalesceWindow)
+		send(errEvent(1235), errEvent(1235))
+		select {
+		case err := <-errChan:
+			if !strings.Contains(err.Error(), "repeated 1 more times") {
+				t.Errorf("Expected the error to report 1 repeat, got %v", err)
+			}
+		default:
+			t.Fatal("Expected the error to be sent again once the window elapsed")
+		}
+		if codes := errorCodes(); len(codes) != 0 {
+			t.Errorf("Expected no more errors, got codes %v", codes)
+		}
+	})
+
+	t.Run("fatalErrorStopsLoop", func(t *testing.T) {
+		handlerDone := make(chan struct{})
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			defer close(handlerDone)
+			websocket.JSON.Send(ws, connEstablishedEvent("foo", 120))
+			for websocket.JSON.Send(ws, errEvent(4001)) == nil {
+			}
+		}))
+		defer srv.Close()
+		host, port, _ := getServerHostPort(srv)
+
+		errChan := make(chan error, 100)
+		client := &Client{
+			Insecure:     true,
+			OverrideHost: host,
+			OverridePort: port,
+			Errors:       errChan,
+		}
+		if err := client.Connect("foo"); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer client.Disconnect()
+
+		waitErr := make(chan error, 1)
+		go func() { waitErr <- client.Wait() }()
+		select {
+		case <-waitErr:
+		case <-time.After(time.Second):
+			t.Fatal("Expected the client to disconnect on a fatal error")
+		}
+		select {
+		case <-handlerDone:
+		case <-time.After(time.Second):
+			t.Fatal("Expected the server to stop sending once the connection was closed")
+		}
+		if n := len(errChan); n > 1 {
+			t.Errorf("Expected the repeated errors to be coalesced, got %d errors", n)
+		}
+	})
+}
+
+func TestClientContext(t *testing.T) {
+	t.Run("cancelled", func(t *testing.T) {
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			websocket.JSON.Send(ws, connEstablishedEvent("foo", 120))
+			var event Event
+			websocket.JSON.Receive(ws, &event)
+		}))
+		defer srv.Close()
+		host, port, _ := getServerHostPort(srv)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		client := &Client{
+			Insecure:     true,
+			OverrideHost: host,
+			OverridePort: port,
+			Context:      ctx,
+		}
+		defer client.Disconnect()
+
+		if err := client.Connect(""); err != nil {
+			panic(err)
+		}
+
+		cancel()
+
+		waitErr := make(chan error)
+		go func() { waitErr <- client.Wait() }()
+
+		select {
+		case err := <-waitErr:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("Expected Wait to return %v, got %v", context.Canceled, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timeout waiting for Wait to return after cancellation")
+		}
+
+		if client.isConnected() {
+			t.Errorf("Expected client to be disconnected after cancellation")
+		}
+	})
+
+	t.Run("alreadyCancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		client := &Client{Context: ctx}
+		if err := client.Connect(""); !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected Connect to return %v, got %v", context.Canceled, err)
+		}
+	})
+}
+
+func TestClientPing(t *testing.T) {
+	t.Run("pong", func(t *testing.T) {
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			var event Event
+			if err := websocket.JSON.Receive(ws, &event); err != nil {
+				return
+			}
+			if event.Event != pusherPing {
+				t.Errorf("Expected to get ping event, got %+v", event)
+			}
+			websocket.Message.Send(ws, pongPayload)
+			websocket.JSON.Receive(ws, &event)
+		}))
+		defer srv.Close()
+		wsURL := strings.Replace(srv.URL, "http", "ws", 1)
+		ws, err := websocket.Dial(wsURL, "ws", localOrigin)
+		if err != nil {
+			panic(err)
+		}
+
+		client := &Client{
+			connected:    true,
+			ws:           websocketConn{ws},
+			pongReceived: make(chan struct{}, 1),
+		}
+		defer client.Disconnect()
+
+		go client.listen()
+
+		if err = client.Ping(); err != nil {
+			t.Errorf("Expected Ping to succeed, got %v", err)
+		}
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			var event Event
+			if err := websocket.JSON.Receive(ws, &event); err != nil {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+			websocket.Message.Send(ws, pongPayload)
+			websocket.JSON.Receive(ws, &event)
+		}))
+		defer srv.Close()
+		wsURL := strings.Replace(srv.URL, "http", "ws", 1)
+		ws, err := websocket.Dial(wsURL, "ws", localOrigin)
+		if err != nil {
+			panic(err)
+		}
+
+		rtts := make(chan time.Duration, 1)
+		client := &Client{
+			connected:    true,
+			ws:           websocketConn{ws},
+			pongReceived: make(chan struct{}, 1),
+			OnPong: func(rtt time.Duration) {
+				rtts <- rtt
+			},
+		}
+		defer client.Disconnect()
+
+		if client.Latency() != 0 {
+			t.Errorf("Expected latency to be zero before a pong, got %v", client.Latency())
+		}
+
+		go client.listen()
+
+		if err = client.Ping(); err != nil {
+			t.Fatalf("Expected Ping to succeed, got %v", err)
+		}
+
+		select {
+		case rtt := <-rtts:
+			if rtt < 20*time.Millisecond {
+				t.Errorf("Expected OnPong rtt of at least 20ms, got %v", rtt)
+			}
+			if client.Latency() != rtt {
+				t.Errorf("Expected Latency to return %v, got %v", rtt, client.Latency())
+			}
+		case <-time.After(time.Second):
+			t.Error("Expected OnPong to be called")
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			var event Event
+			websocket.JSON.Receive(ws, &event)
+			websocket.JSON.Receive(ws, &event)
+		}))
+		defer srv.Close()
+		wsURL := strings.Replace(srv.URL, "http", "ws", 1)
+		ws, err := websocket.Dial(wsURL, "ws", localOrigin)
+		if err != nil {
+			panic(err)
+		}
+
+		client := &Client{
+			connected:   true,
+			ws:          websocketConn{ws},
+			pongTimeout: 10 * time.Millisecond,
+		}
+		defer client.Disconnect()
+
+		if err = client.Ping(); err != ErrTimedOut {
+			t.Errorf("Expected Ping to return %v, got %v", ErrTimedOut, err)
+		}
+		if len(client.pingWaiters) != 0 {
+			t.Errorf("Expected ping waiters to be empty, got %d", len(client.pingWaiters))
+		}
+	})
+
+	t.Run("notConnected", func(t *testing.T) {
+		client := &Client{}
+		if err := client.Ping(); err != ErrNotConnected {
+			t.Errorf("Expected Ping to return %v, got %v", ErrNotConnected, err)
+		}
+	})
+}
+
+type testMetrics struct {
+	// If set, client is called by each method, to check that it isn't called
+	// while the client's lock is held.
+	client *Client
+
+	mutex      sync.Mutex
+	events     []string
+	reconnects int
+	latencies  []time.Duration
+	connected  []bool
+}
+
+func (m *testMetrics) callClient() {
+	if m.client != nil {
+		m.client.Stats()
+	}
+}
+
+func (m *testMetrics) IncEventsReceived(event string) {
+	m.callClient()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.events = append(m.events, event)
+}
+
+func (m *testMetrics) IncReconnects() {
+	m.callClient()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.reconnects++
+}
+
+func (m *testMetrics) ObservePongLatency(d time.Duration) {
+	m.callClient()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.latencies = append(m.latencies, d)
+}
+
+func (m *testMetrics) SetConnected(connected bool) {
+	m.callClient()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.connected = append(m.connected, connected)
+}
+
+func TestClientStats(t *testing.T) {
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		websocket.JSON.Send(ws, connEstablishedEvent("foo", 120))
+		websocket.JSON.Send(ws, Event{Event: "foo", Data: json.RawMessage(`"{}"`)})
+
+		var event Event
+		for websocket.JSON.Receive(ws, &event) == nil {
+		}
+	}))
+	defer srv.Close()
+
+	clock := newFakeClock()
+	host, port, _ := getServerHostPort(srv)
+	client := &Client{
+		Insecure:     true,
+		OverrideHost: host,
+		OverridePort: port,
+		clock:        clock,
+	}
+	if stats := client.Stats(); stats != (Stats{}) {
+		t.Errorf("Expected empty stats before connecting, got %+v", stats)
+	}
+
+	fooChan := client.BindOnce("foo")
+	if err := client.Connect("foo"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+	<-fooChan
+
+	connectedAt := clock.Now()
+	stats := client.Stats()
+	if !stats.ConnectedSince.Equal(connectedAt) || stats.EventsReceived != 1 || stats.ReconnectCount != 0 {
+		t.Errorf("Expected a connection at %v with 1 event received, got %+v", connectedAt, stats)
+	}
+
+	clock.Advance(time.Minute)
+	fooChan = client.BindOnce("foo")
+	if err := client.Reconnect(); err != nil {
+		t.Fatalf("Expected error to be `nil`, got %v", err)
+	}
+	<-fooChan
+
+	reconnectedAt := clock.Now()
+	stats = client.Stats()
+	want := Stats{
+		ConnectedSince: reconnectedAt,
+		ReconnectCount: 1,
+		LastReconnect:  reconnectedAt,
+		EventsReceived: 2,
+	}
+	if stats != want {
+		t.Errorf("Expected stats %+v, got %+v", want, stats)
+	}
+
+	client.Disconnect()
+	if stats := client.Stats(); !stats.ConnectedSince.IsZero() {
+		t.Errorf("Expected no connection time after disconnecting, got %v", stats.ConnectedSince)
+	}
+}
+
+func TestClientMetrics(t *testing.T) {
+	var connMutex sync.Mutex
+	connectionCount := 0
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		connMutex.Lock()
+		connectionCount++
+		count := connectionCount
+		connMutex.Unlock()
+
+		websocket.JSON.Send(ws, connEstablishedEvent(fmt.Sprintf("socket-%d", count), 120))
+
+		if count == 1 {
+			websocket.JSON.Send(ws, Event{Event: "foo", Data: json.RawMessage(`"bar"`)})
+			time.Sleep(50 * time.Millisecond)
+			ws.Close()
+			return
+		}
+
+		var event Event
+		for websocket.JSON.Receive(ws, &event) == nil {
+			if event.Event == pusherPing {
+				websocket.Message.Send(ws, pongPayload)
+			}
+		}
+	}))
+	defer srv.Close()
+
+	errChan := make(chan error, 10)
+	metrics := &testMetrics{}
+	host, port, _ := getServerHostPort(srv)
+	client := &Client{
+		Insecure:              true,
+		OverrideHost:          host,
+		OverridePort:          port,
+		Errors:                errChan,
+		InitialReconnectDelay: 10 * time.Millisecond,
+		Metrics:               metrics,
+	}
+	metrics.client = client
+
+	if err := client.Connect("foo"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	timeout := time.After(5 * time.Second)
+	for reconnected := false; !reconnected; {
+		select {
+		case err := <-errChan:
+			reconnected = err.Error() == "reconnection successful"
+		case <-timeout:
+			t.Fatal("Timeout waiting for reconnection")
+		}
+	}
+
+	if err := client.Ping(); err != nil {
+		t.Fatalf("Expected Ping to succeed, got %v", err)
+	}
+	client.Disconnect()
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	if !reflect.DeepEqual(metrics.events, []string{"foo", pusherPong}) {
+		t.Errorf("Expected events received to be %v, got %v", []string{"foo", pusherPong}, metrics.events)
+	}
+	if metrics.reconnects != 1 {
+		t.Errorf("Expected 1 reconnect, got %d", metrics.reconnects)
+	}
+	if len(metrics.latencies) != 1 {
+		t.Errorf("Expected 1 pong latency, got %d", len(metrics.latencies))
+	}
+	wantConnected := []bool{true, false, true, false}
+	if !reflect.DeepEqual(metrics.connected, wantConnected) {
+		t.Errorf("Expected connection states %v, got %v", wantConnected, metrics.connected)
+	}
+}
+
+func TestClientOnDisconnect(t *testing.T) {
+	var connMutex sync.Mutex
+	connectionCount := 0
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		connMutex.Lock()
+		connectionCount++
+		count := connectionCount
+		connMutex.Unlock()
+
+		websocket.JSON.Send(ws, connEstablishedEvent(fmt.Sprintf("socket-%d", count), 120))
+
+		if count == 1 {
+			time.Sleep(50 * time.Millisecond)
+			ws.Close()
+			return
+		}
+
+		var event Event
+		for websocket.JSON.Receive(ws, &event) == nil {
+		}
+	}))
+	defer srv.Close()
+
+	disconnects := make(chan error, 10)
+	errChan := make(chan error, 10)
+	host, port, _ := getServerHostPort(srv)
+	client := &Client{
+		Insecure:              true,
+		OverrideHost:          host,
+		OverridePort:          port,
+		Errors:                errChan,
+		InitialReconnectDelay: 10 * time.Millisecond,
+		OnDisconnect: func(err error) {
+			disconnects <- err
+		},
+	}
+
+	if err := client.Connect("foo"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	select {
+	case err := <-disconnects:
+		if err == nil {
+			t.Error("Expected OnDisconnect to be called with the cause of the lost connection")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected OnDisconnect to be called when the connection is lost")
+	}
+
+	timeout := time.After(5 * time.Second)
+	for reconnected := false; !reconnected; {
+		select {
+		case err := <-errChan:
+			reconnected = err.Error() == "reconnection successful"
+		case <-timeout:
+			t.Fatal("Timeout waiting for reconnection")
+		}
+	}
+
+	client.Disconnect()
+	client.Disconnect()
+
+	select {
+	case err := <-disconnects:
+		if err != nil {
+			t.Errorf("Expected OnDisconnect to be called with nil for Disconnect, got %v", err)
+		}
+	default:
+		t.Fatal("Expected OnDisconnect to be called by Disconnect")
+	}
+
+	if len(disconnects) != 0 {
+		t.Errorf("Expected OnDisconnect to be called once per disconnection, got %d extra calls", len(disconnects))
+	}
+}
+
+func TestClientReconnect(t *testing.T) {
+	// newServer returns a server that confirms subscriptions and reports them
+	// on subscriptions.
+	newServer := func(subscriptions chan string) *httptest.Server {
+		var connMutex sync.Mutex
+		connectionCount := 0
+		return httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			connMutex.Lock()
+			connectionCount++
+			count := connectionCount
+			connMutex.Unlock()
+
+			websocket.JSON.Send(ws, connEstablishedEvent(fmt.Sprintf("socket-%d", count), 120))
+
+			var event Event
+			for websocket.JSON.Receive(ws, &event) == nil {
+				if event.Event == pusherSubscribe {
+					var data channelData
+					json.Unmarshal(event.Data, &data)
+					subscriptions <- data.Channel
+					websocket.JSON.Send(ws, Event{Event: pusherInternalSubSucceeded, Channel: data.Channel, Data: json.RawMessage(`"{}"`)})
+				}
+			}
+		}))
+	}
+
+	t.Run("newConnection", func(t *testing.T) {
+		subscriptions := make(chan string, 10)
+		srv := newServer(subscriptions)
+		defer srv.Close()
+
+		disconnects := make(chan error, 10)
+		host, port, _ := getServerHostPort(srv)
+		client := &Client{
+			Insecure:              true,
+			OverrideHost:          host,
+			OverridePort:          port,
+			InitialReconnectDelay: time.Minute,
+			OnDisconnect: func(err error) {
+				disconnects <- err
+			},
+		}
+		if err := client.Connect("foo"); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer client.Disconnect()
+
+		if _, err := client.Subscribe("bar"); err != nil {
+			t.Fatalf("Expected error to be `nil`, got %v", err)
+		}
+		<-subscriptions
+
+		client.mutex.Lock()
+		client.generation.pongFailures = 2
+		client.generation.reconnectDelay = 2 * time.Minute
+		client.mutex.Unlock()
+
+		if err := client.Reconnect(); err != nil {
+			t.Fatalf("Expected error to be `nil`, got %v", err)
+		}
+
+		if socketID := client.SocketID(); socketID != "socket-2" {
+			t.Errorf("Expected new socket ID after Reconnect, got %s", socketID)
+		}
+		select {
+		case channel := <-subscriptions:
+			if channel != "bar" {
+				t.Errorf("Expected resubscription to bar, got %s", channel)
+			}
+		default:
+			t.Error("Expected Reconnect to resubscribe before returning")
+		}
+		select {
+		case err := <-disconnects:
+			if !errors.Is(err, ErrReconnectRequested) {
+				t.Errorf("Expected OnDisconnect to be called with ErrReconnectRequested, got %v", err)
+			}
+		default:
+			t.Error("Expected OnDisconnect to be called")
+		}
+
+		client.mutex.RLock()
+		pongFailures, delay := client.generation.pongFailures, client.generation.reconnectDelay
+		client.mutex.RUnlock()
+		if pongFailures != 0 || delay != time.Minute {
+			t.Errorf("Expected pong failures and reconnect delay to be reset, got %d and %v", pongFailures, delay)
+		}
+	})
+
+	t.Run("withoutAutoResubscribe", func(t *testing.T) {
+		subscriptions := make(chan string, 10)
+		srv := newServer(subscriptions)
+		defer srv.Close()
+
+		host, port, _ := getServerHostPort(srv)
+		client := &Client{
+			Insecure:     true,
+			OverrideHost: host,
+			OverridePort: port,
+		}
+		if err := client.Connect("foo"); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer client.Disconnect()
+
+		if _, err := client.Subscribe("bar"); err != nil {
+			t.Fatalf("Expected error to be `nil`, got %v", err)
+		}
+		ephemeral, err := client.Subscribe("baz", WithoutAutoResubscribe())
+		if err != nil {
+			t.Fatalf("Expected error to be `nil`, got %v", err)
+		}
+		<-subscriptions
+		<-subscriptions
+
+		if err := client.Reconnect(); err != nil {
+			t.Fatalf("Expected error to be `nil`, got %v", err)
+		}
+
+		if channel := <-subscriptions; channel != "bar" {
+			t.Errorf("Expected resubscription to bar, got %s", channel)
+		}
+		select {
+		case channel := <-subscriptions:
+			t.Errorf("Expected no other resubscriptions, got %s", channel)
+		default:
+		}
+		if ephemeral.IsSubscribed() {
+			t.Error("Expected the dropped channel not to be subscribed")
+		}
+		client.mutex.RLock()
+		_, ok := client.subscribedChannels["baz"]
+		client.mutex.RUnlock()
+		if ok {
+			t.Error("Expected the dropped channel to be removed from the subscribed channels")
+		}
+	})
+
+	t.Run("notConnected", func(t *testing.T) {
+		client := &Client{}
+		if err := client.Reconnect(); !errors.Is(err, ErrNotConnected) {
+			t.Errorf("Expected ErrNotConnected, got %v", err)
+		}
+	})
+}
+
+func TestClientWatchdog(t *testing.T) {
+	defaultMargin := watchdogMargin
+	watchdogMargin = 0
+	defer func() { watchdogMargin = defaultMargin }()
+
+	var connMutex sync.Mutex
+	connectionCount := 0
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		connMutex.Lock()
+		connectionCount++
+		count := connectionCount
+		connMutex.Unlock()
+
+		websocket.JSON.Send(ws, connEstablishedEvent(fmt.Sprintf("socket-%d", count), 120))
+
+		var event Event
+		for websocket.JSON.Receive(ws, &event) == nil {
+		}
+	}))
+	defer srv.Close()
+
+	disconnects := make(chan error, 10)
+	host, port, _ := getServerHostPort(srv)
+	client := &Client{
+		Insecure:              true,
+		OverrideHost:          host,
+		OverridePort:          port,
+		InitialReconnectDelay: 10 * time.Millisecond,
+		OnDisconnect: func(err error) {
+			disconnects <- err
+		},
+	}
+	if err := client.Connect("foo"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	// Simulate a stalled heartbeat by starting a watchdog with short timeouts,
+	// which the connection's own heartbeat won't satisfy.
+	client.mutex.Lock()
+	client.activityTimeout = 20 * time.Millisecond
+	client.pongTimeout = 20 * time.Millisecond
+	client.mutex.Unlock()
+	go client.watchdog()
+
+	select {
+	case err := <-disconnects:
+		if !strings.Contains(err.Error(), "no activity") {
+			t.Errorf("Expected OnDisconnect to be called with the idle error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the watchdog to drop the idle connection")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for client.SocketID() != "socket-2" {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the watchdog to reconnect, got socket ID %s", client.SocketID())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// subscribeFuncChannel is an internalChannel whose Subscribe calls subscribe.
+type subscribeFuncChannel struct {
+	internalChannel
+	subscribe func() error
+}
+
+func (c subscribeFuncChannel) Subscribe(...SubscribeOption) error {
+	return c.subscribe()
+}
+
+func (c subscribeFuncChannel) Name() string {
+	return "foo"
+}
+
+func TestClientResubscribe(t *testing.T) {
+	testCases := []struct {
+		name        string
+		concurrency int
+		want        int
+	}{
+		{"default", 0, defaultResubscribeConcurrency},
+		{"custom", 3, 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var mutex sync.Mutex
+			inFlight, maxInFlight := 0, 0
+			subscribe := func() error {
+				mutex.Lock()
+				inFlight++
+				maxInFlight = max(maxInFlight, inFlight)
+				mutex.Unlock()
+
+				time.Sleep(5 * time.Millisecond)
+
+				mutex.Lock()
+				inFlight--
+				mutex.Unlock()
+				return nil
+			}
+
+			wantErr := errors.New("foo")
+			channels := []internalChannel{subscribeFuncChannel{subscribe: func() error { return wantErr }}}
+			for i := 0; i < 50; i++ {
+				channels = append(channels, subscribeFuncChannel{subscribe: subscribe})
+			}
+
+			client := &Client{ResubscribeConcurrency: tc.concurrency}
+			if err := client.resubscribe(channels); !errors.Is(err, wantErr) {
+				t.Errorf("Expected error to wrap %v, got %v", wantErr, err)
+			}
+			if maxInFlight != tc.want {
+				t.Errorf("Expected at most %d concurrent subscriptions, got %d", tc.want, maxInFlight)
+			}
+		})
+	}
+}
+
+func TestClientSubscribeMany(t *testing.T) {
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		websocket.JSON.Send(ws, connEstablishedEvent("foo", 120))
+
+		var event Event
+		for websocket.JSON.Receive(ws, &event) == nil {
+			if event.Event != pusherSubscribe {
+				continue
+			}
+			var data channelData
+			json.Unmarshal(event.Data, &data)
+			if data.Channel == "baz" {
+				errData, _ := json.Marshal(`{"type":"AuthError","error":"forbidden","status":403}`)
+				websocket.JSON.Send(ws, Event{Event: pusherInternalSubError, Channel: data.Channel, Data: errData})
+				continue
+			}
+			websocket.JSON.Send(ws, Event{Event: pusherInternalSubSucceeded, Channel: data.Channel, Data: json.RawMessage(`"{}"`)})
+		}
+	}))
+	defer srv.Close()
+	host, port, _ := getServerHostPort(srv)
+
+	client := &Client{
+		Insecure:     true,
+		OverrideHost: host,
+		OverridePort: port,
+	}
+	if err := client.Connect("foo"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	t.Run("allSucceed", func(t *testing.T) {
+		channels, err := client.SubscribeMany("foo", "bar", "foo")
+		if err != nil {
+			t.Fatalf("Expected error to be `nil`, got %v", err)
+		}
+		if len(channels) != 2 {
+			t.Errorf("Expected 2 channels, got %d", len(channels))
+		}
+		for _, name := range []string{"foo", "bar"} {
+			if ch := channels[name]; ch == nil || !ch.IsSubscribed() {
+				t.Errorf("Expected channel %s to be subscribed", name)
+			}
+		}
+	})
+
+	t.Run("someFail", func(t *testing.T) {
+		channels, err := client.SubscribeMany("qux", "baz", "bad name")
+		var manyErr SubscribeManyError
+		if !errors.As(err, &manyErr) {
+			t.Fatalf("Expected a SubscribeManyError, got %v", err)
+		}
+		if len(manyErr) != 2 {
+			t.Errorf("Expected 2 failed channels, got %v", manyErr)
+		}
+		var subErr SubscriptionError
+		if !errors.As(manyErr["baz"], &subErr) || subErr.Code != 403 {
+			t.Errorf("Expected a SubscriptionError for baz, got %v", manyErr["baz"])
+		}
+		if !errors.Is(manyErr["bad name"], ErrInvalidChannelName) {
+			t.Errorf("Expected an error wrapping %v for bad name, got %v", ErrInvalidChannelName, manyErr["bad name"])
+		}
+		if !errors.Is(err, ErrInvalidChannelName) {
+			t.Errorf("Expected error to wrap %v, got %v", ErrInvalidChannelName, err)
+		}
+
+		if ch := channels["qux"]; ch == nil || !ch.IsSubscribed() {
+			t.Error("Expected channel qux to be subscribed")
+		}
+		if ch := channels["baz"]; ch == nil || ch.IsSubscribed() {
+			t.Error("Expected channel baz to be returned unsubscribed")
+		}
+		if _, ok := channels["bad name"]; ok {
+			t.Error("Expected no channel for an invalid name")
+		}
+	})
+}
+
+func TestClientResubscribeFailure(t *testing.T) {
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		websocket.JSON.Send(ws, connEstablishedEvent("foo", 120))
+
+		var event Event
+		for websocket.JSON.Receive(ws, &event) == nil {
+			if event.Event == pusherSubscribe {
+				var data channelData
+				json.Unmarshal(event.Data, &data)
+				websocket.JSON.Send(ws, Event{Event: pusherInternalSubSucceeded, Channel: data.Channel, Data: json.RawMessage(`"{}"`)})
+			}
+		}
+	}))
+	defer srv.Close()
+
+	// The auth endpoint starts refusing private-bar once it has been
+	// subscribed to.
+	var authMutex sync.Mutex
+	barAuthorized := false
+	authSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		authMutex.Lock()
+		defer authMutex.Unlock()
+		if r.Form.Get("channel_name") == "private-bar" {
+			if barAuthorized {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			barAuthorized = true
+		}
+		w.Write([]byte(`{"auth":"foo:bar"}`))
+	}))
+	defer authSrv.Close()
+
+	errChan := make(chan error, 20)
+	host, port, _ := getServerHostPort(srv)
+	client := &Client{
+		Insecure:              true,
+		OverrideHost:          host,
+		OverridePort:          port,
+		AuthURL:               authSrv.URL,
+		InitialReconnectDelay: 10 * time.Millisecond,
+		Errors:                errChan,
+	}
+	if err := client.Connect("foo"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	foo, err := client.Subscribe("private-foo")
+	if err != nil {
+		t.Fatalf("Expected error to be `nil`, got %v", err)
+	}
+	if _, err := client.Subscribe("private-bar"); err != nil {
+		t.Fatalf("Expected error to be `nil`, got %v", err)
+	}
+
+	client.mutex.Lock()
+	client.ws.Close()
+	client.mutex.Unlock()
+
+	var resubscribeErr error
+	timeout := time.After(5 * time.Second)
+	for reconnected := false; !reconnected; {
+		select {
+		case err := <-errChan:
+			if strings.Contains(err.Error(), "resubscription") {
+				if resubscribeErr != nil {
+					t.Errorf("Expected a single resubscription failure, got %v", err)
+				}
+				resubscribeErr = err
+			}
+			reconnected = err.Error() == "reconnection successful"
+		case <-timeout:
+			t.Fatal("Timeout waiting for reconnection")
+		}
+	}
+
+	var authErr AuthError
+	if !errors.As(resubscribeErr, &authErr) || authErr.Status != http.StatusForbidden {
+		t.Errorf("Expected the resubscription failure to wrap the auth error, got %v", resubscribeErr)
+	}
+	if resubscribeErr != nil && !strings.Contains(resubscribeErr.Error(), "private-bar") {
+		t.Errorf("Expected the resubscription failure to name the channel, got %v", resubscribeErr)
+	}
+	if !foo.IsSubscribed() {
+		t.Error("Expected private-foo to be resubscribed")
+	}
+}
+
+func TestClientOnConnect(t *testing.T) {
+	var connMutex sync.Mutex
+	connectionCount := 0
+	signins := make(chan string, 10)
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		connMutex.Lock()
+		connectionCount++
+		count := connectionCount
+		connMutex.Unlock()
+
+		socketID := fmt.Sprintf("socket-%d", count)
+		websocket.JSON.Send(ws, connEstablishedEvent(socketID, 120))
+
+		var event Event
+		if websocket.JSON.Receive(ws, &event) == nil && event.Event == "pusher:signin" {
+			signins <- socketID
+		}
+
+		if count == 1 {
+			ws.Close()
+			return
+		}
+
+		for websocket.JSON.Receive(ws, &event) == nil {
+		}
+	}))
+	defer srv.Close()
+
+	host, port, _ := getServerHostPort(srv)
+	var client *Client
+	socketIDs := make(chan string, 10)
+	client = &Client{
+		Insecure:              true,
+		OverrideHost:          host,
+		OverridePort:          port,
+		InitialReconnectDelay: 10 * time.Millisecond,
+		OnConnect: func(socketID string) {
+			socketIDs <- socketID
+			client.SendEvent("pusher:signin", map[string]string{}, "")
+		},
+	}
+	defer client.Disconnect()
+
+	if err := client.Connect("foo"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	for _, want := range []string{"socket-1", "socket-2"} {
+		select {
+		case got := <-socketIDs:
+			if got != want {
+				t.Errorf("Expected OnConnect to be called with %q, got %q", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected OnConnect to be called with %q", want)
+		}
+
+		select {
+		case got := <-signins:
+			if got != want {
+				t.Errorf("Expected sign in on %q, got %q", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected event sent by OnConnect to be received on %q", want)
+		}
+	}
+}
+
+func TestClientDisconnectWaitsForOnConnect(t *testing.T) {
+	called := make(chan string, 1)
+	release := make(chan struct{})
+	client := &Client{
+		Dial: func(url, origin string) (Conn, error) {
+			return newConnectedFakeConn(t, "foo", 120), nil
+		},
+		OnConnect: func(socketID string) {
+			called <- socketID
+			<-release
+		},
+	}
+	if err := client.Connect(""); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	select {
+	case got := <-called:
+		if got != "foo" {
+			t.Errorf("Expected OnConnect to be called with %q, got %q", "foo", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected OnConnect to be called")
+	}
+
+	disconnected := make(chan struct{})
+	go func() {
+		client.Disconnect()
+		close(disconnected)
+	}()
+
+	select {
+	case <-disconnected:
+		t.Fatal("Expected Disconnect to wait for OnConnect to return")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Disconnect to return once OnConnect returned")
+	}
+}
+
+func TestClientMaxMessageSize(t *testing.T) {
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		websocket.JSON.Send(ws, connEstablishedEvent("foo", 120))
+
+		var event Event
+		websocket.JSON.Receive(ws, &event)
+		large, _ := json.Marshal(strings.Repeat("a", 2048))
+		websocket.JSON.Send(ws, Event{Event: "foo", Data: large})
+		websocket.JSON.Send(ws, Event{Event: "foo", Data: json.RawMessage(`"small"`)})
+
+		for websocket.JSON.Receive(ws, &event) == nil {
+		}
+	}))
+	defer srv.Close()
+
+	errChan := make(chan error, 10)
+	host, port, _ := getServerHostPort(srv)
+	client := &Client{
+		Insecure:       true,
+		OverrideHost:   host,
+		OverridePort:   port,
+		Errors:         errChan,
+		MaxMessageSize: 1024,
+	}
+	if err := client.Connect("foo"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	events := client.Bind("foo", WithOrderedDelivery())
+	// Tell the server to send the messages once bound.
+	if err := client.SendEvent(pusherPing, nil, ""); err != nil {
+		t.Fatalf("Expected error to be `nil`, got %v", err)
+	}
+
+	select {
+	case err := <-errChan:
+		if !errors.Is(err, ErrMessageTooLarge) {
+			t.Errorf("Expected error to wrap ErrMessageTooLarge, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected an error for the oversized message")
+	}
+
+	select {
+	case event := <-events:
+		if string(event.Data) != `"small"` {
+			t.Errorf("Expected only the small message to be delivered, got %s", event.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the connection to remain usable after the oversized message")
+	}
+	if !client.isConnected() {
+		t.Error("Expected the client to remain connected")
+	}
+}
+
+func TestClientConnectionInfo(t *testing.T) {
+	conn := newFakeConn()
+	connInfo := `{"socket_id":"123.456","activity_timeout":120,"foo":"bar"}`
+	connInfoStr, _ := json.Marshal(connInfo)
+	conn.push(t, Event{Event: pusherConnEstablished, Data: connInfoStr})
+
+	client := &Client{
+		Dial: func(url, origin string) (Conn, error) {
+			return conn, nil
+		},
+	}
+	if info := client.ConnectionInfo(); info != nil {
+		t.Errorf("Expected no connection info before connecting, got %s", info)
+	}
+
+	if err := client.Connect("foo"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	if info := client.ConnectionInfo(); string(info) != connInfo {
+		t.Errorf("Expected connection info %s, got %s", connInfo, info)
+	}
+	if socketID := client.SocketID(); socketID != "123.456" {
+		t.Errorf("Expected socket ID 123.456, got %s", socketID)
+	}
+}
+
+func TestClientOnActivityTimeout(t *testing.T) {
+	// Each connection reports the next activity timeout, in seconds.
+	timeouts := []int{120, 120, 60, 90}
+	var dials int
+	calls := make(chan time.Duration, len(timeouts))
+	var client *Client
+	client = &Client{
+		MaxActivityTimeout: 80 * time.Second,
+		Dial: func(url, origin string) (Conn, error) {
+			conn := newConnectedFakeConn(t, "foo", timeouts[dials])
+			dials++
+			return conn, nil
+		},
+		// The callback may call methods that take the client's lock
+		OnActivityTimeout: func(d time.Duration) {
+			if got := client.ActivityTimeout(); got != d {
+				t.Errorf("Expected ActivityTimeout to return %v, got %v", d, got)
 			}
-		}))
-		defer srv.Close()
-		wsURL := strings.Replace(srv.URL, "http", "ws", 1)
-		ws, err := websocket.Dial(wsURL, "ws", localOrigin)
-		if err != nil {
-			panic(err)
+			calls <- d
+		},
+	}
+	if err := client.Connect("foo"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	var got []time.Duration
+	select {
+	case d := <-calls:
+		got = append(got, d)
+	case <-time.After(time.Second):
+		t.Fatal("Expected OnActivityTimeout to be called")
+	}
+
+	// Reconnect completes the connection before returning
+	for range timeouts[1:] {
+		if err := client.Reconnect(); err != nil {
+			t.Fatalf("Failed to reconnect: %v", err)
 		}
+	}
+	close(calls)
+	for d := range calls {
+		got = append(got, d)
+	}
 
-		authSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Write([]byte(`{}`))
-		}))
+	want := []time.Duration{80 * time.Second, 60 * time.Second, 80 * time.Second}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected OnActivityTimeout to be called with %v, got %v", want, got)
+	}
+}
 
-		client := &Client{
-			subscribedChannels: map[string]internalChannel{},
-			ws:                 ws,
-			connected:          true,
-			AuthURL:            authSrv.URL,
+func TestClientPingPayload(t *testing.T) {
+	const customPing = `{"event":"pusher:ping","data":"{\"client\":\"foo\"}"}`
+	const customPong = `{"event":"pusher:pong","data":"{\"client\":\"foo\"}"}`
+
+	t.Run("sent", func(t *testing.T) {
+		testCases := []struct {
+			name        string
+			pingPayload string
+			pongPayload string
+			wantPing    string
+			wantPong    string
+		}{
+			{"default", "", "", pingPayload, pongPayload},
+			{"custom", customPing, customPong, customPing, customPong},
 		}
-		defer client.Disconnect()
 
-		go client.listen()
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				conn := newConnectedFakeConn(t, "foo", 120)
+				client := &Client{
+					PingPayload: tc.pingPayload,
+					PongPayload: tc.pongPayload,
+					Dial: func(url, origin string) (Conn, error) {
+						return conn, nil
+					},
+				}
+				if err := client.Connect("foo"); err != nil {
+					t.Fatalf("Failed to connect: %v", err)
+				}
+				defer client.Disconnect()
 
-		subCh, err := client.SubscribePresence(channelName)
-		if err != nil {
-			panic(err)
+				conn.received <- []byte(pingPayload)
+				if got := string(<-conn.sent); got != tc.wantPong {
+					t.Errorf("Expected pong %s, got %s", tc.wantPong, got)
+				}
+
+				if err := client.sendPing(conn); err != nil {
+					t.Fatalf("Failed to send ping: %v", err)
+				}
+				if got := string(<-conn.sent); got != tc.wantPing {
+					t.Errorf("Expected ping %s, got %s", tc.wantPing, got)
+				}
+			})
 		}
+	})
 
-		baseCh := subCh.(*presenceChannel)
-		if baseCh.name != channelName {
-			t.Errorf("Expected channel name to be %q, got %q", channelName, baseCh.name)
+	t.Run("invalid", func(t *testing.T) {
+		testCases := []struct {
+			name        string
+			pingPayload string
+			pongPayload string
+		}{
+			{"pingNotJSON", "ping", ""},
+			{"pingWrongEvent", pongPayload, ""},
+			{"pongNotJSON", "", "pong"},
+			{"pongWrongEvent", "", pingPayload},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				client := &Client{
+					PingPayload: tc.pingPayload,
+					PongPayload: tc.pongPayload,
+					Dial: func(url, origin string) (Conn, error) {
+						t.Error("Expected no connection to be attempted")
+						return nil, errors.New("unexpected dial")
+					},
+				}
+				if err := client.Connect("foo"); !errors.Is(err, ErrInvalidPayload) {
+					t.Errorf("Expected an error wrapping %v, got %v", ErrInvalidPayload, err)
+				}
+			})
 		}
 	})
 }
 
-func TestClientUnsubscribe(t *testing.T) {
-	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {}))
-	defer srv.Close()
-	wsURL := strings.Replace(srv.URL, "http", "ws", 1)
-	ws, err := websocket.Dial(wsURL, "ws", localOrigin)
-	if err != nil {
-		panic(err)
-	}
-
-	ch := &channel{name: "foo"}
+func TestClientByteCounters(t *testing.T) {
+	conn := newFakeConn()
+	handshake, _ := json.Marshal(connEstablishedEvent("foo", 120))
+	conn.received <- handshake
 	client := &Client{
-		subscribedChannels: map[string]internalChannel{"foo": ch},
-		ws:                 ws,
+		Dial: func(url, origin string) (Conn, error) {
+			return conn, nil
+		},
+	}
+	if err := client.Connect("foo"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
 	}
 	defer client.Disconnect()
-	ch.client = client
-	err = client.Unsubscribe("foo")
-	if err != nil {
-		panic(err)
+
+	// The client answers the ping, so the ping has been counted once the pong
+	// is sent.
+	conn.received <- []byte(pingPayload)
+	select {
+	case <-conn.sent:
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for the pong")
 	}
 
-	if _, ok := client.subscribedChannels["foo"]; ok {
-		t.Errorf("Expected client subscribed channels not to contain 'foo', got %+v", client.subscribedChannels)
+	if got, want := client.BytesReceived(), uint64(len(handshake)+len(pingPayload)); got != want {
+		t.Errorf("Expected %d bytes received, got %d", want, got)
+	}
+	// The bytes sent are counted once WriteMessage has returned
+	want := uint64(len(pongPayload))
+	deadline := time.Now().Add(time.Second)
+	for client.BytesSent() != want && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := client.BytesSent(); got != want {
+		t.Errorf("Expected %d bytes sent, got %d", want, got)
 	}
 }
 
-func TestClientDisconnect(t *testing.T) {
-	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {}))
+func TestClientUserAgent(t *testing.T) {
+	userAgents := make(chan string, 1)
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		userAgents <- ws.Request().Header.Get("User-Agent")
+		websocket.JSON.Send(ws, connEstablishedEvent("foo", 120))
+		var event Event
+		for websocket.JSON.Receive(ws, &event) == nil {
+		}
+	}))
 	defer srv.Close()
-	wsURL := strings.Replace(srv.URL, "http", "ws", 1)
-	ws, err := websocket.Dial(wsURL, "ws", localOrigin)
-	if err != nil {
-		panic(err)
+	host, port, _ := getServerHostPort(srv)
+
+	testCases := []struct {
+		name      string
+		userAgent string
+		want      string
+	}{
+		{"default", "", clientName + "/" + clientVersion},
+		{"custom", "foo/1.0", "foo/1.0"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &Client{
+				Insecure:     true,
+				OverrideHost: host,
+				OverridePort: port,
+				UserAgent:    tc.userAgent,
+			}
+			if err := client.Connect("foo"); err != nil {
+				t.Fatalf("Failed to connect: %v", err)
+			}
+			defer client.Disconnect()
+
+			if got := <-userAgents; got != tc.want {
+				t.Errorf("Expected User-Agent %q, got %q", tc.want, got)
+			}
+		})
 	}
+}
+
+func TestClientOnFrame(t *testing.T) {
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		websocket.JSON.Send(ws, connEstablishedEvent("foo", 120))
+		var event Event
+		if websocket.JSON.Receive(ws, &event) == nil {
+			websocket.JSON.Send(ws, Event{Event: "bar", Data: json.RawMessage(`"baz"`)})
+		}
+		websocket.JSON.Receive(ws, &event)
+	}))
+	defer srv.Close()
+	host, port, _ := getServerHostPort(srv)
 
+	type frame struct {
+		direction Direction
+		event     string
+	}
+	frames := make(chan frame, 10)
 	client := &Client{
-		connected: true,
-		ws:        ws,
+		Insecure:     true,
+		OverrideHost: host,
+		OverridePort: port,
+		OnFrame: func(direction Direction, data []byte) {
+			var event Event
+			json.Unmarshal(data, &event)
+			frames <- frame{direction, event.Event}
+		},
 	}
+	defer client.Disconnect()
 
-	err = client.Disconnect()
-	if err != nil {
-		panic(err)
+	bound := client.Bind("bar")
+	if err := client.Connect("foo"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
 	}
+	if err := client.SendEvent("client-foo", "bar", "baz"); err != nil {
+		t.Fatalf("Failed to send event: %v", err)
+	}
+	<-bound
 
-	if client.connected {
-		t.Errorf("Expected client connected to be false, got true")
+	wantFrames := []frame{
+		{DirectionReceived, pusherConnEstablished},
+		{DirectionSent, "client-foo"},
+		{DirectionReceived, "bar"},
 	}
-	if err = ws.Close(); err == nil {
-		t.Errorf("Expected websocket connection to have been closed, got no error closing again")
+	for _, want := range wantFrames {
+		select {
+		case got := <-frames:
+			if got != want {
+				t.Errorf("Expected frame %v %q, got %v %q", want.direction, want.event, got.direction, got.event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected frame %v %q", want.direction, want.event)
+		}
 	}
 }
 
@@ -451,7 +3752,7 @@ func TestClientHeartbeat(t *testing.T) {
 		client := &Client{
 			connected:          false,
 			activityTimerReset: make(chan struct{}, 1),
-			activityTimer:      &time.Timer{C: timeChan},
+			activityTimer:      realTimer{&time.Timer{C: timeChan}},
 		}
 
 		go func() {
@@ -467,6 +3768,24 @@ func TestClientHeartbeat(t *testing.T) {
 		client.heartbeat()
 	})
 
+	t.Run("nilTimers", func(t *testing.T) {
+		client := &Client{
+			connected:          true,
+			activityTimerReset: make(chan struct{}, 1),
+		}
+
+		returned := make(chan struct{})
+		go func() {
+			client.heartbeat()
+			close(returned)
+		}()
+		select {
+		case <-returned:
+		case <-time.After(time.Second):
+			t.Fatal("Expected heartbeat to return without an activity timer")
+		}
+	})
+
 	t.Run("timerReset", func(t *testing.T) {
 		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {}))
 		defer srv.Close()
@@ -479,9 +3798,9 @@ func TestClientHeartbeat(t *testing.T) {
 		client := &Client{
 			connected:          true,
 			activityTimerReset: make(chan struct{}, 1),
-			activityTimer:      time.NewTimer(1 * time.Hour),
+			activityTimer:      realClock{}.NewTimer(1 * time.Hour),
 			activityTimeout:    0,
-			ws:                 ws,
+			ws:                 websocketConn{ws},
 		}
 
 		go client.heartbeat()
@@ -490,7 +3809,7 @@ func TestClientHeartbeat(t *testing.T) {
 		client.Disconnect()
 		client.activityTimerReset <- struct{}{}
 
-		<-client.activityTimer.C
+		<-client.activityTimer.C()
 	})
 
 	t.Run("timerExpire", func(t *testing.T) {
@@ -517,8 +3836,8 @@ func TestClientHeartbeat(t *testing.T) {
 
 		client := &Client{
 			connected:     true,
-			activityTimer: time.NewTimer(0),
-			ws:            ws,
+			activityTimer: realClock{}.NewTimer(0),
+			ws:            websocketConn{ws},
 		}
 		defer client.Disconnect()
 
@@ -540,9 +3859,9 @@ func TestClientHeartbeat(t *testing.T) {
 		client := &Client{
 			connected:          true,
 			activityTimerReset: make(chan struct{}, 1),
-			activityTimer:      time.NewTimer(1024 * time.Hour),
+			activityTimer:      realClock{}.NewTimer(1024 * time.Hour),
 			activityTimeout:    0,
-			ws:                 ws,
+			ws:                 websocketConn{ws},
 		}
 
 		go client.heartbeat()
@@ -600,7 +3919,7 @@ func TestClientListen(t *testing.T) {
 
 		client := &Client{
 			connected: true,
-			ws:        ws,
+			ws:        websocketConn{ws},
 		}
 		defer client.Disconnect()
 
@@ -630,9 +3949,9 @@ func TestClientListen(t *testing.T) {
 		dataChan := make(chan json.RawMessage)
 		client := &Client{
 			connected: true,
-			ws:        ws,
+			ws:        websocketConn{ws},
 			boundEvents: map[string]boundEventChans{
-				wantEvent.Event: {eventChan: struct{}{}},
+				wantEvent.Event: {eventChan: make(chan struct{})},
 			},
 			subscribedChannels: map[string]internalChannel{
 				wantEvent.Channel: &channel{
@@ -661,6 +3980,147 @@ func TestClientListen(t *testing.T) {
 		wg.Wait()
 	})
 
+	t.Run("receiveBatch", func(t *testing.T) {
+		conn := newFakeConn()
+		conn.received <- []byte(`[{"event":"foo","data":"1"},42,{"event":"foo","data":"2"}]`)
+
+		eventChan := make(chan Event, 2)
+		errChan := make(chan error, 1)
+		client := &Client{
+			connected: true,
+			ws:        conn,
+			Errors:    errChan,
+		}
+		doneChan := make(chan struct{})
+		client.boundEvents = map[string]boundEventChans{"foo": {eventChan: doneChan}}
+		client.orderedChans = map[chan Event]*orderedQueue[Event]{
+			eventChan: newOrderedQueue(eventChan, doneChan, &client.deliveries),
+		}
+		defer client.Disconnect()
+
+		go client.listen()
+
+		for _, want := range []string{`"1"`, `"2"`} {
+			select {
+			case event := <-eventChan:
+				if string(event.Data) != want {
+					t.Errorf("Expected event data %s, got %s", want, event.Data)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Expected to receive each event of the batch")
+			}
+		}
+		select {
+		case err := <-errChan:
+			if !strings.Contains(err.Error(), "batched event 1") {
+				t.Errorf("Expected an error decoding batched event 1, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected the malformed element to be reported on Errors")
+		}
+	})
+
+	t.Run("recoverPanic", func(t *testing.T) {
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			websocket.JSON.Send(ws, Event{
+				Event:   pusherInternalSubCount,
+				Channel: "bar",
+				Data:    json.RawMessage(`"{\"subscription_count\":2}"`),
+			})
+			websocket.JSON.Send(ws, Event{Event: "foo", Data: json.RawMessage(`"baz"`)})
+			var event Event
+			websocket.JSON.Receive(ws, &event)
+		}))
+		defer srv.Close()
+		wsURL := strings.Replace(srv.URL, "http", "ws", 1)
+		ws, err := websocket.Dial(wsURL, "ws", localOrigin)
+		if err != nil {
+			panic(err)
+		}
+
+		ch := &channel{name: "bar"}
+		ch.BindSubscriptionCount(func(count int) { panic("boom") })
+		eventChan := make(chan Event)
+		errChan := make(chan error, 1)
+		client := &Client{
+			connected: true,
+			ws:        websocketConn{ws},
+			Errors:    errChan,
+			boundEvents: map[string]boundEventChans{
+				"foo": {eventChan: make(chan struct{})},
+			},
+			subscribedChannels: map[string]internalChannel{"bar": ch},
+		}
+		ch.client = client
+		defer client.Disconnect()
+
+		go client.listen()
+
+		select {
+		case err := <-errChan:
+			if !strings.Contains(err.Error(), "recovered from panic") || !strings.Contains(err.Error(), "boom") {
+				t.Errorf("Expected recovered panic error, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected panic to be reported on Errors")
+		}
+
+		select {
+		case event := <-eventChan:
+			if event.Event != "foo" {
+				t.Errorf("Expected to receive foo event, got %+v", event)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected listen to continue after a panic")
+		}
+
+		// The client's lock must have been released by the panicking handler
+		client.Unbind("foo")
+	})
+
+	t.Run("handlerCallsClient", func(t *testing.T) {
+		conn := newFakeConn()
+		conn.push(t, Event{
+			Event:   pusherInternalSubCount,
+			Channel: "bar",
+			Data:    json.RawMessage(`"{\"subscription_count\":2}"`),
+		})
+		conn.push(t, Event{Event: pusherCacheMiss, Channel: "cache-bar"})
+
+		ch := &channel{name: "bar"}
+		cacheCh := &channel{name: "cache-bar"}
+		client := &Client{
+			connected:          true,
+			ws:                 conn,
+			subscribedChannels: map[string]internalChannel{"bar": ch, "cache-bar": cacheCh},
+		}
+		ch.client = client
+		cacheCh.client = client
+		defer client.Disconnect()
+
+		// Handlers may call methods that take the client's lock
+		countCalled := make(chan struct{})
+		ch.BindSubscriptionCount(func(count int) {
+			client.Unbind("foo")
+			close(countCalled)
+		})
+		cacheMissCalled := make(chan struct{})
+		cacheCh.BindCacheMiss(func() {
+			client.Unbind("foo")
+			close(cacheMissCalled)
+		})
+
+		go client.listen()
+
+		for name, called := range map[string]chan struct{}{"subscription count": countCalled, "cache miss": cacheMissCalled} {
+			select {
+			case <-called:
+			case <-time.After(time.Second):
+				t.Fatalf("Expected the %s handler to return", name)
+			}
+		}
+	})
+
 	t.Run("receiveError", func(t *testing.T) {
 		wantError := EventError{
 			Code:    1234,
@@ -670,6 +4130,7 @@ func TestClientListen(t *testing.T) {
 		if err != nil {
 			panic(err)
 		}
+		wantError.Raw = errData
 		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
 			for {
 				websocket.JSON.Send(ws, Event{Event: pusherError, Data: errData})
@@ -684,31 +4145,201 @@ func TestClientListen(t *testing.T) {
 
 		client := &Client{
 			connected: true,
-			ws:        ws,
+			ws:        websocketConn{ws},
 			Errors:    make(chan error),
 		}
-		defer client.Disconnect()
+		defer client.Disconnect()
+
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			// Repeats of the error are coalesced, so the error received may
+			// wrap it with the number of repeats skipped.
+			var gotError EventError
+			if err := <-client.Errors; !errors.As(err, &gotError) || !reflect.DeepEqual(gotError, wantError) {
+				t.Errorf("Expected to receive event %+v, got %+v", wantError, err)
+			}
+			wg.Done()
+		}()
+
+		go client.listen()
+
+		wg.Wait()
+	})
+}
+
+func TestDecodeEvents(t *testing.T) {
+	testCases := []struct {
+		name    string
+		msg     string
+		want    []Event
+		wantErr bool
+	}{
+		{"single", `{"event":"foo","data":"1"}`, []Event{{Event: "foo", Data: json.RawMessage(`"1"`)}}, false},
+		{"batch", ` [{"event":"foo","data":"1"},{"event":"bar","channel":"baz"}]`, []Event{
+			{Event: "foo", Data: json.RawMessage(`"1"`)},
+			{Event: "bar", Channel: "baz"},
+		}, false},
+		{"emptyBatch", `[]`, []Event{}, false},
+		{"mixedBatch", `[{"event":"foo"},"bar",[]]`, []Event{{Event: "foo"}}, true},
+		{"malformed", `{"event":`, nil, true},
+		{"malformedBatch", `[{"event":"foo"}`, nil, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := decodeEvents([]byte(tc.msg))
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Expected error: %v, got %v", tc.wantErr, err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Expected events %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestClientConnectionGeneration(t *testing.T) {
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		websocket.JSON.Send(ws, connEstablishedEvent("foo", 120))
+		var event Event
+		for websocket.JSON.Receive(ws, &event) == nil {
+		}
+	}))
+	defer srv.Close()
+	host, port, _ := getServerHostPort(srv)
+
+	t.Run("rapidConnectDisconnect", func(t *testing.T) {
+		client := &Client{
+			Insecure:              true,
+			OverrideHost:          host,
+			OverridePort:          port,
+			InitialReconnectDelay: 10 * time.Millisecond,
+		}
+
+		// Read the stats concurrently with the connection state changing, so
+		// that -race catches counters accessed outside of the mutex.
+		stop := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					client.Stats()
+				}
+			}
+		}()
+		defer func() {
+			close(stop)
+			<-stopped
+		}()
+
+		for i := 0; i < 20; i++ {
+			if err := client.Connect("foo"); err != nil {
+				t.Fatalf("Failed to connect: %v", err)
+			}
+
+			client.mutex.Lock()
+			generation := client.generation
+			client.generation.pongFailures = 2
+			client.generation.reconnectDelay = time.Minute
+			client.mutex.Unlock()
+
+			if generation.pongFailures != 0 || generation.reconnectDelay != 10*time.Millisecond {
+				t.Fatalf("Expected counters to be reset on connect, got %+v", generation)
+			}
+			if err := client.Disconnect(); err != nil {
+				t.Fatalf("Failed to disconnect: %v", err)
+			}
+		}
+	})
+
+	t.Run("reconnection", func(t *testing.T) {
+		errChan := make(chan error, 10)
+		client := &Client{
+			Insecure:              true,
+			OverrideHost:          host,
+			OverridePort:          port,
+			InitialReconnectDelay: 10 * time.Millisecond,
+			Errors:                errChan,
+		}
+		if err := client.Connect("foo"); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer client.Disconnect()
+
+		client.mutex.Lock()
+		oldID := client.generation.id
+		client.generation.pongFailures = 2
+		client.ws.Close()
+		client.mutex.Unlock()
+
+		timeout := time.After(5 * time.Second)
+		for reconnected := false; !reconnected; {
+			select {
+			case err := <-errChan:
+				reconnected = err.Error() == "reconnection successful"
+			case <-timeout:
+				t.Fatal("Timeout waiting for reconnection")
+			}
+		}
 
-		wg := &sync.WaitGroup{}
-		wg.Add(1)
-		go func() {
-			if gotError := <-client.Errors; !reflect.DeepEqual(gotError, wantError) {
-				t.Errorf("Expected to receive event %+v, got %+v", wantError, gotError)
-			}
-			wg.Done()
-		}()
+		// The pong failures of the lost connection don't count against the
+		// new one, but the backoff is kept until a pong is received.
+		client.mutex.RLock()
+		generation := client.generation
+		client.mutex.RUnlock()
+		if generation.id == oldID {
+			t.Error("Expected a new connection generation")
+		}
+		if generation.pongFailures != 0 {
+			t.Errorf("Expected pong failures to be reset, got %d", generation.pongFailures)
+		}
+		if generation.reconnectDelay != 20*time.Millisecond {
+			t.Errorf("Expected reconnect delay to be backed off to 20ms, got %v", generation.reconnectDelay)
+		}
+	})
+}
 
-		go client.listen()
+func TestClientReconnectDelays(t *testing.T) {
+	testCases := []struct {
+		name        string
+		initial     time.Duration
+		max         time.Duration
+		wantInitial time.Duration
+		wantMax     time.Duration
+	}{
+		{"defaults", 0, 0, initialReconnectDelay, maxReconnectDelay},
+		{"custom", 100 * time.Millisecond, 5 * time.Second, 100 * time.Millisecond, 5 * time.Second},
+		{"customInitial", 2 * time.Second, 0, 2 * time.Second, maxReconnectDelay},
+		{"maxLessThanInitial", 10 * time.Second, 5 * time.Second, initialReconnectDelay, maxReconnectDelay},
+	}
 
-		wg.Wait()
-	})
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &Client{
+				InitialReconnectDelay: tc.initial,
+				MaxReconnectDelay:     tc.max,
+			}
+			gotInitial, gotMax := client.reconnectDelays()
+			if gotInitial != tc.wantInitial {
+				t.Errorf("Expected initial delay to be %v, got %v", tc.wantInitial, gotInitial)
+			}
+			if gotMax != tc.wantMax {
+				t.Errorf("Expected max delay to be %v, got %v", tc.wantMax, gotMax)
+			}
+		})
+	}
 }
 
 func TestClientGenerateConnURL(t *testing.T) {
 	t.Run("defaults", func(t *testing.T) {
 		wantAppKey := "foo"
 		client := &Client{}
-		gotURL := client.generateConnURL(wantAppKey)
+		gotURL := client.generateConnURL(wantAppKey, client.Cluster)
 		if !strings.Contains(gotURL, secureScheme) {
 			t.Errorf("Expected connection URL to have secure scheme, got %q", gotURL)
 		}
@@ -721,15 +4352,26 @@ func TestClientGenerateConnURL(t *testing.T) {
 		if !strings.Contains(gotURL, wantAppKey) {
 			t.Errorf("Expected connection URL to have app key, got %q", gotURL)
 		}
+		if !strings.Contains(gotURL, "client="+clientName) || !strings.Contains(gotURL, "version="+clientVersion) {
+			t.Errorf("Expected connection URL to have default client identity, got %q", gotURL)
+		}
 	})
 
 	t.Run("custom", func(t *testing.T) {
 		wantAppKey := "foo"
 		client := &Client{
-			Insecure: true,
-			Cluster:  "bar",
+			Insecure:      true,
+			Cluster:       "bar",
+			ClientName:    "my client",
+			ClientVersion: "1.2.3",
+		}
+		gotURL := client.generateConnURL(wantAppKey, client.Cluster)
+		if !strings.Contains(gotURL, "client=my+client&version=1.2.3") {
+			t.Errorf("Expected connection URL to have custom client identity, got %q", gotURL)
+		}
+		if !strings.Contains(gotURL, "protocol="+protocolVersion) {
+			t.Errorf("Expected connection URL to have protocol version, got %q", gotURL)
 		}
-		gotURL := client.generateConnURL(wantAppKey)
 		if !strings.Contains(gotURL, insecureScheme) {
 			t.Errorf("Expected connection URL to have insecure scheme, got %q", gotURL)
 		}
@@ -747,13 +4389,27 @@ func TestClientGenerateConnURL(t *testing.T) {
 		}
 	})
 
+	t.Run("preferredActivityTimeout", func(t *testing.T) {
+		client := &Client{PreferredActivityTimeout: 90 * time.Second}
+		gotURL := client.generateConnURL("foo", client.Cluster)
+		if !strings.Contains(gotURL, "&activity_timeout=90") {
+			t.Errorf("Expected connection URL to have preferred activity timeout, got %q", gotURL)
+		}
+
+		client = &Client{}
+		gotURL = client.generateConnURL("foo", client.Cluster)
+		if strings.Contains(gotURL, "activity_timeout") {
+			t.Errorf("Expected connection URL not to have activity timeout, got %q", gotURL)
+		}
+	})
+
 	t.Run("override", func(t *testing.T) {
 		client := &Client{
 			OverrideHost: "foo.bar",
 			OverridePort: 1234,
 		}
 
-		gotURL := client.generateConnURL("")
+		gotURL := client.generateConnURL("", client.Cluster)
 		if !strings.Contains(gotURL, client.OverrideHost) {
 			t.Errorf("Expected connection URL to have override host, got %q", gotURL)
 		}
@@ -763,6 +4419,54 @@ func TestClientGenerateConnURL(t *testing.T) {
 	})
 }
 
+func TestClientClusters(t *testing.T) {
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		websocket.JSON.Send(ws, connEstablishedEvent("foo", 120))
+		var event Event
+		websocket.JSON.Receive(ws, &event)
+	}))
+	defer srv.Close()
+	_, port, _ := getServerHostPort(srv)
+
+	// Direct cluster "1" to the server and other clusters to unused loopback
+	// addresses.
+	defaultClusterHost := clusterHost
+	clusterHost = func(cluster string) string { return "127.0.0." + cluster }
+	defer func() { clusterHost = defaultClusterHost }()
+
+	t.Run("failover", func(t *testing.T) {
+		client := &Client{
+			Insecure:     true,
+			OverridePort: port,
+			Clusters:     []string{"2", "1", "3"},
+		}
+		defer client.Disconnect()
+
+		if err := client.Connect("foo"); err != nil {
+			t.Fatalf("Expected to connect, got %v", err)
+		}
+		if got := client.ActiveCluster(); got != "1" {
+			t.Errorf("Expected active cluster to be %q, got %q", "1", got)
+		}
+	})
+
+	t.Run("allUnreachable", func(t *testing.T) {
+		client := &Client{
+			Insecure:     true,
+			OverridePort: port,
+			Clusters:     []string{"2", "3"},
+		}
+
+		err := client.Connect("foo")
+		if err == nil {
+			t.Fatal("Expected an error when no cluster is reachable")
+		}
+		if !strings.Contains(err.Error(), "127.0.0.2") || !strings.Contains(err.Error(), "127.0.0.3") {
+			t.Errorf("Expected error to report each cluster, got %v", err)
+		}
+	})
+}
+
 func TestClientConnect(t *testing.T) {
 	t.Run("pusherError", func(t *testing.T) {
 		wantError := EventError{
@@ -773,6 +4477,7 @@ func TestClientConnect(t *testing.T) {
 		if err != nil {
 			panic(err)
 		}
+		wantError.Raw = errData
 		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
 			for {
 				websocket.JSON.Send(ws, Event{Event: pusherError, Data: errData})
@@ -870,6 +4575,212 @@ func TestClientConnect(t *testing.T) {
 			t.Errorf("Expected client subscribed channels to be non-nil")
 		}
 	})
+
+	t.Run("onSocketID", func(t *testing.T) {
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			websocket.JSON.Send(ws, connEstablishedEvent("bar", 120))
+			var event Event
+			websocket.JSON.Receive(ws, &event)
+		}))
+		defer srv.Close()
+		host, port, _ := getServerHostPort(srv)
+
+		ids := make(chan [2]string, 1)
+		client := &Client{
+			Insecure:     true,
+			OverrideHost: host,
+			OverridePort: port,
+			socketID:     "foo",
+		}
+		// The callback may call methods that take the client's lock
+		client.OnSocketID = func(oldID, newID string) {
+			client.SetAuthHeaders(http.Header{"X-Socket-Id": {newID}})
+			ids <- [2]string{oldID, newID}
+		}
+		defer client.Disconnect()
+
+		if err := client.Connect(""); err != nil {
+			panic(err)
+		}
+
+		select {
+		case got := <-ids:
+			if got != [2]string{"foo", "bar"} {
+				t.Errorf("Expected OnSocketID to be called with (%q, %q), got (%q, %q)", "foo", "bar", got[0], got[1])
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected OnSocketID to be called")
+		}
+	})
+
+	t.Run("origin", func(t *testing.T) {
+		wantOrigin := "https://example.com"
+
+		gotOrigin := make(chan string, 1)
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			gotOrigin <- ws.Request().Header.Get("Origin")
+			websocket.JSON.Send(ws, connEstablishedEvent("foo", 120))
+			var event Event
+			websocket.JSON.Receive(ws, &event)
+		}))
+		defer srv.Close()
+		host, port, _ := getServerHostPort(srv)
+
+		client := &Client{
+			Insecure:     true,
+			OverrideHost: host,
+			OverridePort: port,
+			Origin:       wantOrigin,
+		}
+		defer client.Disconnect()
+
+		if err := client.Connect(""); err != nil {
+			panic(err)
+		}
+
+		if origin := <-gotOrigin; origin != wantOrigin {
+			t.Errorf("Expected Origin header to be %q, got %q", wantOrigin, origin)
+		}
+	})
+
+	t.Run("unknownEvent", func(t *testing.T) {
+		wantEvent := Event{Event: "foo", Data: json.RawMessage(`{"bar":"baz"}`)}
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			websocket.JSON.Send(ws, wantEvent)
+		}))
+		defer srv.Close()
+		host, port, _ := getServerHostPort(srv)
+
+		client := &Client{
+			Insecure:     true,
+			OverrideHost: host,
+			OverridePort: port,
+		}
+
+		err := client.Connect("")
+		var unexpectedErr UnexpectedEventError
+		if !errors.As(err, &unexpectedErr) {
+			t.Fatalf("Expected error to be an UnexpectedEventError, got %v", err)
+		}
+		if !reflect.DeepEqual(unexpectedErr.Event, wantEvent) {
+			t.Errorf("Expected error to carry event %+v, got %+v", wantEvent, unexpectedErr.Event)
+		}
+		if client.isConnected() {
+			t.Error("Expected client not to be connected")
+		}
+	})
+
+	t.Run("handshakeTimeout", func(t *testing.T) {
+		release := make(chan struct{})
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			<-release
+		}))
+		defer srv.Close()
+		defer close(release)
+		host, port, _ := getServerHostPort(srv)
+
+		client := &Client{
+			Insecure:         true,
+			OverrideHost:     host,
+			OverridePort:     port,
+			HandshakeTimeout: 50 * time.Millisecond,
+		}
+
+		start := time.Now()
+		err := client.Connect("")
+		if !errors.Is(err, ErrTimedOut) {
+			t.Errorf("Expected error %v, got %v", ErrTimedOut, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected Connect to time out after %v, took %v", client.HandshakeTimeout, elapsed)
+		}
+	})
+
+	t.Run("alreadyConnected", func(t *testing.T) {
+		connections := make(chan struct{}, 10)
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			connections <- struct{}{}
+			websocket.JSON.Send(ws, connEstablishedEvent("foo", 120))
+			var event Event
+			for websocket.JSON.Receive(ws, &event) == nil {
+			}
+		}))
+		defer srv.Close()
+		host, port, _ := getServerHostPort(srv)
+
+		client := &Client{
+			Insecure:     true,
+			OverrideHost: host,
+			OverridePort: port,
+		}
+		defer client.Disconnect()
+
+		if err := client.Connect("foo"); err != nil {
+			t.Fatalf("Expected error to be `nil`, got %v", err)
+		}
+		client.mutex.RLock()
+		ws := client.ws
+		client.mutex.RUnlock()
+
+		if err := client.Connect("foo"); !errors.Is(err, ErrAlreadyConnected) {
+			t.Errorf("Expected ErrAlreadyConnected, got %v", err)
+		}
+		client.mutex.RLock()
+		sameWs := client.ws == ws
+		client.mutex.RUnlock()
+		if !sameWs || !client.isConnected() {
+			t.Error("Expected the existing connection to be kept")
+		}
+		if len(connections) != 1 {
+			t.Errorf("Expected 1 connection, got %d", len(connections))
+		}
+	})
+
+	t.Run("maxActivityTimeout", func(t *testing.T) {
+		testCases := []struct {
+			name      string
+			max       time.Duration
+			preferred time.Duration
+			want      time.Duration
+		}{
+			{"unset", 0, 0, 120 * time.Second},
+			{"capped", 30 * time.Second, 0, 30 * time.Second},
+			{"serverSmaller", 300 * time.Second, 0, 120 * time.Second},
+			{"preferredSmaller", 0, 60 * time.Second, 60 * time.Second},
+			{"preferredLarger", 0, 300 * time.Second, 120 * time.Second},
+			{"preferredAboveMax", 30 * time.Second, 60 * time.Second, 30 * time.Second},
+			{"preferredBelowSecond", 0, time.Millisecond, 120 * time.Second},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+					websocket.JSON.Send(ws, connEstablishedEvent("foo", 120))
+					var event Event
+					websocket.JSON.Receive(ws, &event)
+				}))
+				defer srv.Close()
+				host, port, _ := getServerHostPort(srv)
+
+				client := &Client{
+					Insecure:                 true,
+					OverrideHost:             host,
+					OverridePort:             port,
+					MaxActivityTimeout:       tc.max,
+					PreferredActivityTimeout: tc.preferred,
+				}
+				defer client.Disconnect()
+
+				if err := client.Connect(""); err != nil {
+					panic(err)
+				}
+
+				if got := client.ActivityTimeout(); got != tc.want {
+					t.Errorf("Expected activity timeout to be %v, got %v", tc.want, got)
+				}
+			})
+		}
+	})
 }
 
 func TestReconnection(t *testing.T) {
@@ -884,12 +4795,7 @@ func TestReconnection(t *testing.T) {
 			connectionCount++
 
 			// Send connection established event
-			connData, _ := json.Marshal(connectionData{
-				SocketID:        fmt.Sprintf("socket-%d", connectionCount),
-				ActivityTimeout: 1,
-			})
-			connDataStr, _ := json.Marshal(string(connData))
-			websocket.JSON.Send(ws, Event{Event: pusherConnEstablished, Data: connDataStr})
+			websocket.JSON.Send(ws, connEstablishedEvent(fmt.Sprintf("socket-%d", connectionCount), 1))
 
 			// For the first connection, close it immediately after establishing
 			if connectionCount == 1 {
@@ -967,12 +4873,7 @@ func TestReconnection(t *testing.T) {
 		server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
 			if !initialConnectionEstablished {
 				// Send connection established event for first connection
-				connData, _ := json.Marshal(connectionData{
-					SocketID:        "test-socket",
-					ActivityTimeout: 1,
-				})
-				connDataStr, _ := json.Marshal(string(connData))
-				websocket.JSON.Send(ws, Event{Event: pusherConnEstablished, Data: connDataStr})
+				websocket.JSON.Send(ws, connEstablishedEvent("test-socket", 1))
 				initialConnectionEstablished = true
 
 				// Wait a moment before closing
@@ -1069,12 +4970,7 @@ func TestReconnection(t *testing.T) {
 			connectionMutex.Unlock()
 
 			// Send connection established event
-			connData, _ := json.Marshal(connectionData{
-				SocketID:        fmt.Sprintf("socket-%d", connID),
-				ActivityTimeout: 1,
-			})
-			connDataStr, _ := json.Marshal(string(connData))
-			websocket.JSON.Send(ws, Event{Event: pusherConnEstablished, Data: connDataStr})
+			websocket.JSON.Send(ws, connEstablishedEvent(fmt.Sprintf("socket-%d", connID), 1))
 
 			// Process messages until connection closes
 			for {
@@ -1155,6 +5051,9 @@ func TestReconnection(t *testing.T) {
 			t.Error("Client should be connected after reconnect")
 		}
 
+		connectionMutex.Lock()
+		defer connectionMutex.Unlock()
+
 		// Verify number of connections
 		if connectionCount != 2 {
 			t.Errorf("Expected 2 connections, got %d", connectionCount)
@@ -1170,11 +5069,169 @@ func TestReconnection(t *testing.T) {
 			}
 		}
 	})
+
+	t.Run("resubscriptionConfirmedBeforeSuccess", func(t *testing.T) {
+		var connectionMutex sync.Mutex
+		connectionCount := 0
+
+		server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			connectionMutex.Lock()
+			connectionCount++
+			connID := connectionCount
+			connectionMutex.Unlock()
+
+			websocket.JSON.Send(ws, connEstablishedEvent(fmt.Sprintf("socket-%d", connID), 120))
+
+			for {
+				var evt Event
+				if err := websocket.JSON.Receive(ws, &evt); err != nil {
+					return
+				}
+				if evt.Event != pusherSubscribe {
+					continue
+				}
+
+				var data channelData
+				json.Unmarshal(evt.Data, &data)
+
+				// Delay confirmations on the second connection so that they
+				// arrive well after the connection is established.
+				go func() {
+					if connID > 1 {
+						time.Sleep(200 * time.Millisecond)
+					}
+					websocket.JSON.Send(ws, Event{
+						Event:   pusherInternalSubSucceeded,
+						Channel: data.Channel,
+					})
+				}()
+			}
+		}))
+		defer server.Close()
+
+		errorChan := make(chan error, 10)
+		host, port, _ := getServerHostPort(server)
+
+		client := &Client{
+			Insecure:     true,
+			OverrideHost: host,
+			OverridePort: port,
+			Errors:       errorChan,
+		}
+		defer client.Disconnect()
+
+		if err := client.Connect("test-app-key"); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+
+		channelNames := []string{"test-channel-1", "test-channel-2", "test-channel-3"}
+		channels := make([]Channel, 0, len(channelNames))
+		for _, name := range channelNames {
+			ch, err := client.Subscribe(name)
+			if err != nil {
+				t.Fatalf("Failed to subscribe to channel %s: %v", name, err)
+			}
+			channels = append(channels, ch)
+		}
+
+		client.mutex.Lock()
+		client.generation.reconnectDelay = 10 * time.Millisecond
+		client.mutex.Unlock()
+		client.ws.Close()
+
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case err := <-errorChan:
+				if strings.Contains(err.Error(), "resubscription failed") {
+					t.Fatalf("Expected resubscription to succeed, got %v", err)
+				}
+				if !strings.Contains(err.Error(), "reconnection successful") {
+					continue
+				}
+				for i, ch := range channels {
+					if !ch.IsSubscribed() {
+						t.Errorf("Expected channel %s to be subscribed before reconnection success", channelNames[i])
+					}
+				}
+				return
+			case <-timeout:
+				t.Fatal("Timeout waiting for reconnection")
+			}
+		}
+	})
+
+	t.Run("immediateReconnectOnError", func(t *testing.T) {
+		var connMutex sync.Mutex
+		connectionCount := 0
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			connMutex.Lock()
+			connectionCount++
+			count := connectionCount
+			connMutex.Unlock()
+
+			websocket.JSON.Send(ws, connEstablishedEvent(fmt.Sprintf("socket-%d", count), 120))
+
+			if count == 1 {
+				websocket.JSON.Send(ws, Event{
+					Event: pusherError,
+					Data:  json.RawMessage(`{"code":4201,"message":"Pong reply not received"}`),
+				})
+			}
+
+			var event Event
+			for websocket.JSON.Receive(ws, &event) == nil {
+			}
+		}))
+		defer srv.Close()
+
+		errChan := make(chan error, 10)
+		host, port, _ := getServerHostPort(srv)
+		client := &Client{
+			Insecure:     true,
+			OverrideHost: host,
+			OverridePort: port,
+			Errors:       errChan,
+			// A reconnection within the test timeout shows the delay was skipped
+			InitialReconnectDelay: time.Minute,
+		}
+		defer client.Disconnect()
+
+		if err := client.Connect("foo"); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+
+		var gotEventErr bool
+		timeout := time.After(5 * time.Second)
+		for reconnected := false; !reconnected; {
+			select {
+			case err := <-errChan:
+				var eventErr EventError
+				if errors.As(err, &eventErr) && eventErr.Code == 4201 {
+					gotEventErr = true
+				}
+				reconnected = err.Error() == "reconnection successful"
+			case <-timeout:
+				t.Fatal("Timeout waiting for immediate reconnection")
+			}
+		}
+
+		if !gotEventErr {
+			t.Error("Expected the 4201 error to be sent to Errors")
+		}
+		if socketID := client.SocketID(); socketID != "socket-2" {
+			t.Errorf("Expected new socket ID after reconnect, got %s", socketID)
+		}
+	})
 }
 
 // Helper functions
 func getServerHostPort(server *httptest.Server) (host string, port int, err error) {
-	host, portStr, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		return "", 0, err
+	}
+	host, portStr, err := net.SplitHostPort(serverURL.Host)
 	if err != nil {
 		return "", 0, err
 	}
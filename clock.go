@@ -0,0 +1,60 @@
+package pusher
+
+import "time"
+
+// clock is the source of the current time and of timers for the client's
+// time-dependent logic, such as the heartbeat, pong timeouts and reconnection
+// backoff. It lets tests control time instead of waiting for real delays.
+type clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) timer
+}
+
+// timer is a timer created by a clock. It behaves like time.Timer.
+type timer interface {
+	// C returns the channel on which the time is delivered when the timer
+	// fires.
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// realClock is the clock used by default, backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// now returns the current time according to the client's clock.
+func (c *Client) now() time.Time {
+	if c.clock == nil {
+		return time.Now()
+	}
+	return c.clock.Now()
+}
+
+// since returns the time elapsed since t according to the client's clock.
+func (c *Client) since(t time.Time) time.Duration {
+	return c.now().Sub(t)
+}
+
+// newTimer returns a timer from the client's clock that fires after d.
+func (c *Client) newTimer(d time.Duration) timer {
+	if c.clock == nil {
+		return realClock{}.NewTimer(d)
+	}
+	return c.clock.NewTimer(d)
+}
@@ -0,0 +1,373 @@
+package pusher
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// fakeClock is a clock whose time only moves when Advance is called.
+type fakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+	// created receives the duration of each timer created, so that tests can
+	// wait for the code under test to start waiting before advancing the
+	// clock. Sends don't block, so it should be buffered.
+	created chan time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		created: make(chan time.Duration, 100),
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) timer {
+	c.mutex.Lock()
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1), deadline: c.now.Add(d), active: true}
+	c.timers = append(c.timers, t)
+	c.mutex.Unlock()
+
+	select {
+	case c.created <- d:
+	default:
+	}
+	return t
+}
+
+// Advance moves the clock forward by d, firing the timers that expire.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.now = c.now.Add(d)
+	for _, t := range c.timers {
+		if t.active && !t.deadline.After(c.now) {
+			t.active = false
+			select {
+			case t.c <- c.now:
+			default:
+			}
+		}
+	}
+}
+
+// waitForTimer waits for a timer to be created and returns its duration.
+func (c *fakeClock) waitForTimer(t *testing.T) time.Duration {
+	t.Helper()
+
+	select {
+	case d := <-c.created:
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for a timer to be created")
+		return 0
+	}
+}
+
+type fakeTimer struct {
+	clock    *fakeClock
+	c        chan time.Time
+	deadline time.Time
+	active   bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+
+	wasActive := t.active
+	t.active = false
+	return wasActive
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+
+	wasActive := t.active
+	t.active = true
+	t.deadline = t.clock.now.Add(d)
+	return wasActive
+}
+
+func TestClientClockReconnectBackoff(t *testing.T) {
+	// A closed server refuses every reconnection attempt.
+	srv := httptest.NewServer(nil)
+	host, port, _ := getServerHostPort(srv)
+	srv.Close()
+
+	clock := newFakeClock()
+	client := &Client{
+		Insecure:              true,
+		OverrideHost:          host,
+		OverridePort:          port,
+		InitialReconnectDelay: time.Second,
+		MaxReconnectDelay:     4 * time.Second,
+		generation:            connGeneration{reconnectDelay: time.Second},
+		closed:                make(chan struct{}),
+		clock:                 clock,
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		client.reconnect(false)
+		close(stopped)
+	}()
+
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		if got := clock.waitForTimer(t); got != want {
+			t.Fatalf("Expected reconnect delay %v, got %v", want, got)
+		}
+		clock.Advance(want)
+	}
+
+	close(client.closed)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected reconnection to stop once the client is closed")
+	}
+}
+
+func TestClientClockHeartbeat(t *testing.T) {
+	pings := make(chan struct{}, 10)
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		websocket.JSON.Send(ws, connEstablishedEvent("foo", 120))
+
+		var event Event
+		for websocket.JSON.Receive(ws, &event) == nil {
+			if event.Event == pusherPing {
+				pings <- struct{}{}
+				websocket.Message.Send(ws, pongPayload)
+			}
+		}
+	}))
+	defer srv.Close()
+
+	clock := newFakeClock()
+	host, port, _ := getServerHostPort(srv)
+	client := &Client{
+		Insecure:     true,
+		OverrideHost: host,
+		OverridePort: port,
+		clock:        clock,
+	}
+	if err := client.Connect("foo"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	clock.Advance(119 * time.Second)
+	select {
+	case <-pings:
+		t.Fatal("Expected no ping before the activity timeout")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case <-pings:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a ping once the activity timeout elapsed")
+	}
+}
+
+func TestClientClockMaxLifetime(t *testing.T) {
+	conn := newConnectedFakeConn(t, "foo", 120)
+
+	clock := newFakeClock()
+	errChan := make(chan error, 10)
+	client := &Client{
+		MaxLifetime: time.Hour,
+		Errors:      errChan,
+		Dial: func(url, origin string) (Conn, error) {
+			return conn, nil
+		},
+		clock: clock,
+	}
+	if err := client.Connect("foo"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	for clock.waitForTimer(t) != time.Hour {
+	}
+	waitErr := make(chan error, 1)
+	go func() { waitErr <- client.Wait() }()
+
+	clock.Advance(time.Hour - time.Second)
+	select {
+	case err := <-waitErr:
+		t.Fatalf("Expected the client to stay connected before MaxLifetime, Wait returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case err := <-waitErr:
+		if err != ErrMaxLifetimeReached {
+			t.Errorf("Expected Wait to return %v, got %v", ErrMaxLifetimeReached, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the client to disconnect once MaxLifetime elapsed")
+	}
+	select {
+	case <-conn.closed:
+	default:
+		t.Error("Expected the connection to be closed")
+	}
+
+	for {
+		select {
+		case err := <-errChan:
+			if errors.Is(err, ErrMaxLifetimeReached) {
+				return
+			}
+		default:
+			t.Fatalf("Expected an error wrapping %v on Errors", ErrMaxLifetimeReached)
+		}
+	}
+}
+
+func TestClientClockHeartbeatReconnect(t *testing.T) {
+	// Run with the race detector: the heartbeat goroutines of replaced
+	// connections must not touch the timers of their successors.
+	clock := newFakeClock()
+	client := &Client{
+		Dial: func(url, origin string) (Conn, error) {
+			conn := newConnectedFakeConn(t, "foo", 1)
+			go func() {
+				// Answer each ping until the connection is closed
+				for {
+					select {
+					case msg := <-conn.sent:
+						var e Event
+						if json.Unmarshal(msg, &e) == nil && e.Event == pusherPing {
+							conn.received <- []byte(pongPayload)
+						}
+					case <-conn.closed:
+						return
+					}
+				}
+			}()
+			return conn, nil
+		},
+		clock: clock,
+	}
+	if err := client.Connect("foo"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	stop := make(chan struct{})
+	advanced := make(chan struct{})
+	go func() {
+		defer close(advanced)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				clock.Advance(time.Second)
+				runtime.Gosched()
+			}
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		if err := client.Reconnect(); err != nil {
+			t.Fatalf("Failed to reconnect: %v", err)
+		}
+	}
+	close(stop)
+	<-advanced
+
+	done := make(chan struct{})
+	go func() {
+		client.Disconnect()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the heartbeat goroutines to exit on disconnect")
+	}
+}
+
+func TestClientClockConnectDuringReconnect(t *testing.T) {
+	var dials atomic.Int32
+	conns := make(chan *fakeConn, 10)
+	clock := newFakeClock()
+	client := &Client{
+		InitialReconnectDelay: time.Second,
+		Dial: func(url, origin string) (Conn, error) {
+			dials.Add(1)
+			conn := newConnectedFakeConn(t, "foo", 120)
+			conns <- conn
+			return conn, nil
+		},
+		clock: clock,
+	}
+	if err := client.Connect("foo"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	// Lose the connection, and connect again while the reconnection waits
+	(<-conns).readErrors <- io.EOF
+	for clock.waitForTimer(t) != time.Second {
+	}
+	if err := client.Connect("foo"); err != nil {
+		t.Fatalf("Failed to connect during reconnection: %v", err)
+	}
+
+	clock.Advance(time.Second)
+	time.Sleep(50 * time.Millisecond)
+	if n := dials.Load(); n != 2 {
+		t.Errorf("Expected the pending reconnection not to replace the new connection, got %d dials", n)
+	}
+}
+
+func TestClientClockDisconnectWait(t *testing.T) {
+	clock := newFakeClock()
+	client := &Client{clock: clock}
+	client.wg.Add(1)
+	defer client.wg.Done()
+
+	errChan := make(chan error, 1)
+	go func() { errChan <- client.DisconnectWait(time.Minute) }()
+
+	if d := clock.waitForTimer(t); d != time.Minute {
+		t.Fatalf("Expected a timer for %v, got %v", time.Minute, d)
+	}
+	clock.Advance(time.Minute)
+	select {
+	case err := <-errChan:
+		if err != ErrTimedOut {
+			t.Errorf("Expected DisconnectWait to return %v, got %v", ErrTimedOut, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected DisconnectWait to time out once the clock advanced")
+	}
+}
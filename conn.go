@@ -0,0 +1,149 @@
+package pusher
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Conn is a message-oriented connection to Pusher. The client uses a
+// websocket connection by default; another implementation, such as an
+// in-memory connection for tests, can be provided with Client.Dial.
+type Conn interface {
+	// ReadMessage blocks until a message is received and returns it. Once the
+	// connection has been closed by the server, the error returned wraps
+	// io.EOF, and once it has been closed by Close, net.ErrClosed, so that the
+	// client can tell that the connection was lost.
+	ReadMessage() ([]byte, error)
+	// WriteMessage sends msg as a single message. It isn't called
+	// concurrently.
+	WriteMessage(msg []byte) error
+	// SetReadDeadline sets the deadline for ReadMessage, as for net.Conn. A
+	// zero value removes the deadline.
+	SetReadDeadline(t time.Time) error
+	// Close closes the connection. Blocked calls to ReadMessage return an
+	// error.
+	Close() error
+}
+
+// ReadErrorKind classifies the errors that occur while receiving messages.
+type ReadErrorKind int
+
+// Read error kinds
+const (
+	// ReadErrorClosed means that the connection was closed, by Pusher with a
+	// close frame, by the network, or by the client. golang.org/x/net/websocket
+	// reports a close frame as io.EOF, so it can't be told apart from the
+	// connection being dropped.
+	ReadErrorClosed ReadErrorKind = iota
+	// ReadErrorTimeout means that a read deadline was exceeded.
+	ReadErrorTimeout
+	// ReadErrorNetwork means that reading from the connection failed for
+	// another reason, such as the connection being reset.
+	ReadErrorNetwork
+	// ReadErrorProtocol means that a malformed websocket frame was received.
+	ReadErrorProtocol
+	// ReadErrorTooLarge means that a message larger than
+	// Client.MaxMessageSize was received and discarded.
+	ReadErrorTooLarge
+	// ReadErrorDecode means that a message was received but couldn't be
+	// decoded as an event.
+	ReadErrorDecode
+)
+
+func (k ReadErrorKind) String() string {
+	switch k {
+	case ReadErrorClosed:
+		return "closed"
+	case ReadErrorTimeout:
+		return "timeout"
+	case ReadErrorNetwork:
+		return "network"
+	case ReadErrorProtocol:
+		return "protocol"
+	case ReadErrorTooLarge:
+		return "too large"
+	case ReadErrorDecode:
+		return "decode"
+	default:
+		return fmt.Sprintf("ReadErrorKind(%d)", int(k))
+	}
+}
+
+// A ReadError is sent to Client.Errors when receiving a message fails. Kind
+// tells whether the connection can still be used.
+type ReadError struct {
+	Kind ReadErrorKind
+	Err  error
+}
+
+func (e ReadError) Error() string {
+	return fmt.Sprintf("read error (%s): %v", e.Kind, e.Err)
+}
+
+func (e ReadError) Unwrap() error {
+	return e.Err
+}
+
+// ConnectionLost reports whether the error leaves the connection unusable, in
+// which case the client reconnects. Messages that are too large or can't be
+// decoded are skipped, and the connection remains open.
+func (e ReadError) ConnectionLost() bool {
+	return e.Kind != ReadErrorTooLarge && e.Kind != ReadErrorDecode
+}
+
+// classifyReadError wraps err, returned by Conn.ReadMessage, in a ReadError of
+// the matching kind.
+func classifyReadError(err error) ReadError {
+	var netErr net.Error
+	var protocolErr *websocket.ProtocolError
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, net.ErrClosed):
+		return ReadError{Kind: ReadErrorClosed, Err: err}
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ReadError{Kind: ReadErrorTimeout, Err: err}
+	case errors.As(err, &protocolErr):
+		return ReadError{Kind: ReadErrorProtocol, Err: err}
+	default:
+		return ReadError{Kind: ReadErrorNetwork, Err: err}
+	}
+}
+
+// Pinger is implemented by a Conn that can send native websocket ping control
+// frames, which are sent when Client.UseNativePing is set.
+type Pinger interface {
+	// WritePing sends a ping control frame with data as its payload. It isn't
+	// called concurrently with WriteMessage.
+	WritePing(data []byte) error
+}
+
+// websocketConn is the Conn used by default, which sends each message as a
+// websocket text frame.
+type websocketConn struct {
+	*websocket.Conn
+}
+
+func (ws websocketConn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	err := websocket.Message.Receive(ws.Conn, &msg)
+	return msg, err
+}
+
+func (ws websocketConn) WriteMessage(msg []byte) error {
+	return websocket.Message.Send(ws.Conn, string(msg))
+}
+
+func (ws websocketConn) WritePing(data []byte) error {
+	// Write sends a frame of the connection's payload type, which is restored
+	// once the ping has been sent.
+	payloadType := ws.PayloadType
+	ws.PayloadType = websocket.PingFrame
+	defer func() { ws.PayloadType = payloadType }()
+
+	_, err := ws.Write(data)
+	return err
+}
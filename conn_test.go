@@ -0,0 +1,440 @@
+package pusher
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// fakeConn is an in-memory Conn. Messages pushed to received are read by the
+// client, and messages written by the client are sent to sent. Errors pushed
+// to readErrors are returned by ReadMessage.
+type fakeConn struct {
+	received   chan []byte
+	readErrors chan error
+	sent       chan []byte
+	closed     chan struct{}
+
+	mutex     sync.Mutex
+	deadline  time.Time
+	closeOnce sync.Once
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{
+		received:   make(chan []byte, 10),
+		readErrors: make(chan error, 10),
+		sent:       make(chan []byte, 10),
+		closed:     make(chan struct{}),
+	}
+}
+
+// newConnectedFakeConn returns a fakeConn on which the connection_established
+// event for socketID has been received, so that connecting with it succeeds.
+func newConnectedFakeConn(t *testing.T, socketID string, activityTimeout int) *fakeConn {
+	t.Helper()
+
+	conn := newFakeConn()
+	conn.push(t, connEstablishedEvent(socketID, activityTimeout))
+	return conn
+}
+
+// connEstablishedEvent returns the connection_established event sent by Pusher
+// for socketID, whose data is double-encoded.
+func connEstablishedEvent(socketID string, activityTimeout int) Event {
+	connData, _ := json.Marshal(connectionData{SocketID: socketID, ActivityTimeout: activityTimeout})
+	connDataStr, _ := json.Marshal(string(connData))
+	return Event{Event: pusherConnEstablished, Data: connDataStr}
+}
+
+func (c *fakeConn) ReadMessage() ([]byte, error) {
+	c.mutex.Lock()
+	deadline := c.deadline
+	c.mutex.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case msg := <-c.received:
+		return msg, nil
+	case err := <-c.readErrors:
+		return nil, err
+	case <-c.closed:
+		return nil, net.ErrClosed
+	case <-timeout:
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *fakeConn) WriteMessage(msg []byte) error {
+	select {
+	case <-c.closed:
+		return net.ErrClosed
+	case c.sent <- msg:
+		return nil
+	}
+}
+
+func (c *fakeConn) SetReadDeadline(t time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.deadline = t
+	return nil
+}
+
+func (c *fakeConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+// push sends e to the client as the server would.
+func (c *fakeConn) push(t *testing.T, e Event) {
+	t.Helper()
+
+	msg, err := json.Marshal(e)
+	if err != nil {
+		t.Fatalf("Failed to encode event: %v", err)
+	}
+	c.received <- msg
+}
+
+// next returns the next event sent by the client.
+func (c *fakeConn) next(t *testing.T) Event {
+	t.Helper()
+
+	select {
+	case msg := <-c.sent:
+		var e Event
+		if err := json.Unmarshal(msg, &e); err != nil {
+			t.Fatalf("Failed to decode event: %v", err)
+		}
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for the client to send an event")
+		return Event{}
+	}
+}
+
+func TestClientDial(t *testing.T) {
+	t.Run("fakeConn", func(t *testing.T) {
+		conn := newConnectedFakeConn(t, "123.456", 120)
+
+		var gotURL string
+		client := &Client{
+			Dial: func(url, origin string) (Conn, error) {
+				gotURL = url
+				return conn, nil
+			},
+		}
+		if err := client.Connect("foo"); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer client.Disconnect()
+
+		if want := client.generateConnURL("foo", ""); gotURL != want {
+			t.Errorf("Expected Dial to be called with %q, got %q", want, gotURL)
+		}
+		if socketID := client.SocketID(); socketID != "123.456" {
+			t.Errorf("Expected socket ID 123.456, got %s", socketID)
+		}
+
+		subscribed := make(chan error, 1)
+		var ch Channel
+		go func() {
+			var err error
+			ch, err = client.Subscribe("bar")
+			subscribed <- err
+		}()
+		if e := conn.next(t); e.Event != pusherSubscribe {
+			t.Errorf("Expected a subscribe event, got %+v", e)
+		}
+		conn.push(t, Event{Event: pusherInternalSubSucceeded, Channel: "bar", Data: json.RawMessage(`"{}"`)})
+		if err := <-subscribed; err != nil {
+			t.Fatalf("Expected error to be `nil`, got %v", err)
+		}
+
+		dataChan := ch.Bind("baz")
+		conn.push(t, Event{Event: "baz", Channel: "bar", Data: json.RawMessage(`"qux"`)})
+		select {
+		case data := <-dataChan:
+			if string(data) != `"qux"` {
+				t.Errorf("Expected data %q, got %s", `"qux"`, data)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected to receive the event")
+		}
+
+		if err := client.Disconnect(); err != nil {
+			t.Fatalf("Failed to disconnect: %v", err)
+		}
+		select {
+		case <-conn.closed:
+		default:
+			t.Error("Expected Disconnect to close the connection")
+		}
+	})
+
+	t.Run("handshakeTimeout", func(t *testing.T) {
+		conn := newFakeConn()
+		client := &Client{
+			HandshakeTimeout: 10 * time.Millisecond,
+			Dial: func(url, origin string) (Conn, error) {
+				return conn, nil
+			},
+		}
+		if err := client.Connect("foo"); !errors.Is(err, ErrTimedOut) {
+			t.Errorf("Expected Connect to return an error wrapping %v, got %v", ErrTimedOut, err)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		wantErr := errors.New("foo")
+		client := &Client{
+			Dial: func(url, origin string) (Conn, error) {
+				return nil, wantErr
+			},
+		}
+		if err := client.Connect("foo"); !errors.Is(err, wantErr) {
+			t.Errorf("Expected Connect to return an error wrapping %v, got %v", wantErr, err)
+		}
+	})
+}
+
+// fakePingConn is a fakeConn that also implements Pinger.
+type fakePingConn struct {
+	*fakeConn
+	// pings receives the number of messages sent before each ping.
+	pings chan int
+}
+
+func (c *fakePingConn) WritePing(data []byte) error {
+	c.pings <- len(c.sent)
+	return nil
+}
+
+func TestClientUseNativePing(t *testing.T) {
+	testCases := []struct {
+		name          string
+		useNativePing bool
+	}{
+		{"disabled", false},
+		{"enabled", true},
+	}
+
+	for _, tc := range testCases {
+		useNativePing := tc.useNativePing
+		t.Run(tc.name, func(t *testing.T) {
+			conn := &fakePingConn{fakeConn: newFakeConn(), pings: make(chan int, 1)}
+			client := &Client{UseNativePing: useNativePing}
+			if err := client.sendPing(conn); err != nil {
+				t.Fatalf("Failed to send ping: %v", err)
+			}
+
+			if e := conn.next(t); e.Event != pusherPing {
+				t.Errorf("Expected a pusher:ping event, got %+v", e)
+			}
+			select {
+			case sent := <-conn.pings:
+				if !useNativePing {
+					t.Error("Expected no native ping to be sent")
+				} else if sent != 0 {
+					t.Error("Expected the native ping to be sent before pusher:ping")
+				}
+			default:
+				if useNativePing {
+					t.Error("Expected a native ping to be sent")
+				}
+			}
+		})
+	}
+}
+
+func TestWebsocketConnWritePing(t *testing.T) {
+	type frame struct {
+		payloadType byte
+		payload     string
+	}
+	frames := make(chan frame, 2)
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		// Frames are read directly so that the ping isn't answered and
+		// discarded.
+		for i := 0; i < 2; i++ {
+			r, err := ws.NewFrameReader()
+			if err != nil {
+				return
+			}
+			payload, _ := io.ReadAll(r)
+			frames <- frame{r.PayloadType(), string(payload)}
+		}
+	}))
+	defer srv.Close()
+	wsURL := strings.Replace(srv.URL, "http", "ws", 1)
+	ws, err := websocket.Dial(wsURL, "ws", localOrigin)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	conn := websocketConn{ws}
+	defer conn.Close()
+
+	if err := conn.WritePing([]byte("foo")); err != nil {
+		t.Fatalf("Failed to write ping: %v", err)
+	}
+	if err := conn.WriteMessage([]byte("bar")); err != nil {
+		t.Fatalf("Failed to write message: %v", err)
+	}
+
+	for _, want := range []frame{{websocket.PingFrame, "foo"}, {websocket.TextFrame, "bar"}} {
+		select {
+		case got := <-frames:
+			if got != want {
+				t.Errorf("Expected frame %+v, got %+v", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timeout waiting for a frame")
+		}
+	}
+}
+
+func TestClassifyReadError(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+		want ReadErrorKind
+	}{
+		{"eof", io.EOF, ReadErrorClosed},
+		{"unexpectedEOF", io.ErrUnexpectedEOF, ReadErrorClosed},
+		{"closed", &net.OpError{Op: "read", Err: net.ErrClosed}, ReadErrorClosed},
+		{"timeout", os.ErrDeadlineExceeded, ReadErrorTimeout},
+		{"protocol", websocket.ErrBadMaskingKey, ReadErrorProtocol},
+		{"network", &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}, ReadErrorNetwork},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := classifyReadError(tc.err)
+			if got.Kind != tc.want {
+				t.Errorf("Expected kind %v, got %v", tc.want, got.Kind)
+			}
+			if !errors.Is(got, tc.err) {
+				t.Errorf("Expected the ReadError to wrap %v", tc.err)
+			}
+			if !got.ConnectionLost() {
+				t.Errorf("Expected a %v error to lose the connection", got.Kind)
+			}
+		})
+	}
+}
+
+func TestClientReadErrors(t *testing.T) {
+	connect := func(t *testing.T) (*Client, *fakeConn, chan error, chan error) {
+		t.Helper()
+
+		conn := newConnectedFakeConn(t, "foo", 120)
+
+		errChan := make(chan error, 10)
+		disconnects := make(chan error, 10)
+		dials := 0
+		client := &Client{
+			Errors:                errChan,
+			InitialReconnectDelay: time.Hour,
+			OnDisconnect:          func(err error) { disconnects <- err },
+			Dial: func(url, origin string) (Conn, error) {
+				dials++
+				if dials > 1 {
+					return nil, errors.New("refused")
+				}
+				return conn, nil
+			},
+		}
+		if err := client.Connect("foo"); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		return client, conn, errChan, disconnects
+	}
+
+	nextReadError := func(t *testing.T, errChan chan error) ReadError {
+		t.Helper()
+
+		for {
+			select {
+			case err := <-errChan:
+				var readErr ReadError
+				if errors.As(err, &readErr) {
+					return readErr
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Timeout waiting for a ReadError on Errors")
+				return ReadError{}
+			}
+		}
+	}
+
+	t.Run("decode", func(t *testing.T) {
+		client, conn, errChan, disconnects := connect(t)
+		defer client.Disconnect()
+
+		events := client.Bind("foo")
+		conn.received <- []byte(`{"event":`)
+		conn.push(t, Event{Event: "foo"})
+
+		if readErr := nextReadError(t, errChan); readErr.Kind != ReadErrorDecode {
+			t.Errorf("Expected a %v error, got %v", ReadErrorDecode, readErr)
+		}
+		select {
+		case <-events:
+		case <-time.After(time.Second):
+			t.Fatal("Expected the connection to remain usable after a decode error")
+		}
+		select {
+		case err := <-disconnects:
+			t.Errorf("Expected the connection not to be dropped, got %v", err)
+		default:
+		}
+	})
+
+	testCases := []struct {
+		name string
+		err  error
+		want ReadErrorKind
+	}{
+		{"closed", io.EOF, ReadErrorClosed},
+		{"timeout", os.ErrDeadlineExceeded, ReadErrorTimeout},
+		{"network", errors.New("connection reset by peer"), ReadErrorNetwork},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, conn, errChan, disconnects := connect(t)
+			defer client.Disconnect()
+
+			conn.readErrors <- tc.err
+
+			if readErr := nextReadError(t, errChan); readErr.Kind != tc.want {
+				t.Errorf("Expected a %v error, got %v", tc.want, readErr)
+			}
+			select {
+			case err := <-disconnects:
+				if !errors.Is(err, tc.err) {
+					t.Errorf("Expected the connection to be dropped with %v, got %v", tc.err, err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Expected the connection to be dropped")
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package pusher
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 )
@@ -12,21 +13,87 @@ type Event struct {
 	Channel string          `json:"channel,omitempty"`
 }
 
+// Unmarshal unmarshals the event's data into dest. The data may be either
+// double-encoded, following Pusher's convention, or a plain JSON value. See
+// UnmarshalAuto.
+func (e Event) Unmarshal(dest interface{}) error {
+	return UnmarshalAuto(e.Data, dest)
+}
+
+// DataString returns the event's data as a string. If the data is a JSON
+// string, such as double-encoded data, its decoded contents are returned.
+// Otherwise the raw JSON is returned.
+func (e Event) DataString() (string, error) {
+	if len(bytes.TrimSpace(e.Data)) == 0 {
+		return "", nil
+	}
+
+	var dataStr string
+	if err := json.Unmarshal(e.Data, &dataStr); err == nil {
+		return dataStr, nil
+	}
+	if !json.Valid(e.Data) {
+		return "", fmt.Errorf("event data is not valid JSON: %s", e.Data)
+	}
+	return string(e.Data), nil
+}
+
+// Direction is the direction of a frame passed to Client.OnFrame.
+type Direction int
+
+// Frame directions
+const (
+	DirectionSent Direction = iota
+	DirectionReceived
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionSent:
+		return "sent"
+	case DirectionReceived:
+		return "received"
+	default:
+		return fmt.Sprintf("Direction(%d)", int(d))
+	}
+}
+
 // EventError represents an error event received from Pusher.
 type EventError struct {
 	Message string `json:"message"`
 	Code    int    `json:"code"`
+	// Raw holds the complete data of the error event, including any fields
+	// other than the message and code.
+	Raw json.RawMessage `json:"-"`
+}
+
+// UnmarshalRaw unmarshals the complete data of the error event into dest, so
+// that fields other than the message and code can be inspected.
+func (e EventError) UnmarshalRaw(dest interface{}) error {
+	return UnmarshalAuto(e.Raw, dest)
 }
 
 func (e EventError) Error() string {
 	return fmt.Sprintf("Pusher error: code %d, message %q", e.Code, e.Message)
 }
 
+// UnexpectedEventError is returned by Connect when Pusher responds to the
+// connection with an event other than connection_established or an error. It
+// carries the full event so that its data can be inspected.
+type UnexpectedEventError struct {
+	Event Event
+}
+
+func (e UnexpectedEventError) Error() string {
+	return fmt.Sprintf("got unknown event type from Pusher: %s, data: %s", e.Event.Event, e.Event.Data)
+}
+
 func extractEventError(event Event) error {
 	var eventErr EventError
 	err := json.Unmarshal(event.Data, &eventErr)
 	if err != nil {
 		return err
 	}
+	eventErr.Raw = event.Data
 	return eventErr
 }
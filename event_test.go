@@ -3,6 +3,7 @@ package pusher
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -21,6 +22,17 @@ func TestEventErrorError(t *testing.T) {
 	}
 }
 
+func TestUnexpectedEventErrorError(t *testing.T) {
+	err := UnexpectedEventError{Event: Event{Event: "foo", Data: json.RawMessage(`"bar"`)}}
+	errMsg := err.Error()
+	if !strings.Contains(errMsg, "foo") {
+		t.Errorf("Expected error message to contain 'foo', got %s", errMsg)
+	}
+	if !strings.Contains(errMsg, `"bar"`) {
+		t.Errorf(`Expected error message to contain '"bar"', got %s`, errMsg)
+	}
+}
+
 func TestExtractEventError(t *testing.T) {
 	wantMessage := "foo"
 	wantCode := 123
@@ -36,3 +48,76 @@ func TestExtractEventError(t *testing.T) {
 		t.Errorf("Expected error code to be %d, got %d", wantCode, evtErr.Code)
 	}
 }
+
+func TestExtractEventErrorRaw(t *testing.T) {
+	event := Event{
+		Data: json.RawMessage(`{"message":"foo","code":4001,"details":{"reason":"bar"}}`),
+	}
+	evtErr := extractEventError(event).(EventError)
+	if !reflect.DeepEqual(evtErr.Raw, event.Data) {
+		t.Errorf("Expected raw data to be %s, got %s", event.Data, evtErr.Raw)
+	}
+
+	var details struct {
+		Details struct {
+			Reason string `json:"reason"`
+		} `json:"details"`
+	}
+	if err := evtErr.UnmarshalRaw(&details); err != nil {
+		t.Errorf("Expected error to be `nil`, got %v", err)
+	}
+	if details.Details.Reason != "bar" {
+		t.Errorf("Expected reason to be %q, got %q", "bar", details.Details.Reason)
+	}
+}
+
+func TestEventUnmarshal(t *testing.T) {
+	wantData := map[string]interface{}{"foo": "A"}
+
+	testCases := []struct {
+		name string
+		data json.RawMessage
+	}{
+		{"stringEncoded", json.RawMessage(`"{\"foo\":\"A\"}"`)},
+		{"objectEncoded", json.RawMessage(`{"foo":"A"}`)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dest := map[string]interface{}{}
+			if err := (Event{Data: tc.data}).Unmarshal(&dest); err != nil {
+				t.Errorf("Expected error to be `nil`, got %v", err)
+			}
+			if !reflect.DeepEqual(dest, wantData) {
+				t.Errorf("Expected dest to deep-equal %+v, got %+v", wantData, dest)
+			}
+		})
+	}
+}
+
+func TestEventDataString(t *testing.T) {
+	testCases := []struct {
+		name    string
+		data    json.RawMessage
+		want    string
+		wantErr bool
+	}{
+		{"stringEncoded", json.RawMessage(`"{\"foo\":\"A\"}"`), `{"foo":"A"}`, false},
+		{"objectEncoded", json.RawMessage(`{"foo":"A"}`), `{"foo":"A"}`, false},
+		{"plainString", json.RawMessage(`"hello"`), "hello", false},
+		{"empty", nil, "", false},
+		{"invalid", json.RawMessage(`{foo`), "", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := (Event{Data: tc.data}).DataString()
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Expected error %v, got %v", tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Errorf("Expected data string %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
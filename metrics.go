@@ -0,0 +1,55 @@
+package pusher
+
+import "time"
+
+// Metrics receives measurements from a Client, so that they can be exported to
+// a metrics system without this package depending on one. Methods may be
+// called concurrently. They are called once the client's lock has been
+// released, so implementations may call methods on the Client, such as Stats.
+type Metrics interface {
+	// IncEventsReceived is called for each event received from Pusher.
+	IncEventsReceived(event string)
+	// IncReconnects is called each time the client reconnects after the
+	// connection was lost.
+	IncReconnects()
+	// ObservePongLatency is called with the round-trip time of each ping
+	// answered by Pusher.
+	ObservePongLatency(d time.Duration)
+	// SetConnected is called when the client connects or disconnects.
+	SetConnected(connected bool)
+}
+
+// Stats is a snapshot of a Client's connection statistics, returned by
+// Client.Stats.
+type Stats struct {
+	// ConnectedSince is when the current connection was established. It is the
+	// zero time if the client isn't connected.
+	ConnectedSince time.Time
+	// ReconnectCount is the number of times the client has reconnected since
+	// Connect was called, and LastReconnect is when it last did so.
+	ReconnectCount int
+	LastReconnect  time.Time
+	// EventsReceived is the number of events received from Pusher since
+	// Connect was called.
+	EventsReceived uint64
+	// PongFailures is the number of consecutive pings that Pusher hasn't
+	// answered in time.
+	PongFailures int
+}
+
+// Stats returns a snapshot of the client's connection statistics.
+func (c *Client) Stats() Stats {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	stats := Stats{
+		ReconnectCount: c.reconnectCount,
+		LastReconnect:  c.lastReconnect,
+		EventsReceived: c.eventsReceived,
+		PongFailures:   c.generation.pongFailures,
+	}
+	if c.connected {
+		stats.ConnectedSince = c.connectedSince
+	}
+	return stats
+}
@@ -15,6 +15,9 @@ var (
 	// server violated the message protocol by not providing a member for the
 	// current user.
 	ErrMissingMe = errors.New("missing member for current user")
+	// ErrMemberNotFound is returned when a presence channel member is looked up
+	// by an ID that isn't in the channel.
+	ErrMemberNotFound = errors.New("member not found")
 )
 
 // Member represents a channel member.
@@ -38,6 +41,13 @@ type PresenceChannel interface {
 	// UnbindMemberAdded when finished listening to events.
 	BindMemberAdded() chan Member
 
+	// BindMemberAddedWithSnapshot is like BindMemberAdded, but the returned
+	// channel first receives each user currently subscribed to the channel, so
+	// that a binding made after the channel was subscribed doesn't miss the
+	// initial members. The members are read and the binding made atomically,
+	// so no member is missed or delivered twice.
+	BindMemberAddedWithSnapshot() chan Member
+
 	// UnbindMemberAdded removes bindings created by BindMemberAdded(). If chans
 	// are passed, only those bindings will be removed. Otherwise, all bindings
 	// for this event will be removed.
@@ -57,10 +67,22 @@ type PresenceChannel interface {
 	// channel.
 	Members() map[string]Member
 
+	// EachMember calls f with the ID and info of each user currently subscribed
+	// to the channel, in no particular order, until f returns false. Unlike
+	// Members, it doesn't copy the members. Membership changes wait until the
+	// iteration is complete, so f must not call methods of the channel.
+	EachMember(f func(id string, info json.RawMessage) bool)
+
 	// Member returns a representation of the channel member with the given ID.
 	// `nil` is returned if the member isn't in the channel.
 	Member(id string) *Member
 
+	// UnmarshalMember unmarshals the info of the channel member with the given
+	// ID into dest. An error wrapping ErrMemberNotFound is returned if the
+	// member isn't in the channel. dest is left unchanged if the member has no
+	// info.
+	UnmarshalMember(id string, dest interface{}) error
+
 	// Me returns the member for the current user.
 	//
 	// Possible errors:
@@ -71,6 +93,13 @@ type PresenceChannel interface {
 
 	// MemberCount returns the number of users connected to the channel.
 	MemberCount() int
+
+	// Snapshot returns a copy of the members currently subscribed to the
+	// channel and their number, read together so that they are consistent.
+	// It reflects every member event processed so far, and may be called at
+	// any time, such as by a UI that is set up after the channel was
+	// subscribed.
+	Snapshot() (members map[string]Member, count int)
 }
 
 // presenceChannel implements the internalChannel and PresenceChannel interfaces
@@ -133,7 +162,7 @@ func (pc *presenceChannel) handleEvent(event string, data json.RawMessage) {
 			Info: member.UserInfo,
 		}
 
-		sendMemberAdded(pc.memberAddedChans, pc.members[member.UserID])
+		sendMemberAdded(pc.memberAddedChans, pc.deliveries(), pc.members[member.UserID])
 		pc.membersMutex.Unlock()
 
 	case pusherInternalMemberRemoved:
@@ -147,7 +176,7 @@ func (pc *presenceChannel) handleEvent(event string, data json.RawMessage) {
 		pc.membersMutex.Lock()
 		delete(pc.members, member.UserID)
 
-		sendMemberRemoved(pc.memberRemovedChans, member.UserID)
+		sendMemberRemoved(pc.memberRemovedChans, pc.deliveries(), member.UserID)
 		pc.membersMutex.Unlock()
 
 	case pusherInternalSubSucceeded:
@@ -172,7 +201,7 @@ func (pc *presenceChannel) handleEvent(event string, data json.RawMessage) {
 		pc.privateChannel.channel.handleEvent(event, data)
 
 		for _, member := range pc.members {
-			sendMemberAdded(pc.memberAddedChans, member)
+			sendMemberAdded(pc.memberAddedChans, pc.deliveries(), member)
 		}
 		pc.membersMutex.Unlock()
 
@@ -181,25 +210,28 @@ func (pc *presenceChannel) handleEvent(event string, data json.RawMessage) {
 	}
 }
 
-func sendMemberAdded(channels map[chan Member]chan struct{}, member Member) {
+// sendMemberAdded sends member to each of channels in its own goroutine,
+// counted in pending so that DisconnectContext waits for it.
+func sendMemberAdded(channels map[chan Member]chan struct{}, pending *deliveryCounter, member Member) {
 	for ch, doneChan := range channels {
-		go func(ch chan Member, member Member, doneChan chan struct{}) {
+		deliver(pending, func() {
 			select {
 			case ch <- member:
 			case <-doneChan:
 			}
-		}(ch, member, doneChan)
+		})
 	}
 }
 
-func sendMemberRemoved(channels map[chan string]chan struct{}, id string) {
+// sendMemberRemoved sends id to each of channels as sendMemberAdded does.
+func sendMemberRemoved(channels map[chan string]chan struct{}, pending *deliveryCounter, id string) {
 	for ch, doneChan := range channels {
-		go func(ch chan string, id string, doneChan chan struct{}) {
+		deliver(pending, func() {
 			select {
 			case ch <- id:
 			case <-doneChan:
 			}
-		}(ch, id, doneChan)
+		})
 	}
 }
 
@@ -213,6 +245,22 @@ func (pc *presenceChannel) BindMemberAdded() chan Member {
 	return ch
 }
 
+func (pc *presenceChannel) BindMemberAddedWithSnapshot() chan Member {
+	pc.membersMutex.Lock()
+	defer pc.membersMutex.Unlock()
+
+	ch := make(chan Member)
+	doneChan := make(chan struct{})
+	pc.memberAddedChans[ch] = doneChan
+
+	binding := map[chan Member]chan struct{}{ch: doneChan}
+	for _, member := range pc.members {
+		sendMemberAdded(binding, pc.deliveries(), member)
+	}
+
+	return ch
+}
+
 func (pc *presenceChannel) UnbindMemberAdded(chans ...chan Member) {
 	pc.membersMutex.Lock()
 	defer pc.membersMutex.Unlock()
@@ -287,6 +335,17 @@ func (pc *presenceChannel) Members() map[string]Member {
 	return members
 }
 
+func (pc *presenceChannel) EachMember(f func(id string, info json.RawMessage) bool) {
+	pc.membersMutex.RLock()
+	defer pc.membersMutex.RUnlock()
+
+	for id, member := range pc.members {
+		if !f(id, member.Info) {
+			return
+		}
+	}
+}
+
 func (pc *presenceChannel) Member(id string) *Member {
 	pc.membersMutex.RLock()
 	defer pc.membersMutex.RUnlock()
@@ -299,6 +358,20 @@ func (pc *presenceChannel) Member(id string) *Member {
 	return nil
 }
 
+func (pc *presenceChannel) UnmarshalMember(id string, dest interface{}) error {
+	member := pc.Member(id)
+	if member == nil {
+		return fmt.Errorf("member %q: %w", id, ErrMemberNotFound)
+	}
+	if len(member.Info) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(member.Info, dest); err != nil {
+		return fmt.Errorf("decoding info of member %q: %w", id, err)
+	}
+	return nil
+}
+
 func (pc *presenceChannel) Me() (*Member, error) {
 	if !pc.privateChannel.channel.IsSubscribed() {
 		return nil, ErrNotSubscribed
@@ -328,6 +401,18 @@ func (pc *presenceChannel) MemberCount() int {
 	return len(pc.members)
 }
 
+func (pc *presenceChannel) Snapshot() (map[string]Member, int) {
+	pc.membersMutex.RLock()
+	defer pc.membersMutex.RUnlock()
+
+	members := make(map[string]Member, len(pc.members))
+	for id, member := range pc.members {
+		members[id] = member
+	}
+
+	return members, len(members)
+}
+
 func (pc *presenceChannel) ResetSubscriptionState() {
 	pc.channel.ResetSubscriptionState()
 }
@@ -2,7 +2,11 @@ package pusher
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -137,6 +141,96 @@ func TestPresenceMembers(t *testing.T) {
 		}
 	})
 
+	t.Run("EachMember()", func(t *testing.T) {
+		ch := newPresenceChannel(&channel{})
+		ch.members = map[string]Member{
+			"1": {"1", json.RawMessage(`{ "name": "name-1" }`)},
+			"2": {"2", json.RawMessage(`{ "name": "name-2" }`)},
+			"3": {"3", json.RawMessage(`{ "name": "name-3" }`)},
+		}
+
+		got := map[string]json.RawMessage{}
+		ch.EachMember(func(id string, info json.RawMessage) bool {
+			got[id] = info
+			return true
+		})
+		want := map[string]json.RawMessage{}
+		for id, member := range ch.members {
+			want[id] = member.Info
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %+v, got %+v", want, got)
+		}
+
+		calls := 0
+		ch.EachMember(func(string, json.RawMessage) bool {
+			calls++
+			return false
+		})
+		if calls != 1 {
+			t.Errorf("Expected iteration to stop after 1 call, got %d", calls)
+		}
+	})
+
+	t.Run("EachMemberConcurrent", func(t *testing.T) {
+		ch := newPresenceChannel(&channel{})
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				data, _ := json.Marshal(fmt.Sprintf(`{"user_id":"%d","user_info":{}}`, i))
+				ch.handleEvent(pusherInternalMemberAdded, data)
+			}
+		}()
+		for i := 0; i < 100; i++ {
+			ch.EachMember(func(string, json.RawMessage) bool { return true })
+		}
+		wg.Wait()
+
+		if count := ch.MemberCount(); count != 100 {
+			t.Errorf("Expected 100 members, got %d", count)
+		}
+	})
+
+	t.Run("UnmarshalMember()", func(t *testing.T) {
+		ch := newPresenceChannel(&channel{})
+		ch.members = map[string]Member{
+			"1": {"1", json.RawMessage(`{ "name": "name-1" }`)},
+			"2": {"2", nil},
+			"3": {"3", json.RawMessage(`"name-3"`)},
+		}
+
+		type info struct {
+			Name string `json:"name"`
+		}
+
+		var got info
+		if err := ch.UnmarshalMember("1", &got); err != nil {
+			t.Fatal("Expected no error, got ", err)
+		}
+		if want := (info{Name: "name-1"}); got != want {
+			t.Errorf("Expected %+v, got %+v", want, got)
+		}
+
+		if err := ch.UnmarshalMember("2", &got); err != nil {
+			t.Errorf("Expected no error for a member without info, got %v", err)
+		}
+
+		if err := ch.UnmarshalMember("3", &got); err == nil {
+			t.Error("Expected an error decoding mismatched info, got nil")
+		}
+
+		err := ch.UnmarshalMember("4", &got)
+		if !errors.Is(err, ErrMemberNotFound) {
+			t.Errorf("Expected ErrMemberNotFound, got %v", err)
+		}
+		if err != nil && !strings.Contains(err.Error(), `"4"`) {
+			t.Errorf("Expected the error to name the member, got %v", err)
+		}
+	})
+
 	t.Run("Me()", func(t *testing.T) {
 		ch := newPresenceChannel(&channel{})
 		ch.members = map[string]Member{
@@ -172,6 +266,73 @@ func TestPresenceMembers(t *testing.T) {
 		}
 	})
 
+	t.Run("Snapshot()", func(t *testing.T) {
+		ch := newPresenceChannel(&channel{})
+		ch.members = map[string]Member{
+			"1": {"1", json.RawMessage(`{ "name": "name-1" }`)},
+			"2": {"2", json.RawMessage(`{ "name": "name-2" }`)},
+		}
+
+		removed, _ := json.Marshal(`{"user_id": "2"}`)
+		ch.handleEvent(pusherInternalMemberRemoved, json.RawMessage(removed))
+		added, _ := json.Marshal(`{"user_id": "3", "user_info": { "name": "name-3" }}`)
+		ch.handleEvent(pusherInternalMemberAdded, json.RawMessage(added))
+
+		members, count := ch.Snapshot()
+		expectMembers := map[string]Member{
+			"1": {"1", json.RawMessage(`{ "name": "name-1" }`)},
+			"3": {"3", json.RawMessage(`{ "name": "name-3" }`)},
+		}
+		if !reflect.DeepEqual(members, expectMembers) {
+			t.Errorf("Expected %+v, got %+v", expectMembers, members)
+		}
+		if count != 2 {
+			t.Errorf("Expected count %d, got %d", 2, count)
+		}
+
+		// The snapshot is a copy
+		delete(members, "1")
+		if ch.MemberCount() != 2 {
+			t.Error("Expected modifying the snapshot not to affect the channel")
+		}
+	})
+
+	t.Run("BindMemberAddedWithSnapshot()", func(t *testing.T) {
+		ch := newPresenceChannel(&channel{})
+		ch.members = map[string]Member{
+			"1": {"1", json.RawMessage(`{ "name": "name-1" }`)},
+			"2": {"2", json.RawMessage(`{ "name": "name-2" }`)},
+		}
+		memberAddedChan := ch.BindMemberAddedWithSnapshot()
+
+		data, _ := json.Marshal(`{"user_id": "3", "user_info": { "name": "name-3" }}`)
+		ch.handleEvent(pusherInternalMemberAdded, json.RawMessage(data))
+
+		got := map[string]Member{}
+		for i := 0; i < 3; i++ {
+			select {
+			case member := <-memberAddedChan:
+				got[member.ID] = member
+			case <-time.After(time.Second):
+				t.Fatal("Not enough member added events")
+			}
+		}
+		expectMembers := map[string]Member{
+			"1": {"1", json.RawMessage(`{ "name": "name-1" }`)},
+			"2": {"2", json.RawMessage(`{ "name": "name-2" }`)},
+			"3": {"3", json.RawMessage(`{ "name": "name-3" }`)},
+		}
+		if !reflect.DeepEqual(got, expectMembers) {
+			t.Errorf("Expected %+v, got %+v", expectMembers, got)
+		}
+
+		select {
+		case member := <-memberAddedChan:
+			t.Errorf("Expected each member to be delivered once, got %+v again", member)
+		case <-time.After(20 * time.Millisecond):
+		}
+	})
+
 	t.Run("BindMemberAdded()", func(t *testing.T) {
 		ch := newPresenceChannel(&channel{})
 		memberAddedChan := ch.BindMemberAdded()
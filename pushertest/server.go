@@ -0,0 +1,440 @@
+// Package pushertest provides a fake Pusher server for testing code that uses
+// the pusher package, without hand-rolling websocket handlers.
+//
+// The server accepts connections from any app key, responds to pings and
+// subscription requests, and can be told to send events and errors to its
+// clients:
+//
+//	srv := pushertest.NewServer()
+//	defer srv.Close()
+//
+//	client := &pusher.Client{}
+//	srv.Configure(client)
+//	client.Connect("app-key")
+//	ch, _ := client.Subscribe("my-channel")
+//	events := ch.Bind("my-event")
+//	srv.Trigger("my-channel", "my-event", map[string]string{"foo": "bar"})
+//
+// Auth signatures are not checked, so private and presence channels can be
+// subscribed to with any signature.
+package pushertest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	pusher "github.com/bencurio/pusher-ws-go"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	pusherPing                  = "pusher:ping"
+	pusherPong                  = "pusher:pong"
+	pusherError                 = "pusher:error"
+	pusherSubscribe             = "pusher:subscribe"
+	pusherUnsubscribe           = "pusher:unsubscribe"
+	pusherConnEstablished       = "pusher:connection_established"
+	pusherInternalSubSucceeded  = "pusher_internal:subscription_succeeded"
+	pusherInternalSubError      = "pusher_internal:subscription_error"
+	pusherInternalMemberAdded   = "pusher_internal:member_added"
+	pusherInternalMemberRemoved = "pusher_internal:member_removed"
+
+	presencePrefix = "presence-"
+
+	defaultActivityTimeout = 120
+)
+
+// ErrNoConnections is returned when an event is sent while no client is
+// connected to the server, or no client is subscribed to the channel.
+var ErrNoConnections = errors.New("no matching connections")
+
+// Server is a fake Pusher server. Its exported fields must be set before any
+// client connects.
+type Server struct {
+	// The activity timeout, in seconds, sent to clients in the
+	// connection_established event. The default is 120.
+	ActivityTimeout int
+
+	// If set, SubscriptionError is called for each subscription request. If it
+	// returns a non-nil error, the subscription is rejected with a
+	// subscription_error event carrying the error's message.
+	SubscriptionError func(channel string) error
+
+	srv *httptest.Server
+
+	mutex        sync.Mutex
+	conns        map[*conn]struct{}
+	connCount    int
+	clientEvents []pusher.Event
+}
+
+// conn is a client connection to the server.
+type conn struct {
+	ws       *websocket.Conn
+	socketID string
+
+	writeMutex sync.Mutex
+
+	// channels holds the channels the connection is subscribed to, and for
+	// presence channels, the member data it subscribed with. It's guarded by
+	// the server's mutex.
+	channels map[string]*member
+}
+
+// member is a member of a presence channel.
+type member struct {
+	UserID   string          `json:"user_id"`
+	UserInfo json.RawMessage `json:"user_info,omitempty"`
+}
+
+type connectionData struct {
+	SocketID        string `json:"socket_id"`
+	ActivityTimeout int    `json:"activity_timeout"`
+}
+
+type subscriptionData struct {
+	Channel     string          `json:"channel"`
+	Auth        string          `json:"auth,omitempty"`
+	ChannelData json.RawMessage `json:"channel_data,omitempty"`
+}
+
+type presenceData struct {
+	Presence struct {
+		IDs   []string                   `json:"ids"`
+		Hash  map[string]json.RawMessage `json:"hash"`
+		Count int                        `json:"count"`
+	} `json:"presence"`
+}
+
+// NewServer starts and returns a new Server. The caller should call Close when
+// finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{conns: map[*conn]struct{}{}}
+	s.srv = httptest.NewServer(websocket.Handler(s.serve))
+	return s
+}
+
+// Close disconnects all clients and shuts down the server.
+func (s *Server) Close() {
+	s.DisconnectAll()
+	s.srv.Close()
+}
+
+// URL returns the websocket URL of the server.
+func (s *Server) URL() string {
+	return strings.Replace(s.srv.URL, "http", "ws", 1)
+}
+
+// Configure sets up client to connect to the server.
+func (s *Server) Configure(client *pusher.Client) {
+	u, _ := url.Parse(s.srv.URL)
+	host, port, _ := net.SplitHostPort(u.Host)
+	client.Insecure = true
+	client.OverrideHost = host
+	client.OverridePort, _ = strconv.Atoi(port)
+}
+
+// Connections returns the number of clients connected to the server.
+func (s *Server) Connections() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.conns)
+}
+
+// Subscribed reports whether any connected client is subscribed to channel.
+func (s *Server) Subscribed(channel string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.subscribersLocked(channel)) > 0
+}
+
+// ClientEvents returns the client events received by the server, in the order
+// they were received.
+func (s *Server) ClientEvents() []pusher.Event {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]pusher.Event(nil), s.clientEvents...)
+}
+
+// Trigger sends an event to all clients subscribed to channel. Its data is
+// marshalled to JSON and double-encoded, as Pusher does.
+func (s *Server) Trigger(channel, event string, data interface{}) error {
+	dataJSON, err := encodeData(data)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	conns := s.subscribersLocked(channel)
+	s.mutex.Unlock()
+
+	return sendAll(conns, pusher.Event{Event: event, Data: dataJSON, Channel: channel})
+}
+
+// Send sends event to all connected clients as is.
+func (s *Server) Send(event pusher.Event) error {
+	return sendAll(s.connections(), event)
+}
+
+// SendError sends a pusher:error event with code and message to all connected
+// clients.
+func (s *Server) SendError(code int, message string) error {
+	data, err := json.Marshal(pusher.EventError{Code: code, Message: message})
+	if err != nil {
+		return err
+	}
+	return s.Send(pusher.Event{Event: pusherError, Data: data})
+}
+
+// DisconnectAll closes the connections of all connected clients, as if they
+// had been lost.
+func (s *Server) DisconnectAll() {
+	for _, c := range s.connections() {
+		c.ws.Close()
+	}
+}
+
+func (s *Server) connections() []*conn {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// subscribersLocked returns the connections subscribed to channel. The mutex
+// must be held by the caller.
+func (s *Server) subscribersLocked(channel string) []*conn {
+	var conns []*conn
+	for c := range s.conns {
+		if _, ok := c.channels[channel]; ok {
+			conns = append(conns, c)
+		}
+	}
+	return conns
+}
+
+func (s *Server) serve(ws *websocket.Conn) {
+	s.mutex.Lock()
+	s.connCount++
+	c := &conn{
+		ws:       ws,
+		socketID: fmt.Sprintf("%d.%d", s.connCount, s.connCount),
+		channels: map[string]*member{},
+	}
+	s.conns[c] = struct{}{}
+	activityTimeout := s.ActivityTimeout
+	s.mutex.Unlock()
+
+	defer s.disconnect(c)
+
+	if activityTimeout <= 0 {
+		activityTimeout = defaultActivityTimeout
+	}
+	data, err := encodeData(connectionData{SocketID: c.socketID, ActivityTimeout: activityTimeout})
+	if err != nil {
+		return
+	}
+	if err := c.send(pusher.Event{Event: pusherConnEstablished, Data: data}); err != nil {
+		return
+	}
+
+	for {
+		var event pusher.Event
+		if err := websocket.JSON.Receive(ws, &event); err != nil {
+			return
+		}
+		s.handleEvent(c, event)
+	}
+}
+
+// disconnect removes c from the server, and removes it from the presence
+// channels it was a member of.
+func (s *Server) disconnect(c *conn) {
+	c.ws.Close()
+
+	s.mutex.Lock()
+	delete(s.conns, c)
+	channels := c.channels
+	c.channels = map[string]*member{}
+	s.mutex.Unlock()
+
+	for channel, m := range channels {
+		s.memberRemoved(channel, m)
+	}
+}
+
+func (s *Server) handleEvent(c *conn, event pusher.Event) {
+	switch {
+	case event.Event == pusherPing:
+		c.send(pusher.Event{Event: pusherPong, Data: json.RawMessage(`"{}"`)})
+	case event.Event == pusherSubscribe:
+		s.subscribe(c, event)
+	case event.Event == pusherUnsubscribe:
+		var data subscriptionData
+		if err := pusher.UnmarshalAuto(event.Data, &data); err != nil {
+			return
+		}
+		s.mutex.Lock()
+		m, ok := c.channels[data.Channel]
+		delete(c.channels, data.Channel)
+		s.mutex.Unlock()
+		if ok {
+			s.memberRemoved(data.Channel, m)
+		}
+	case strings.HasPrefix(event.Event, "client-"):
+		s.mutex.Lock()
+		s.clientEvents = append(s.clientEvents, event)
+		var others []*conn
+		if _, ok := c.channels[event.Channel]; ok {
+			for _, other := range s.subscribersLocked(event.Channel) {
+				if other != c {
+					others = append(others, other)
+				}
+			}
+		}
+		s.mutex.Unlock()
+
+		// Like Pusher, client events are only sent to other subscribers.
+		sendAll(others, event)
+	}
+}
+
+func (s *Server) subscribe(c *conn, event pusher.Event) {
+	var data subscriptionData
+	if err := pusher.UnmarshalAuto(event.Data, &data); err != nil {
+		return
+	}
+
+	if s.SubscriptionError != nil {
+		if err := s.SubscriptionError(data.Channel); err != nil {
+			errData, _ := json.Marshal(map[string]interface{}{
+				"type":   "AuthError",
+				"error":  err.Error(),
+				"status": 401,
+			})
+			c.send(pusher.Event{Event: pusherInternalSubError, Data: errData, Channel: data.Channel})
+			return
+		}
+	}
+
+	if !strings.HasPrefix(data.Channel, presencePrefix) {
+		s.mutex.Lock()
+		c.channels[data.Channel] = nil
+		s.mutex.Unlock()
+		c.send(pusher.Event{Event: pusherInternalSubSucceeded, Data: json.RawMessage(`"{}"`), Channel: data.Channel})
+		return
+	}
+
+	m := &member{}
+	if err := pusher.UnmarshalAuto(data.ChannelData, m); err != nil {
+		c.send(pusher.Event{Event: pusherInternalSubError, Data: json.RawMessage(`{"type":"InvalidChannelData","error":"invalid channel data","status":400}`), Channel: data.Channel})
+		return
+	}
+
+	s.mutex.Lock()
+	c.channels[data.Channel] = m
+	var pd presenceData
+	pd.Presence.Hash = map[string]json.RawMessage{}
+	var others []*conn
+	alreadyPresent := false
+	for _, sub := range s.subscribersLocked(data.Channel) {
+		subMember := sub.channels[data.Channel]
+		if _, ok := pd.Presence.Hash[subMember.UserID]; !ok {
+			pd.Presence.IDs = append(pd.Presence.IDs, subMember.UserID)
+		}
+		pd.Presence.Hash[subMember.UserID] = subMember.UserInfo
+		if sub != c {
+			others = append(others, sub)
+			alreadyPresent = alreadyPresent || subMember.UserID == m.UserID
+		}
+	}
+	pd.Presence.Count = len(pd.Presence.IDs)
+	s.mutex.Unlock()
+
+	subData, err := encodeData(pd)
+	if err != nil {
+		return
+	}
+	c.send(pusher.Event{Event: pusherInternalSubSucceeded, Data: subData, Channel: data.Channel})
+
+	if alreadyPresent {
+		return
+	}
+	addedData, err := encodeData(m)
+	if err != nil {
+		return
+	}
+	sendAll(others, pusher.Event{Event: pusherInternalMemberAdded, Data: addedData, Channel: data.Channel})
+}
+
+// memberRemoved notifies the remaining subscribers of a presence channel that
+// m has left it. It does nothing if m is nil, as for other channel types.
+func (s *Server) memberRemoved(channel string, m *member) {
+	if m == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	conns := s.subscribersLocked(channel)
+	for _, c := range conns {
+		// Another connection of the same user keeps the member present.
+		if c.channels[channel].UserID == m.UserID {
+			s.mutex.Unlock()
+			return
+		}
+	}
+	s.mutex.Unlock()
+
+	data, err := encodeData(member{UserID: m.UserID})
+	if err != nil {
+		return
+	}
+	sendAll(conns, pusher.Event{Event: pusherInternalMemberRemoved, Data: data, Channel: channel})
+}
+
+func (c *conn) send(event pusher.Event) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	return websocket.JSON.Send(c.ws, event)
+}
+
+// sendAll sends event to each of conns, returning ErrNoConnections if there
+// are none.
+func sendAll(conns []*conn, event pusher.Event) error {
+	if len(conns) == 0 {
+		return fmt.Errorf("sending %q event: %w", event.Event, ErrNoConnections)
+	}
+
+	var errs []error
+	for _, c := range conns {
+		if err := c.send(event); err != nil {
+			errs = append(errs, fmt.Errorf("sending %q event to socket %s: %w", event.Event, c.socketID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// encodeData marshals data to JSON and encodes the result as a JSON string,
+// following Pusher's convention for event data.
+func encodeData(data interface{}) (json.RawMessage, error) {
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("encoding event data: %w", err)
+	}
+	return json.Marshal(string(dataJSON))
+}
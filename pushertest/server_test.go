@@ -0,0 +1,222 @@
+package pushertest_test
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	pusher "github.com/bencurio/pusher-ws-go"
+	"github.com/bencurio/pusher-ws-go/pushertest"
+)
+
+func newTestClient(t *testing.T, srv *pushertest.Server) *pusher.Client {
+	t.Helper()
+
+	client := &pusher.Client{Errors: make(chan error, 10)}
+	srv.Configure(client)
+	if err := client.Connect("foo"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { client.Disconnect() })
+	return client
+}
+
+func TestServerTrigger(t *testing.T) {
+	srv := pushertest.NewServer()
+	defer srv.Close()
+	client := newTestClient(t, srv)
+
+	ch, err := client.Subscribe("foo")
+	if err != nil {
+		t.Fatalf("Expected error to be `nil`, got %v", err)
+	}
+	if !srv.Subscribed("foo") {
+		t.Error("Expected server to record the subscription")
+	}
+	dataChan := ch.Bind("bar")
+
+	if err := srv.Trigger("foo", "bar", map[string]string{"baz": "A"}); err != nil {
+		t.Fatalf("Expected error to be `nil`, got %v", err)
+	}
+
+	select {
+	case data := <-dataChan:
+		got := map[string]string{}
+		if err := pusher.UnmarshalDataString(data, &got); err != nil {
+			t.Fatalf("Expected error to be `nil`, got %v", err)
+		}
+		if want := map[string]string{"baz": "A"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected data %+v, got %+v", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected to receive the triggered event")
+	}
+
+	err = srv.Trigger("unsubscribed", "bar", nil)
+	if !errors.Is(err, pushertest.ErrNoConnections) {
+		t.Errorf("Expected ErrNoConnections, got %v", err)
+	}
+}
+
+func TestServerSendError(t *testing.T) {
+	srv := pushertest.NewServer()
+	defer srv.Close()
+	client := newTestClient(t, srv)
+
+	if err := srv.SendError(4100, "over capacity"); err != nil {
+		t.Fatalf("Expected error to be `nil`, got %v", err)
+	}
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case err := <-client.Errors:
+			var eventErr pusher.EventError
+			if errors.As(err, &eventErr) {
+				if eventErr.Code != 4100 || eventErr.Message != "over capacity" {
+					t.Errorf("Expected code 4100 and message %q, got %+v", "over capacity", eventErr)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("Expected to receive the error")
+		}
+	}
+}
+
+func TestServerSubscriptionError(t *testing.T) {
+	srv := pushertest.NewServer()
+	srv.SubscriptionError = func(channel string) error {
+		return errors.New("forbidden")
+	}
+	defer srv.Close()
+	client := newTestClient(t, srv)
+
+	_, err := client.Subscribe("private-foo", pusher.WithAuth("sig", ""))
+	var subErr pusher.SubscriptionError
+	if !errors.As(err, &subErr) {
+		t.Fatalf("Expected SubscriptionError, got %v", err)
+	}
+	if subErr.Message != "forbidden" {
+		t.Errorf("Expected message %q, got %q", "forbidden", subErr.Message)
+	}
+	if srv.Subscribed("private-foo") {
+		t.Error("Expected server not to record the rejected subscription")
+	}
+}
+
+func TestServerPresence(t *testing.T) {
+	srv := pushertest.NewServer()
+	defer srv.Close()
+	alice := newTestClient(t, srv)
+	bob := newTestClient(t, srv)
+
+	aliceCh, err := alice.SubscribePresence("presence-foo",
+		pusher.WithAuth("sig", ""), pusher.WithPresenceData("alice", nil))
+	if err != nil {
+		t.Fatalf("Expected error to be `nil`, got %v", err)
+	}
+	added := aliceCh.BindMemberAdded()
+	removed := aliceCh.BindMemberRemoved()
+
+	bobCh, err := bob.SubscribePresence("presence-foo",
+		pusher.WithAuth("sig", ""), pusher.WithPresenceData("bob", map[string]string{"name": "Bob"}))
+	if err != nil {
+		t.Fatalf("Expected error to be `nil`, got %v", err)
+	}
+	if members := bobCh.Members(); len(members) != 2 {
+		t.Errorf("Expected 2 members, got %+v", members)
+	}
+
+	select {
+	case member := <-added:
+		if member.ID != "bob" || !json.Valid(member.Info) {
+			t.Errorf("Expected member bob with info, got %+v", member)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected member added event")
+	}
+
+	bob.Disconnect()
+
+	select {
+	case id := <-removed:
+		if id != "bob" {
+			t.Errorf("Expected member bob to be removed, got %s", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected member removed event")
+	}
+}
+
+func TestServerClientEvents(t *testing.T) {
+	srv := pushertest.NewServer()
+	defer srv.Close()
+	sender := newTestClient(t, srv)
+	receiver := newTestClient(t, srv)
+
+	senderCh, err := sender.Subscribe("private-foo", pusher.WithAuth("sig", ""))
+	if err != nil {
+		t.Fatalf("Expected error to be `nil`, got %v", err)
+	}
+	receiverCh, err := receiver.Subscribe("private-foo", pusher.WithAuth("sig", ""))
+	if err != nil {
+		t.Fatalf("Expected error to be `nil`, got %v", err)
+	}
+	dataChan := receiverCh.Bind("client-bar")
+
+	if err := senderCh.Trigger("client-bar", "baz"); err != nil {
+		t.Fatalf("Expected error to be `nil`, got %v", err)
+	}
+
+	select {
+	case <-dataChan:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the client event to be forwarded to other subscribers")
+	}
+
+	events := srv.ClientEvents()
+	if len(events) != 1 || events[0].Event != "client-bar" || events[0].Channel != "private-foo" {
+		t.Errorf("Expected the client event to be recorded, got %+v", events)
+	}
+}
+
+func TestServerDisconnectAll(t *testing.T) {
+	srv := pushertest.NewServer()
+	defer srv.Close()
+
+	client := &pusher.Client{
+		Errors:                make(chan error, 10),
+		InitialReconnectDelay: 10 * time.Millisecond,
+	}
+	srv.Configure(client)
+	if err := client.Connect("foo"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+	if _, err := client.Subscribe("foo"); err != nil {
+		t.Fatalf("Expected error to be `nil`, got %v", err)
+	}
+	oldSocketID := client.SocketID()
+
+	srv.DisconnectAll()
+
+	timeout := time.After(5 * time.Second)
+	for reconnected := false; !reconnected; {
+		select {
+		case err := <-client.Errors:
+			reconnected = err.Error() == "reconnection successful"
+		case <-timeout:
+			t.Fatal("Timeout waiting for reconnection")
+		}
+	}
+
+	if client.SocketID() == oldSocketID {
+		t.Errorf("Expected a new socket ID after reconnecting, got %s", oldSocketID)
+	}
+	if !srv.Subscribed("foo") || srv.Connections() != 1 {
+		t.Errorf("Expected the client to reconnect and resubscribe, got %d connections", srv.Connections())
+	}
+}
@@ -0,0 +1,129 @@
+package pusher
+
+import "sync"
+
+// orderedQueue delivers values to a channel in the order they were pushed. A
+// single worker goroutine performs the delivery, so pushing never blocks on a
+// slow receiver.
+type orderedQueue[T any] struct {
+	mutex  sync.Mutex
+	items  []T
+	notify chan struct{}
+	done   chan struct{}
+	// pending counts the values that have been pushed but not yet delivered
+	// or discarded.
+	pending *deliveryCounter
+}
+
+// newOrderedQueue starts a queue that delivers values to out until done is
+// closed. Values that haven't been delivered by then are discarded. If pending
+// isn't nil, values are counted in it until they are delivered or discarded.
+func newOrderedQueue[T any](out chan T, done chan struct{}, pending *deliveryCounter) *orderedQueue[T] {
+	q := &orderedQueue[T]{
+		notify:  make(chan struct{}, 1),
+		done:    done,
+		pending: pending,
+	}
+	go q.run(out)
+	return q
+}
+
+func (q *orderedQueue[T]) push(v T) {
+	q.mutex.Lock()
+	q.items = append(q.items, v)
+	q.pending.add(1)
+	q.mutex.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *orderedQueue[T]) run(out chan T) {
+	for {
+		select {
+		case <-q.notify:
+		case <-q.done:
+			q.discard(0)
+			return
+		}
+
+		q.mutex.Lock()
+		items := q.items
+		q.items = nil
+		q.mutex.Unlock()
+
+		for i, v := range items {
+			select {
+			case out <- v:
+				q.pending.add(-1)
+			case <-q.done:
+				q.discard(len(items) - i)
+				return
+			}
+		}
+	}
+}
+
+// discard discards the queued values once the queue is stopped, along with n
+// values that were taken from the queue but not delivered.
+func (q *orderedQueue[T]) discard(n int) {
+	q.mutex.Lock()
+	n += len(q.items)
+	q.items = nil
+	q.mutex.Unlock()
+
+	q.pending.add(-n)
+}
+
+// deliver runs f in a new goroutine, which is counted in pending until f
+// returns.
+func deliver(pending *deliveryCounter, f func()) {
+	pending.add(1)
+	go func() {
+		defer pending.add(-1)
+		f()
+	}()
+}
+
+// deliveryCounter counts deliveries in progress. Unlike a sync.WaitGroup, it
+// can be waited on with a timeout and reused while a wait is abandoned. A nil
+// deliveryCounter counts nothing.
+type deliveryCounter struct {
+	mutex sync.Mutex
+	count int
+	// idleChan is closed when count drops to zero. It's created by idle.
+	idleChan chan struct{}
+}
+
+func (p *deliveryCounter) add(n int) {
+	if p == nil || n == 0 {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.count += n
+	if p.count == 0 && p.idleChan != nil {
+		close(p.idleChan)
+		p.idleChan = nil
+	}
+}
+
+// idle returns a channel that is closed once no deliveries are in progress.
+func (p *deliveryCounter) idle() <-chan struct{} {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.count == 0 {
+		idle := make(chan struct{})
+		close(idle)
+		return idle
+	}
+	if p.idleChan == nil {
+		p.idleChan = make(chan struct{})
+	}
+	return p.idleChan
+}
@@ -0,0 +1,103 @@
+package pusher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOrderedQueue(t *testing.T) {
+	t.Run("order", func(t *testing.T) {
+		out := make(chan int)
+		done := make(chan struct{})
+		defer close(done)
+		q := newOrderedQueue(out, done, nil)
+
+		for i := 0; i < 100; i++ {
+			q.push(i)
+		}
+
+		for want := 0; want < 100; want++ {
+			select {
+			case got := <-out:
+				if got != want {
+					t.Fatalf("Expected to receive %d, got %d", want, got)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Expected to receive %d", want)
+			}
+		}
+	})
+
+	t.Run("pending", func(t *testing.T) {
+		out := make(chan int)
+		done := make(chan struct{})
+		pending := &deliveryCounter{}
+		q := newOrderedQueue(out, done, pending)
+
+		q.push(1)
+		q.push(2)
+		idle := pending.idle()
+		<-out
+
+		select {
+		case <-idle:
+			t.Fatal("Expected a value to still be pending")
+		default:
+		}
+
+		close(done)
+		select {
+		case <-idle:
+		case <-time.After(time.Second):
+			t.Fatal("Expected discarded values not to be pending")
+		}
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		out := make(chan int)
+		done := make(chan struct{})
+		q := newOrderedQueue(out, done, nil)
+
+		q.push(1)
+		close(done)
+		q.push(2)
+
+		select {
+		case got := <-out:
+			// The first value may have been delivered before the queue stopped
+			if got != 1 {
+				t.Errorf("Expected no values after stopping, got %d", got)
+			}
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+}
+
+func TestDeliveryCounter(t *testing.T) {
+	var pending deliveryCounter
+	select {
+	case <-pending.idle():
+	default:
+		t.Fatal("Expected a new counter to be idle")
+	}
+
+	release := make(chan struct{})
+	deliver(&pending, func() { <-release })
+	idle := pending.idle()
+	select {
+	case <-idle:
+		t.Fatal("Expected the counter not to be idle during a delivery")
+	default:
+	}
+
+	close(release)
+	select {
+	case <-idle:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the counter to be idle once the delivery returned")
+	}
+
+	// A nil counter counts nothing
+	var nilCounter *deliveryCounter
+	nilCounter.add(1)
+}
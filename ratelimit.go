@@ -0,0 +1,106 @@
+package pusher
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned by SendEvent when sending a client event would
+// exceed ClientEventRate and BlockOnRateLimit is not set.
+var ErrRateLimited = errors.New("client event rate limit exceeded")
+
+// rateLimiter is a token bucket that allows up to rate events per second, with
+// bursts of up to one second's worth of events.
+type rateLimiter struct {
+	mutex  sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, now time.Time) *rateLimiter {
+	return &rateLimiter{rate: rate, tokens: rate, last: now}
+}
+
+// reserve takes a token from the bucket. If none is available and wait is
+// false, it returns false without taking one. Otherwise it returns how long
+// the caller must wait before the token it has taken becomes available.
+func (l *rateLimiter) reserve(now time.Time, wait bool) (time.Duration, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+
+	if l.tokens < 1 && !wait {
+		return 0, false
+	}
+
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0, true
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second)), true
+}
+
+// reset refills the bucket. Events already waiting for a token still wait
+// for it.
+func (l *rateLimiter) reset(now time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.tokens = l.rate
+	l.last = now
+}
+
+// limitClientEvent applies ClientEventRate to event, either waiting until it
+// may be sent or returning ErrRateLimited. Events other than client events are
+// not limited.
+func (c *Client) limitClientEvent(event string, block bool) error {
+	if !strings.HasPrefix(event, "client-") {
+		return nil
+	}
+
+	c.mutex.RLock()
+	limiter, closed := c.limiter, c.closed
+	c.mutex.RUnlock()
+	if c.ClientEventRate < 0 {
+		return nil
+	}
+	if limiter == nil {
+		// The write lock is only taken to create the limiter, so that sending
+		// doesn't wait for the read loop.
+		c.mutex.Lock()
+		if c.limiter == nil {
+			rate := float64(c.ClientEventRate)
+			if rate == 0 {
+				rate = defaultClientEventRate
+			}
+			c.limiter = newRateLimiter(rate, c.now())
+		}
+		limiter = c.limiter
+		c.mutex.Unlock()
+	}
+
+	delay, ok := limiter.reserve(c.now(), block)
+	if !ok {
+		return ErrRateLimited
+	}
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := c.newTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return nil
+	case <-closed:
+		return ErrNotConnected
+	}
+}
@@ -0,0 +1,132 @@
+package pusher
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	start := time.Now()
+	limiter := &rateLimiter{rate: 2, tokens: 2, last: start}
+
+	for i := 0; i < 2; i++ {
+		if delay, ok := limiter.reserve(start, false); !ok || delay != 0 {
+			t.Errorf("Expected token %d to be available, got %v, %v", i, delay, ok)
+		}
+	}
+	if _, ok := limiter.reserve(start, false); ok {
+		t.Error("Expected no token to be available once the burst is used")
+	}
+	if delay, ok := limiter.reserve(start, true); !ok || delay != 500*time.Millisecond {
+		t.Errorf("Expected to wait 500ms for a token, got %v, %v", delay, ok)
+	}
+	if delay, ok := limiter.reserve(start.Add(time.Second), false); !ok || delay != 0 {
+		t.Errorf("Expected a token to be available after refilling, got %v, %v", delay, ok)
+	}
+}
+
+func TestClientEventRate(t *testing.T) {
+	newClient := func(t *testing.T, rate int, block bool) *Client {
+		srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			var msg string
+			for websocket.Message.Receive(ws, &msg) == nil {
+			}
+		}))
+		t.Cleanup(srv.Close)
+		wsURL := strings.Replace(srv.URL, "http", "ws", 1)
+		ws, err := websocket.Dial(wsURL, "ws", localOrigin)
+		if err != nil {
+			panic(err)
+		}
+
+		client := &Client{
+			ws:                 websocketConn{ws},
+			connected:          true,
+			activityTimerReset: make(chan struct{}, 1),
+			ClientEventRate:    rate,
+			BlockOnRateLimit:   block,
+		}
+		t.Cleanup(func() { client.Disconnect() })
+		return client
+	}
+
+	t.Run("default", func(t *testing.T) {
+		client := newClient(t, 0, false)
+		for i := 0; i < defaultClientEventRate; i++ {
+			if err := client.SendEvent("client-foo", "bar", "baz"); err != nil {
+				t.Fatalf("Expected event %d to be sent, got %v", i, err)
+			}
+		}
+		if err := client.SendEvent("client-foo", "bar", "baz"); err != ErrRateLimited {
+			t.Errorf("Expected error %v, got %v", ErrRateLimited, err)
+		}
+		if err := client.SendEvent(pusherSubscribe, channelData{Channel: "baz"}, ""); err != nil {
+			t.Errorf("Expected protocol events not to be limited, got %v", err)
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+		client := newClient(t, 20, true)
+		start := time.Now()
+		for i := 0; i < 22; i++ {
+			if err := client.SendEvent("client-foo", "bar", "baz"); err != nil {
+				t.Fatalf("Expected event %d to be sent, got %v", i, err)
+			}
+		}
+		if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+			t.Errorf("Expected sending to wait for the rate limit, took %v", elapsed)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		client := newClient(t, -1, false)
+		for i := 0; i < 2*defaultClientEventRate; i++ {
+			if err := client.SendEvent("client-foo", "bar", "baz"); err != nil {
+				t.Fatalf("Expected event %d to be sent, got %v", i, err)
+			}
+		}
+	})
+}
+
+func TestClientEventRateReconnect(t *testing.T) {
+	// The clock doesn't move, so the bucket is only refilled by reconnecting.
+	client := &Client{
+		Dial: func(url, origin string) (Conn, error) {
+			conn := newConnectedFakeConn(t, "foo", 120)
+			go func() {
+				for {
+					select {
+					case <-conn.sent:
+					case <-conn.closed:
+						return
+					}
+				}
+			}()
+			return conn, nil
+		},
+		clock: newFakeClock(),
+	}
+	if err := client.Connect("foo"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		for i := 0; i < defaultClientEventRate; i++ {
+			if err := client.SendEvent("client-foo", "bar", "baz"); err != nil {
+				t.Fatalf("Expected event %d to be sent on connection %d, got %v", i, attempt, err)
+			}
+		}
+		if err := client.SendEvent("client-foo", "bar", "baz"); err != ErrRateLimited {
+			t.Errorf("Expected error %v on connection %d, got %v", ErrRateLimited, attempt, err)
+		}
+
+		if err := client.Reconnect(); err != nil {
+			t.Fatalf("Failed to reconnect: %v", err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package pusher
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// ErrCertificatePinMismatch is returned when connecting if none of the
+// certificates presented by the server match PinnedCertFingerprints.
+var ErrCertificatePinMismatch = errors.New("server certificate does not match any pinned fingerprint")
+
+// tlsConfig returns the TLS configuration used for secure connections. It is
+// a copy of TLSConfig, with client certificates and certificate pinning added
+// if they are configured.
+func (c *Client) tlsConfig() *tls.Config {
+	tlsConfig := &tls.Config{}
+	if c.TLSConfig != nil {
+		tlsConfig = c.TLSConfig.Clone()
+	}
+
+	if len(c.ClientCertificates) > 0 {
+		tlsConfig.Certificates = c.ClientCertificates
+	}
+	if c.GetClientCertificate != nil {
+		tlsConfig.GetClientCertificate = c.GetClientCertificate
+	}
+
+	if len(c.PinnedCertFingerprints) > 0 {
+		pins := make(map[string]struct{}, len(c.PinnedCertFingerprints))
+		for _, fingerprint := range c.PinnedCertFingerprints {
+			pins[normalizeFingerprint(fingerprint)] = struct{}{}
+		}
+
+		verify := tlsConfig.VerifyPeerCertificate
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			if verify != nil {
+				if err := verify(rawCerts, verifiedChains); err != nil {
+					return err
+				}
+			}
+			return verifyPinnedCertificate(rawCerts, pins)
+		}
+	}
+
+	return tlsConfig
+}
+
+// verifyPinnedCertificate checks that one of the certificates presented by the
+// server has a SHA-256 fingerprint in pins.
+func verifyPinnedCertificate(rawCerts [][]byte, pins map[string]struct{}) error {
+	for _, rawCert := range rawCerts {
+		sum := sha256.Sum256(rawCert)
+		if _, ok := pins[hex.EncodeToString(sum[:])]; ok {
+			return nil
+		}
+	}
+	return ErrCertificatePinMismatch
+}
+
+// normalizeFingerprint converts a hex fingerprint to lower case without colon
+// separators, so that fingerprints copied from common tools can be compared.
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+}
@@ -0,0 +1,207 @@
+package pusher
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// tlsTestHandler establishes a connection and then reads until it is closed.
+var tlsTestHandler = websocket.Handler(func(ws *websocket.Conn) {
+	websocket.JSON.Send(ws, connEstablishedEvent("foo", 120))
+	var event Event
+	for websocket.JSON.Receive(ws, &event) == nil {
+	}
+})
+
+func TestClientPinnedCertFingerprints(t *testing.T) {
+	srv := httptest.NewTLSServer(tlsTestHandler)
+	defer srv.Close()
+
+	sum := sha256.Sum256(srv.Certificate().Raw)
+	fingerprint := hex.EncodeToString(sum[:])
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	host, port, _ := getServerHostPort(srv)
+
+	testCases := []struct {
+		name    string
+		pins    []string
+		wantErr error
+	}{
+		{"noPins", nil, nil},
+		{"match", []string{"00", fingerprint}, nil},
+		{"matchUpperCaseWithColons", []string{colonSeparated(strings.ToUpper(fingerprint))}, nil},
+		{"mismatch", []string{strings.Repeat("00", sha256.Size)}, ErrCertificatePinMismatch},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &Client{
+				OverrideHost:           host,
+				OverridePort:           port,
+				TLSConfig:              &tls.Config{RootCAs: roots},
+				PinnedCertFingerprints: tc.pins,
+			}
+			defer client.Disconnect()
+
+			err := client.Connect("foo")
+			if tc.wantErr == nil && err != nil {
+				t.Errorf("Expected to connect, got %v", err)
+			}
+			if tc.wantErr != nil && !errors.Is(err, tc.wantErr) {
+				t.Errorf("Expected error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+
+	t.Run("chainVerificationStillApplies", func(t *testing.T) {
+		client := &Client{
+			OverrideHost:           host,
+			OverridePort:           port,
+			PinnedCertFingerprints: []string{fingerprint},
+		}
+		defer client.Disconnect()
+
+		if err := client.Connect("foo"); err == nil {
+			t.Error("Expected connecting to a server with an untrusted certificate to fail")
+		}
+	})
+}
+
+func TestClientTLSConfig(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		client := &Client{}
+		if tlsConfig := client.tlsConfig(); tlsConfig == nil || tlsConfig.VerifyPeerCertificate != nil {
+			t.Errorf("Expected an empty TLS config, got %+v", tlsConfig)
+		}
+	})
+
+	t.Run("copiesConfig", func(t *testing.T) {
+		base := &tls.Config{ServerName: "foo"}
+		client := &Client{TLSConfig: base, PinnedCertFingerprints: []string{"00"}}
+		tlsConfig := client.tlsConfig()
+		if tlsConfig == base {
+			t.Error("Expected TLSConfig to be copied")
+		}
+		if tlsConfig.ServerName != "foo" {
+			t.Errorf("Expected server name %q, got %q", "foo", tlsConfig.ServerName)
+		}
+		if base.VerifyPeerCertificate != nil {
+			t.Error("Expected TLSConfig not to be modified")
+		}
+	})
+}
+
+func TestClientCertificates(t *testing.T) {
+	clientCert, clientX509 := newTestClientCertificate(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientX509)
+
+	srv := httptest.NewUnstartedServer(tlsTestHandler)
+	srv.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	host, port, _ := getServerHostPort(srv)
+
+	t.Run("certificates", func(t *testing.T) {
+		client := &Client{
+			OverrideHost:       host,
+			OverridePort:       port,
+			TLSConfig:          &tls.Config{RootCAs: roots},
+			ClientCertificates: []tls.Certificate{clientCert},
+		}
+		defer client.Disconnect()
+
+		if err := client.Connect("foo"); err != nil {
+			t.Errorf("Expected to connect, got %v", err)
+		}
+	})
+
+	t.Run("getClientCertificate", func(t *testing.T) {
+		called := false
+		client := &Client{
+			OverrideHost: host,
+			OverridePort: port,
+			TLSConfig:    &tls.Config{RootCAs: roots},
+			GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				called = true
+				return &clientCert, nil
+			},
+		}
+		defer client.Disconnect()
+
+		if err := client.Connect("foo"); err != nil {
+			t.Errorf("Expected to connect, got %v", err)
+		}
+		if !called {
+			t.Error("Expected GetClientCertificate to be called")
+		}
+	})
+
+	t.Run("missingCertificate", func(t *testing.T) {
+		client := &Client{
+			OverrideHost: host,
+			OverridePort: port,
+			TLSConfig:    &tls.Config{RootCAs: roots},
+		}
+		defer client.Disconnect()
+
+		if err := client.Connect("foo"); err == nil {
+			t.Error("Expected connecting without a client certificate to fail")
+		}
+	})
+}
+
+// newTestClientCertificate returns a self-signed certificate for client
+// authentication.
+func newTestClientCertificate(t *testing.T) (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, cert
+}
+
+func colonSeparated(fingerprint string) string {
+	pairs := make([]string, 0, len(fingerprint)/2)
+	for i := 0; i < len(fingerprint); i += 2 {
+		pairs = append(pairs, fingerprint[i:i+2])
+	}
+	return strings.Join(pairs, ":")
+}